@@ -4,9 +4,14 @@
 package boxcli
 
 import (
+	"cmp"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 
 	"go.jetpack.io/devbox/internal/boxcli/usererr"
@@ -19,6 +24,8 @@
 
 type addCmdFlags struct {
 	config           configFlags
+	dryRun           bool
+	force            bool
 	allowInsecure    []string
 	disablePlugin    bool
 	platforms        []string
@@ -26,9 +33,18 @@ type addCmdFlags struct {
 	patchGlibc       bool
 	patch            string
 	outputs          []string
+	priority         int
+	keepGoing        bool
+	noValidate       bool
+	json             bool
+	verbose          bool
+	offline          offlineFlag
+	timings          timingsFlag
 }
 
-func addCmd() *cobra.Command {
+// addCmd returns the add command. When global is true, it's the `devbox
+// global add` command, which can also print a plan with --dry-run.
+func addCmd(global bool) *cobra.Command {
 	flags := addCmdFlags{}
 
 	command := &cobra.Command{
@@ -54,6 +70,8 @@ func addCmd() *cobra.Command {
 	}
 
 	flags.config.register(command)
+	flags.offline.register(command)
+	flags.timings.register(command)
 	command.Flags().StringSliceVar(
 		&flags.allowInsecure, "allow-insecure", []string{},
 		"allow adding packages marked as insecure.")
@@ -75,6 +93,36 @@ func addCmd() *cobra.Command {
 	command.Flags().StringSliceVarP(
 		&flags.outputs, "outputs", "o", []string{},
 		"specify the outputs to select for the nix package")
+	command.Flags().IntVar(
+		&flags.priority, "priority", 0,
+		"nix profile priority for the package, 0 or higher. Lower values win when packages provide the same file")
+	command.Flags().BoolVar(
+		&flags.keepGoing, "keep-going", false,
+		"skip packages that can't be added instead of failing")
+	command.Flags().BoolVar(
+		&flags.json, "json", false,
+		"print a JSON report of the packages that failed to add and exit with an error")
+
+	if global {
+		command.Flags().BoolVar(
+			&flags.dryRun, "dry-run", false,
+			"print whether each package is already installed, its resolved commit and "+
+				"whether it's valid, without installing anything. Use with --json for a "+
+				"machine-readable plan")
+		command.MarkFlagsMutuallyExclusive("dry-run", "keep-going")
+		command.Flags().BoolVar(
+			&flags.noValidate, "no-validate", false,
+			"skip checking that each package exists before installing it, which is faster "+
+				"for large adds. A misspelled package fails with an error from nix instead")
+		command.MarkFlagsMutuallyExclusive("dry-run", "no-validate")
+		command.Flags().BoolVar(
+			&flags.force, "force", false,
+			"reinstall packages that are already in the global config and profile")
+		command.MarkFlagsMutuallyExclusive("dry-run", "force")
+		command.Flags().BoolVar(
+			&flags.verbose, "verbose", false,
+			"print nix's own output, including build logs, to help diagnose slow or failing installs")
+	}
 
 	_ = command.Flags().MarkDeprecated("patch-glibc", `use --patch=always instead`)
 	command.MarkFlagsMutuallyExclusive("patch", "patch-glibc")
@@ -83,14 +131,20 @@ func addCmd() *cobra.Command {
 }
 
 func addCmdFunc(cmd *cobra.Command, args []string, flags addCmdFlags) error {
+	if err := flags.offline.apply(); err != nil {
+		return errors.WithStack(err)
+	}
 	box, err := devbox.Open(&devopt.Opts{
 		Dir:         flags.config.path,
 		Environment: flags.config.environment,
 		Stderr:      cmd.ErrOrStderr(),
+		Timings:     flags.timings.timings,
+		Verbose:     flags.verbose,
 	})
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	defer box.PrintTimings()
 
 	opts := devopt.AddOpts{
 		AllowInsecure:    flags.allowInsecure,
@@ -99,10 +153,80 @@ func addCmdFunc(cmd *cobra.Command, args []string, flags addCmdFlags) error {
 		ExcludePlatforms: flags.excludePlatforms,
 		Patch:            flags.patch,
 		Outputs:          flags.outputs,
+		KeepGoing:        flags.keepGoing,
+		ErrorOnSkipped:   flags.json,
+		SkipValidation:   flags.noValidate,
+		Force:            flags.force,
+	}
+	if cmd.Flags().Changed("priority") {
+		// Zero is a valid priority, so only set it if the user passed one.
+		opts.Priority = &flags.priority
 	}
 	if flags.patchGlibc {
 		// Backwards compatibility so --patch-glibc still works.
 		opts.Patch = "always"
 	}
-	return box.Add(cmd.Context(), args, opts)
+	if flags.dryRun {
+		return printAddPlan(cmd, box, args, opts, flags.json)
+	}
+	err = box.Add(cmd.Context(), args, opts)
+	var addErr *devbox.AddError
+	if flags.json && errors.As(err, &addErr) {
+		out, jsonErr := json.MarshalIndent(addErr.Failures, "", "  ")
+		if jsonErr != nil {
+			return errors.WithStack(jsonErr)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(out))
+	}
+	return err
+}
+
+// printAddPlan prints what `devbox global add` would do with each of args,
+// as a table or as JSON. It returns an error if any of the packages are
+// invalid, so that scripts can check the exit code.
+func printAddPlan(
+	cmd *cobra.Command,
+	box *devbox.Devbox,
+	args []string,
+	opts devopt.AddOpts,
+	asJSON bool,
+) error {
+	plan, err := box.PlanAddGlobal(cmd.Context(), args, opts)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		out, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(out))
+	} else {
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PACKAGE\tNAME\tINSTALLED\tCOMMIT\tSTATUS")
+		for _, p := range plan {
+			status := "ok"
+			if !p.Valid {
+				status = p.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+				p.Package,
+				cmp.Or(p.Name, "-"),
+				lo.Ternary(p.Installed, "yes", "no"),
+				cmp.Or(p.Commit, "-"),
+				status,
+			)
+		}
+		if err := tw.Flush(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	invalid := lo.FilterMap(plan, func(p devbox.PlannedPackage, _ int) (string, bool) {
+		return p.Package, !p.Valid
+	})
+	if len(invalid) > 0 {
+		return usererr.New("These packages can't be added: %s", strings.Join(invalid, ", "))
+	}
+	return nil
 }
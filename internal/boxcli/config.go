@@ -4,7 +4,11 @@
 package boxcli
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
+
+	"go.jetpack.io/devbox/internal/envir"
 )
 
 // to be composed into xyzCmdFlags structs
@@ -43,3 +47,35 @@ func (flags *pathFlag) registerPersistent(cmd *cobra.Command) {
 		&flags.path, "config", "c", "", "path to directory containing a devbox.json config file",
 	)
 }
+
+// offlineFlag makes devbox rely only on devbox.lock and the local nix store.
+type offlineFlag struct {
+	offline bool
+}
+
+func (flags *offlineFlag) register(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&flags.offline, "offline", false,
+		"only use packages pinned in devbox.lock and already in the local nix store",
+	)
+}
+
+type timingsFlag struct {
+	timings bool
+}
+
+func (flags *timingsFlag) register(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&flags.timings, "timings", false,
+		"print how long each phase of the command took, such as validating and installing packages",
+	)
+}
+
+// apply sets DEVBOX_OFFLINE so that nix and any devbox subprocesses see it
+// too.
+func (flags *offlineFlag) apply() error {
+	if !flags.offline {
+		return nil
+	}
+	return os.Setenv(envir.DevboxOffline, "1")
+}
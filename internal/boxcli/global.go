@@ -4,13 +4,29 @@
 package boxcli
 
 import (
+	"cmp"
+	"encoding/json"
 	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/mattn/go-isatty"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/devbox"
 	"go.jetpack.io/devbox/internal/devbox/devopt"
+	"go.jetpack.io/devbox/internal/devconfig"
+	"go.jetpack.io/devbox/internal/devconfig/configfile"
+	"go.jetpack.io/devbox/internal/shenv"
 	"go.jetpack.io/devbox/internal/ux"
 )
 
@@ -28,25 +44,429 @@ func globalCmd() *cobra.Command {
 		PersistentPostRunE: ensureGlobalEnvEnabled,
 	}
 
-	addCommandAndHideConfigFlag(globalCmd, addCmd())
+	addCommandAndHideConfigFlag(globalCmd, addCmd(true))
 	addCommandAndHideConfigFlag(globalCmd, installCmd())
+	addCommandAndHideConfigFlag(globalCmd, lockCmd())
 	addCommandAndHideConfigFlag(globalCmd, pathCmd())
 	addCommandAndHideConfigFlag(globalCmd, pullCmd())
 	addCommandAndHideConfigFlag(globalCmd, pushCmd())
-	addCommandAndHideConfigFlag(globalCmd, removeCmd())
+	globalRemoveCmd := removeCmd(true)
+	globalRemoveCmd.ValidArgsFunction = completeGlobalPackages
+	addCommandAndHideConfigFlag(globalCmd, globalRemoveCmd)
 	addCommandAndHideConfigFlag(globalCmd, runCmd(runFlagDefaults{
 		omitNixEnv: true,
 	}))
 	addCommandAndHideConfigFlag(globalCmd, servicesCmd(persistentPreRunE))
 	addCommandAndHideConfigFlag(globalCmd, shellEnvCmd(shellenvFlagDefaults{
 		omitNixEnv: true,
+		global:     true,
 	}))
 	addCommandAndHideConfigFlag(globalCmd, updateCmd())
-	addCommandAndHideConfigFlag(globalCmd, listCmd())
+	addCommandAndHideConfigFlag(globalCmd, listCmd(true))
+	globalCmd.AddCommand(globalActivateCmd())
+	globalCmd.AddCommand(globalDestroyCmd())
+	globalCmd.AddCommand(globalDoctorCmd())
+	globalCmd.AddCommand(globalEditCmd())
+	globalCmd.AddCommand(globalExportCmd())
+	globalCmd.AddCommand(globalHistoryCmd())
+	globalCmd.AddCommand(globalOutdatedCmd())
+	globalCmd.AddCommand(globalRollbackCmd())
 
 	return globalCmd
 }
 
+// completeGlobalPackages completes the names of packages in the global
+// devbox.json. It reads the config instead of the nix profile so that it
+// returns instantly.
+func completeGlobalPackages(
+	cmd *cobra.Command, args []string, toComplete string,
+) ([]string, cobra.ShellCompDirective) {
+	names, err := devbox.GlobalPackageNames()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	return lo.Without(names, args...), cobra.ShellCompDirectiveNoFileComp
+}
+
+func globalEditCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "edit",
+		Short: "Edit the global devbox.json and install any package changes",
+		Long: "Open the global devbox.json in $VISUAL or $EDITOR. After the editor exits, " +
+			"the config is validated and, if its packages changed, the global profile " +
+			"is updated to match.",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: ensureNixInstalled,
+		RunE:    globalEditCmdFunc,
+	}
+}
+
+func globalEditCmdFunc(cmd *cobra.Command, args []string) error {
+	path, err := ensureGlobalConfig()
+	if err != nil {
+		return err
+	}
+	before, err := devconfig.Open(path)
+	if err != nil {
+		return err
+	}
+	configPath := before.Root.AbsRootPath
+
+	var after *devconfig.Config
+	for {
+		if err := openInEditor(cmd, configPath); err != nil {
+			return err
+		}
+		after, err = devconfig.Open(path)
+		if err == nil {
+			break
+		}
+
+		ux.Ferrorf(cmd.ErrOrStderr(), "Invalid config: %v\n", err)
+		reopen := true
+		prompt := &survey.Confirm{Message: "Reopen the editor to fix it?", Default: true}
+		if err := survey.AskOne(prompt, &reopen); err != nil {
+			return errors.WithStack(err)
+		}
+		if !reopen {
+			return usererr.New(
+				"The global config %s is invalid, so the global profile was not updated.", configPath)
+		}
+	}
+
+	beforePkgs := before.Root.TopLevelPackages()
+	afterPkgs := after.Root.TopLevelPackages()
+	if slices.EqualFunc(beforePkgs, afterPkgs, func(a, b configfile.Package) bool {
+		return reflect.DeepEqual(a, b)
+	}) {
+		return nil
+	}
+
+	install := true
+	prompt := &survey.Confirm{Message: "Global packages changed. Update the global profile now?", Default: true}
+	if err := survey.AskOne(prompt, &install); err != nil {
+		return errors.WithStack(err)
+	}
+	if !install {
+		ux.Finfof(cmd.ErrOrStderr(), "Run `devbox global install` to update the global profile later.\n")
+		return nil
+	}
+	return installCmdFunc(cmd, installCmdFlags{
+		runCmdFlags: runCmdFlags{config: configFlags{pathFlag: pathFlag{path: path}}},
+	})
+}
+
+// openInEditor opens path in the user's preferred editor and waits for it to
+// exit. The editor command may include arguments, such as "code --wait".
+func openInEditor(cmd *cobra.Command, path string) error {
+	editor := cmp.Or(os.Getenv("VISUAL"), os.Getenv("EDITOR"), "vi")
+	editorArgs := strings.Fields(editor)
+	if len(editorArgs) == 0 {
+		return usererr.New("Set $EDITOR to choose an editor.")
+	}
+
+	editorCmd := exec.CommandContext(cmd.Context(), editorArgs[0], append(editorArgs[1:], path)...)
+	editorCmd.Stdin = cmd.InOrStdin()
+	editorCmd.Stdout = cmd.OutOrStdout()
+	editorCmd.Stderr = cmd.ErrOrStderr()
+	if err := editorCmd.Run(); err != nil {
+		return usererr.WithUserMessage(err, "Editor %q exited with an error.", editor)
+	}
+	return nil
+}
+
+func globalActivateCmd() *cobra.Command {
+	force := false
+	command := &cobra.Command{
+		Use:   "activate",
+		Short: "Activate global packages in the current shell only",
+		Long: "Print shell commands that activate the global packages and the env vars of " +
+			"the global devbox.json in the current shell, without adding a hook to your " +
+			"rcfile. Apply them with:\n\n\teval \"$(devbox global activate)\"\n\n" +
+			"If devbox global is already active in the shell, nothing is printed.",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: ensureNixInstalled,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if isatty.IsTerminal(os.Stdout.Fd()) {
+				ux.Finfof(
+					cmd.ErrOrStderr(),
+					"To activate global packages in this shell, run:\n\n\teval \"$(devbox global activate)\"\n",
+				)
+				return nil
+			}
+
+			path, err := ensureGlobalConfig()
+			if err != nil {
+				return err
+			}
+			box, err := devbox.Open(&devopt.Opts{
+				Dir:    path,
+				Stderr: cmd.ErrOrStderr(),
+			})
+			if err != nil {
+				return err
+			}
+			if box.IsEnvEnabled() && !force {
+				// Applying the environment twice would put the global
+				// packages in front of any project that was activated
+				// since.
+				ux.Finfof(cmd.ErrOrStderr(), "devbox global is already active in this shell.\n")
+				return nil
+			}
+
+			exports, err := box.EnvExports(cmd.Context(), devopt.EnvExportsOpts{
+				DontRecomputeEnvironment: true,
+				EnvOptions:               devopt.EnvOptions{OmitNixEnv: true},
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), exports)
+			if !strings.HasSuffix(os.Getenv("SHELL"), "fish") {
+				fmt.Fprintln(cmd.OutOrStdout(), "hash -r")
+			}
+			return nil
+		},
+	}
+	command.Flags().BoolVarP(
+		&force, "force", "f", false,
+		"print the commands even if devbox global is already active in this shell",
+	)
+	return command
+}
+
+func globalExportCmd() *cobra.Command {
+	shell := ""
+	command := &cobra.Command{
+		Use:   "export",
+		Short: "Print a script that installs the global packages without devbox",
+		Long: "Print a self-contained script, in the syntax of --shell, that installs the " +
+			"global packages with `nix profile install`, pinned to the commits in the " +
+			"global devbox.lock, and adds them to PATH along with the env of the global " +
+			"devbox.json. Share it with someone who hasn't installed devbox:\n\n" +
+			"\tdevbox global export --shell bash > activate.sh",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: ensureNixInstalled,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sh, ok := shenv.ShellByName(shell)
+			if !ok {
+				return usererr.New(
+					"Unsupported shell %q. Supported shells are: %s",
+					shell,
+					strings.Join(shenv.ShellNames(), ", "),
+				)
+			}
+			path, err := ensureGlobalConfig()
+			if err != nil {
+				return err
+			}
+			box, err := devbox.Open(&devopt.Opts{
+				Dir:    path,
+				Stderr: cmd.ErrOrStderr(),
+			})
+			if err != nil {
+				return err
+			}
+			return box.ExportGlobalScript(sh, cmd.OutOrStdout())
+		},
+	}
+	command.Flags().StringVar(&shell, "shell", "", "shell to write the script for, such as bash or fish")
+	_ = command.MarkFlagRequired("shell")
+	_ = command.RegisterFlagCompletionFunc("shell", func(
+		*cobra.Command, []string, string,
+	) ([]string, cobra.ShellCompDirective) {
+		return shenv.ShellNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+	return command
+}
+
+func globalHistoryCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "history",
+		Short: "List the generations of the global profile",
+		Long: "List the generations of the global profile along with the packages " +
+			"that each one added or removed. Use a generation number with " +
+			"`devbox global rollback --to` to switch back to it.",
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			history, err := devbox.GlobalHistory()
+			if err != nil {
+				return err
+			}
+			if len(history) == 0 {
+				ux.Finfof(cmd.ErrOrStderr(), "The global profile has no generations yet.\n")
+				return nil
+			}
+			w := cmd.OutOrStdout()
+			for _, gen := range history {
+				current := ""
+				if gen.Current {
+					current = " (current)"
+				}
+				fmt.Fprintf(w, "Generation %d  %s%s\n", gen.Number, gen.Time.Format(time.DateTime), current)
+				for _, pkg := range gen.Added {
+					fmt.Fprintf(w, "  + %s\n", pkg)
+				}
+				for _, pkg := range gen.Removed {
+					fmt.Fprintf(w, "  - %s\n", pkg)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func globalOutdatedCmd() *cobra.Command {
+	asJSON := false
+	command := &cobra.Command{
+		Use:   "outdated",
+		Short: "List global packages that have newer versions",
+		Long: "Compare the installed version of each global package with the version " +
+			"that `devbox global update` would upgrade it to. Packages that can't be " +
+			"checked, such as flake references, are listed as unknown. Nothing is " +
+			"installed or changed.",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: ensureNixInstalled,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := ensureGlobalConfig()
+			if err != nil {
+				return err
+			}
+			box, err := devbox.Open(&devopt.Opts{
+				Dir:    path,
+				Stderr: cmd.ErrOrStderr(),
+			})
+			if err != nil {
+				return err
+			}
+			outdated, err := box.Outdated(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				out, err := json.MarshalIndent(outdated, "", "  ")
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(out))
+				return nil
+			}
+
+			behind := lo.Filter(outdated, func(p devbox.OutdatedPackage, _ int) bool {
+				return p.Status != devbox.PackageUpToDate
+			})
+			if len(behind) == 0 {
+				ux.Fsuccessf(cmd.ErrOrStderr(), "All global packages are up to date.\n")
+				return nil
+			}
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "PACKAGE\tINSTALLED\tLATEST\tSTATUS")
+			for _, p := range behind {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+					p.Name, cmp.Or(p.Installed, "-"), cmp.Or(p.Latest, "-"), p.Status)
+			}
+			if err := tw.Flush(); err != nil {
+				return errors.WithStack(err)
+			}
+			for _, p := range behind {
+				if p.Error != "" {
+					ux.Fwarningf(cmd.ErrOrStderr(), "Couldn't check %s: %s\n", p.Name, p.Error)
+				}
+			}
+			return nil
+		},
+	}
+	command.Flags().BoolVar(&asJSON, "json", false, "print the status of every package as JSON")
+	return command
+}
+
+func globalRollbackCmd() *cobra.Command {
+	to := 0
+	command := &cobra.Command{
+		Use:   "rollback",
+		Short: "Undo the last change to the global profile",
+		Long: "Switch the global profile back to the previous generation, or to the " +
+			"generation given by --to, and restore the global devbox.json and devbox.lock " +
+			"that produced it.",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: ensureNixInstalled,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return devbox.GlobalRollback(cmd.Context(), cmd.ErrOrStderr(), to)
+		},
+	}
+	command.Flags().IntVar(&to, "to", 0, "generation to roll back to")
+	return command
+}
+
+func globalDestroyCmd() *cobra.Command {
+	yes := false
+	command := &cobra.Command{
+		Use:   "destroy",
+		Short: "Remove the global profile and all global packages",
+		Long: "Delete the global devbox.json, devbox.lock and nix profile, returning the " +
+			"system to the state it was in before devbox global was first used. This " +
+			"doesn't edit your shell rcfiles, so remove any line that evaluates " +
+			"`devbox global shellenv` yourself.",
+		Args: cobra.ExactArgs(0),
+		// Override the global command's PersistentPreRunE, which calls
+		// ensureGlobalConfig and would recreate the profile before
+		// destroy removes it.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !yes {
+				ok, err := confirmGlobalDestroy()
+				if err != nil || !ok {
+					return err
+				}
+			}
+			if err := devbox.GlobalDestroy(cmd.ErrOrStderr()); err != nil {
+				return err
+			}
+			ux.Fwarningf(
+				cmd.ErrOrStderr(),
+				"Your shell rcfiles haven't been changed. Remove any line that runs "+
+					"`devbox global shellenv` to stop loading the global profile.\n",
+			)
+			return nil
+		},
+	}
+	command.Flags().BoolVarP(&yes, "yes", "y", false, "remove the global profile without asking for confirmation")
+	return command
+}
+
+// confirmGlobalDestroy asks the user to confirm removing the global profile.
+// Like confirmRemove, it returns an error instead of prompting when stdin
+// isn't a terminal.
+func confirmGlobalDestroy() (bool, error) {
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return false, usererr.New(
+			"Refusing to remove the global profile without confirmation. Re-run with --yes to remove it.",
+		)
+	}
+	destroy := false
+	prompt := &survey.Confirm{Message: "Remove the global profile and all global packages?"}
+	if err := survey.AskOne(prompt, &destroy); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return destroy, nil
+}
+
+func globalDoctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the global profile for broken packages",
+		Long: "Check the global profile for packages whose files are missing from the " +
+			"nix store, such as after running nix-collect-garbage, and for changes made " +
+			"outside of devbox, and suggest how to fix them.",
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return devbox.GlobalDoctor(cmd.ErrOrStderr())
+		},
+	}
+}
+
 func addCommandAndHideConfigFlag(parent, child *cobra.Command) {
 	parent.AddCommand(child)
 	_ = child.Flags().MarkHidden("config")
@@ -91,7 +511,8 @@ func setGlobalConfigForDelegatedCommands(
 }
 
 func ensureGlobalEnvEnabled(cmd *cobra.Command, args []string) error {
-	if cmd.Name() == "shellenv" {
+	// destroy must not recreate the global config that it just removed.
+	if cmd.Name() == "shellenv" || cmd.Name() == "activate" || cmd.Name() == "destroy" {
 		return nil
 	}
 	path, err := ensureGlobalConfig()
@@ -106,18 +527,10 @@ func ensureGlobalEnvEnabled(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	if !box.IsEnvEnabled() {
-		fmt.Fprintln(cmd.ErrOrStderr())
-		ux.Fwarningf(
-			cmd.ErrOrStderr(),
-			`devbox global is not activated.
-
-Add the following line to your shell's rcfile (e.g., ~/.bashrc or ~/.zshrc)
-and restart your shell to fix this:
-
-	eval "$(devbox global shellenv)"
-`,
-		)
+	if err := box.EnsureGlobalProfileInPath(); errors.Is(err, devbox.ErrGlobalProfileNotInPath) {
+		box.PrintWarningOnce(err)
+	} else if err != nil {
+		return err
 	}
 	return nil
 }
@@ -18,6 +18,9 @@
 type installCmdFlags struct {
 	runCmdFlags
 	tidyLockfile bool
+	locked       bool
+	offline      offlineFlag
+	timings      timingsFlag
 }
 
 func installCmd() *cobra.Command {
@@ -33,30 +36,46 @@ func installCmd() *cobra.Command {
 	}
 
 	flags.config.register(command)
+	flags.offline.register(command)
+	flags.timings.register(command)
 	command.Flags().BoolVar(
 		&flags.tidyLockfile, "tidy-lockfile", false,
 		"Fix missing store paths in the devbox.lock file.",
 		// Could potentially do more in the future.
 	)
+	command.Flags().BoolVar(
+		&flags.locked, "locked", false,
+		"Install exactly the packages pinned in devbox.lock and fail if any package needs resolving.",
+	)
+	command.MarkFlagsMutuallyExclusive("tidy-lockfile", "locked")
 
 	return command
 }
 
 func installCmdFunc(cmd *cobra.Command, flags installCmdFlags) error {
+	if err := flags.offline.apply(); err != nil {
+		return errors.WithStack(err)
+	}
 	// Check the directory exists.
 	box, err := devbox.Open(&devopt.Opts{
 		Dir:         flags.config.path,
 		Environment: flags.config.environment,
 		Stderr:      cmd.ErrOrStderr(),
+		Timings:     flags.timings.timings,
 	})
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	defer box.PrintTimings()
 	ctx := cmd.Context()
 	if flags.tidyLockfile {
 		ctx = ux.HideMessage(ctx, devpkg.MissingStorePathsWarning)
 	}
-	if err = box.Install(ctx); err != nil {
+	install := box.Install
+	if flags.locked {
+		install = box.InstallLocked
+	}
+	if err = install(ctx); err != nil {
 		return errors.WithStack(err)
 	}
 	if flags.tidyLockfile {
@@ -16,9 +16,10 @@
 
 type listCmdFlags struct {
 	config configFlags
+	sort   string
 }
 
-func listCmd() *cobra.Command {
+func listCmd(global bool) *cobra.Command {
 	flags := listCmdFlags{}
 	cmd := &cobra.Command{
 		Use:     "list",
@@ -34,7 +35,14 @@ func listCmd() *cobra.Command {
 				return errors.WithStack(err)
 			}
 
-			for _, pkg := range box.AllPackagesIncludingRemovedTriggerPackages() {
+			pkgs := box.AllPackagesIncludingRemovedTriggerPackages()
+			if flags.sort != "" {
+				pkgs, err = box.SortGlobalPackages(cmd.Context(), pkgs, flags.sort)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
+			for _, pkg := range pkgs {
 				resolvedVersion, err := pkg.ResolvedVersion()
 				if err != nil {
 					// Continue to print the package even if we can't resolve the version
@@ -48,15 +56,24 @@ func listCmd() *cobra.Command {
 				if strings.HasSuffix(pkg.Versioned(), "latest") && resolvedVersion != "" {
 					// Runx packages have a "v" prefix (why?). Trim for consistency.
 					resolvedVersion = strings.TrimPrefix(resolvedVersion, "v")
-					msg = fmt.Sprintf("* %s - %s\n", pkg.Versioned(), resolvedVersion)
+					msg = fmt.Sprintf("* %s - %s", pkg.Versioned(), resolvedVersion)
 				} else {
-					msg = fmt.Sprintf("* %s\n", pkg.Versioned())
+					msg = fmt.Sprintf("* %s", pkg.Versioned())
 				}
-				fmt.Fprint(cmd.OutOrStdout(), msg)
+				if groups := box.Config().Root.PackageGroupsContaining(pkg.Versioned()); len(groups) > 0 {
+					msg += " (@" + strings.Join(groups, ", @") + ")"
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), msg)
 			}
 			return nil
 		},
 	}
 	flags.config.register(cmd)
+	if global {
+		cmd.Flags().StringVar(
+			&flags.sort, "sort", "",
+			"sort packages by name, date (newest install first) or size (largest closure first)",
+		)
+	}
 	return cmd
 }
@@ -0,0 +1,49 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package boxcli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"go.jetpack.io/devbox/internal/devbox"
+	"go.jetpack.io/devbox/internal/devbox/devopt"
+)
+
+type lockCmdFlags struct {
+	config configFlags
+}
+
+func lockCmd() *cobra.Command {
+	flags := lockCmdFlags{}
+	command := &cobra.Command{
+		Use:   "lock",
+		Short: "Resolve all packages and pin them in devbox.lock",
+		Long: "Resolve every package in devbox.json and write the resolved versions and " +
+			"store paths to devbox.lock without installing anything. Commit the " +
+			"lockfile and run `devbox install --locked` to install exactly the pinned packages.",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: ensureNixInstalled,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			box, err := devbox.Open(&devopt.Opts{
+				Dir:         flags.config.path,
+				Environment: flags.config.environment,
+				Stderr:      cmd.ErrOrStderr(),
+			})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if err := box.Lock(cmd.Context()); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.ErrOrStderr(), "Updated devbox.lock.")
+			return nil
+		},
+	}
+
+	flags.config.register(command)
+	return command
+}
@@ -1,23 +1,102 @@
 package boxcli
 
 import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+	"go.jetpack.io/devbox/internal/envir"
 	"go.jetpack.io/devbox/internal/patchpkg"
 )
 
 func patchCmd() *cobra.Command {
 	builder := &patchpkg.DerivationBuilder{}
+	searchCacheDir := ""
 	cmd := &cobra.Command{
 		Use:    "patch <store-path>",
 		Short:  "Apply Devbox patches to a package to fix common linker errors",
 		Args:   cobra.ExactArgs(1),
 		Hidden: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if searchCacheDir != "" {
+				builder.SearchCache = patchpkg.NewSearchCache(searchCacheDir)
+			}
 			return builder.Build(cmd.Context(), args[0])
 		},
 	}
 	cmd.Flags().StringVar(&builder.Glibc, "glibc", "", "patch binaries to use a different glibc")
 	cmd.Flags().StringVar(&builder.Gcc, "gcc", "", "patch binaries to use a different gcc")
 	cmd.Flags().BoolVar(&builder.RestoreRefs, "restore-refs", false, "restore references to removed store paths")
+	cmd.Flags().StringSliceVar(&builder.AllowedPrefixes, "allowed-prefix", nil,
+		"refuse to write outside of these directories (default $NIX_STORE or /nix/store)")
+	// The patch command usually runs in the Nix build sandbox, where HOME
+	// isn't writable or doesn't outlive the build, so the cache is only
+	// used when given a directory that persists between builds.
+	cmd.Flags().StringVar(&searchCacheDir, "search-cache", os.Getenv(envir.DevboxPatchSearchCache),
+		"reuse the results of searching store paths for removed references from this directory "+
+			"(default $DEVBOX_PATCH_SEARCH_CACHE)")
+	cmd.AddCommand(patchScanRefsCmd())
 	return cmd
 }
+
+func patchScanRefsCmd() *cobra.Command {
+	opts := patchpkg.ScanOptions{}
+	asJSON := false
+	failOnMatch := false
+	cmd := &cobra.Command{
+		Use:   "scan-refs <dir>",
+		Short: "Find store path references that were removed from the files in a package",
+		Long: "Search the files in dir for store path references that were removed with " +
+			"removeReferencesTo. Use --fail-on-match to exit with an error when any are " +
+			"found, such as to fail a CI build.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := patchpkg.ScanForRemovedRefs(cmd.Context(), os.DirFS(args[0]), ".", opts)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				out, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			} else {
+				printScanResult(cmd, result)
+			}
+			if failOnMatch && !result.Clean {
+				return usererr.New(
+					"Found %d removed store references in %d files.",
+					result.RefCount(), len(result.Matches),
+				)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&opts.SkipBinaries, "skip-binaries", false,
+		"don't search ELF binaries, which usually have their references removed on purpose")
+	cmd.Flags().BoolVar(&opts.DecodeUTF16, "decode-utf16", false,
+		"decode files that start with a UTF-16 byte order mark before searching them")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the result as JSON")
+	cmd.Flags().BoolVar(&failOnMatch, "fail-on-match", false,
+		"exit with an error if any removed references are found")
+	return cmd
+}
+
+// printScanResult prints each removed ref as path:line:column, the format
+// that editors and CI annotations recognize, followed by a summary.
+func printScanResult(cmd *cobra.Command, result patchpkg.ScanResult) {
+	paths := slices.Sorted(maps.Keys(result.Matches))
+	for _, path := range paths {
+		for _, ref := range result.Matches[path] {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s:%d:%d: %s\n", path, ref.Line, ref.Column, ref.Ref)
+		}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Scanned %d files, found %d removed store references in %d files.\n",
+		result.FilesScanned, result.RefCount(), len(paths))
+}
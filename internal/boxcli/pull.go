@@ -22,16 +22,22 @@
 )
 
 type pullCmdFlags struct {
-	config configFlags
-	force  bool
+	config     configFlags
+	force      bool
+	configName string
+	preview    bool
+	noInstall  bool
+	timings    timingsFlag
 }
 
 func pullCmd() *cobra.Command {
 	flags := pullCmdFlags{}
 	cmd := &cobra.Command{
-		Use:     "pull <file> | <url>",
-		Short:   "Pull a config from a file or URL",
-		Long:    "Pull a config from a file or URL. URLs must be prefixed with 'http://' or 'https://'.",
+		Use:   "pull <file> | <dir> | <url>",
+		Short: "Pull a config from a file, directory or URL",
+		Long: "Pull a config from a file, directory or URL. URLs must be prefixed with 'http://' or 'https://'. " +
+			"A directory or repository must contain a devbox.json, unless --config-name names a different file. " +
+			"Private HTTPS URLs are pulled with the bearer token in DEVBOX_PULL_TOKEN if their host is DEVBOX_PULL_TOKEN_HOST, or with credentials from ~/.netrc.",
 		Args:    cobra.MaximumNArgs(1),
 		PreRunE: ensureNixInstalled,
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -43,8 +49,25 @@ func pullCmd() *cobra.Command {
 		&flags.force, "force", "f", false,
 		"Force overwrite of existing [global] config files",
 	)
+	cmd.Flags().StringVar(
+		&flags.configName, "config-name", "",
+		"name of the config file to pull from a directory or repository, instead of devbox.json",
+	)
+	cmd.Flags().BoolVar(
+		&flags.preview, "preview", false,
+		"print the packages in the pulled config and which ones it would add or remove, "+
+			"without changing or installing anything",
+	)
+	cmd.Flags().BoolVar(
+		&flags.noInstall, "no-install", false,
+		"only update the config with the pulled one, without installing its packages. "+
+			"Run `devbox global install` to install them later",
+	)
+	cmd.MarkFlagsMutuallyExclusive("preview", "force")
+	cmd.MarkFlagsMutuallyExclusive("preview", "no-install")
 
 	flags.config.register(cmd)
+	flags.timings.register(cmd)
 
 	return cmd
 }
@@ -54,10 +77,14 @@ func pullCmdFunc(cmd *cobra.Command, url string, flags *pullCmdFlags) error {
 		Dir:         flags.config.path,
 		Environment: flags.config.environment,
 		Stderr:      cmd.ErrOrStderr(),
+		Timings:     flags.timings.timings,
 	})
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	// Print the pull's timings even if it fails. On success they're printed
+	// before the install, which prints its own.
+	defer box.PrintTimings()
 
 	pullPath, err := absolutizeIfLocal(url)
 	if err != nil {
@@ -76,10 +103,25 @@ func pullCmdFunc(cmd *cobra.Command, url string, flags *pullCmdFlags) error {
 		}
 	}
 
+	if flags.preview {
+		err := box.Pull(cmd.Context(), devopt.PullboxOpts{
+			URL:         pullPath,
+			Credentials: creds,
+			ConfigName:  flags.configName,
+			Preview:     true,
+		})
+		if errors.Is(err, s3.ErrProfileNotFound) {
+			return usererr.New("Profile not found. Use `devbox global push` to create a new profile.")
+		}
+		return err
+	}
+
 	err = box.Pull(cmd.Context(), devopt.PullboxOpts{
 		URL:         pullPath,
 		Overwrite:   flags.force,
 		Credentials: creds,
+		ConfigName:  flags.configName,
+		NoInstall:   flags.noInstall,
 	})
 	if prompt := pullErrorPrompt(err); prompt != "" {
 		prompt := &survey.Confirm{Message: prompt}
@@ -93,6 +135,8 @@ func pullCmdFunc(cmd *cobra.Command, url string, flags *pullCmdFlags) error {
 			URL:         pullPath,
 			Overwrite:   flags.force,
 			Credentials: creds,
+			ConfigName:  flags.configName,
+			NoInstall:   flags.noInstall,
 		})
 	}
 	if errors.Is(err, s3.ErrProfileNotFound) {
@@ -103,11 +147,16 @@ func pullCmdFunc(cmd *cobra.Command, url string, flags *pullCmdFlags) error {
 	if err != nil {
 		return err
 	}
+	if flags.noInstall {
+		return nil
+	}
 
+	box.PrintTimings()
 	return installCmdFunc(
 		cmd,
 		installCmdFlags{
 			runCmdFlags: runCmdFlags{config: configFlags{pathFlag: pathFlag{path: flags.config.path}}},
+			timings:     flags.timings,
 		},
 	)
 }
@@ -4,22 +4,51 @@
 package boxcli
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/mattn/go-isatty"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/devbox"
 	"go.jetpack.io/devbox/internal/devbox/devopt"
+	"go.jetpack.io/devbox/internal/envir"
+	"go.jetpack.io/devbox/internal/ux"
 )
 
+// confirmRemoveThreshold is the number of packages that `devbox global rm`
+// removes without asking for confirmation.
+const confirmRemoveThreshold = 5
+
 type removeCmdFlags struct {
-	config configFlags
+	config      configFlags
+	all         bool
+	yes         bool
+	global      bool
+	pruneGroups bool
+	indexes     []int
+	timings     timingsFlag
 }
 
-func removeCmd() *cobra.Command {
-	flags := removeCmdFlags{}
+// removeCmd returns the rm command. When global is true, it's the `devbox
+// global rm` command, which also offers to clean up empty package groups.
+func removeCmd(global bool) *cobra.Command {
+	flags := removeCmdFlags{global: global}
 	command := &cobra.Command{
-		Use:     "rm <pkg>...",
-		Short:   "Remove a package from your devbox",
-		Args:    cobra.MinimumNArgs(1),
+		Use:   "rm <pkg>...",
+		Short: "Remove a package from your devbox",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if flags.all {
+				return cobra.NoArgs(cmd, args)
+			}
+			if len(flags.indexes) > 0 {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
 		PreRunE: ensureNixInstalled,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runRemoveCmd(cmd, args, flags)
@@ -27,6 +56,23 @@ func removeCmd() *cobra.Command {
 	}
 
 	flags.config.register(command)
+	flags.timings.register(command)
+	if global {
+		command.Flags().BoolVar(
+			&flags.all, "all", false,
+			"remove all packages")
+		command.Flags().BoolVarP(
+			&flags.yes, "yes", "y", false,
+			fmt.Sprintf("don't ask for confirmation when removing more than %d packages", confirmRemoveThreshold))
+		command.Flags().BoolVar(
+			&flags.pruneGroups, "prune-groups", false,
+			"delete package groups that no longer have any packages in the config")
+		command.Flags().IntSliceVar(
+			&flags.indexes, "index", nil,
+			"remove the package of the global profile element at this index of `nix profile list`. "+
+				"Packages can also be removed by the store path of their profile element")
+		command.MarkFlagsMutuallyExclusive("all", "index")
+	}
 	return command
 }
 
@@ -35,10 +81,63 @@ func runRemoveCmd(cmd *cobra.Command, args []string, flags removeCmdFlags) error
 		Dir:         flags.config.path,
 		Environment: flags.config.environment,
 		Stderr:      cmd.ErrOrStderr(),
+		Timings:     flags.timings.timings,
 	})
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	defer box.PrintTimings()
 
+	if flags.all {
+		for _, pkg := range box.Config().Root.TopLevelPackages() {
+			args = append(args, pkg.VersionedName())
+		}
+		if len(args) == 0 {
+			ux.Finfof(cmd.ErrOrStderr(), "There are no packages to remove.\n")
+			return nil
+		}
+	}
+	if flags.global {
+		if !flags.yes && (flags.all || len(args) > confirmRemoveThreshold) {
+			ok, err := confirmRemove(cmd, args)
+			if err != nil || !ok {
+				return err
+			}
+		}
+		return box.RemoveGlobal(cmd.Context(), args, devopt.RemoveOpts{
+			PruneGroups: flags.pruneGroups,
+			Indexes:     flags.indexes,
+		})
+	}
 	return box.Remove(cmd.Context(), args...)
 }
+
+// confirmRemove lists the packages that will be removed and asks the user to
+// confirm. When stdin isn't a terminal there's no one to answer, so it
+// doesn't prompt. It removes the packages as if --yes was passed, unless
+// DEVBOX_GLOBAL_RM_REQUIRE_CONFIRM is set, in which case it returns an error.
+func confirmRemove(cmd *cobra.Command, pkgs []string) (bool, error) {
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		if envir.RequireGlobalRemoveConfirm() {
+			return false, usererr.New(
+				"Refusing to remove %d packages without confirmation. Re-run with --yes to remove them.",
+				len(pkgs),
+			)
+		}
+		return true, nil
+	}
+
+	fmt.Fprintln(cmd.ErrOrStderr(), "The following packages will be removed:")
+	fmt.Fprintln(cmd.ErrOrStderr())
+	for _, pkg := range pkgs {
+		fmt.Fprintf(cmd.ErrOrStderr(), "\t%s\n", pkg)
+	}
+	fmt.Fprintln(cmd.ErrOrStderr())
+
+	remove := false
+	prompt := &survey.Confirm{Message: fmt.Sprintf("Remove %d packages?", len(pkgs))}
+	if err := survey.AskOne(prompt, &remove); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return remove, nil
+}
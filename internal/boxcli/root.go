@@ -55,7 +55,7 @@ func RootCmd() *cobra.Command {
 	}
 
 	// Stable commands
-	command.AddCommand(addCmd())
+	command.AddCommand(addCmd(false))
 	if featureflag.Auth.Enabled() {
 		command.AddCommand(authCmd())
 	}
@@ -68,10 +68,11 @@ func RootCmd() *cobra.Command {
 	command.AddCommand(initCmd())
 	command.AddCommand(installCmd())
 	command.AddCommand(integrateCmd())
-	command.AddCommand(listCmd())
+	command.AddCommand(listCmd(false))
+	command.AddCommand(lockCmd())
 	command.AddCommand(logCmd())
 	command.AddCommand(patchCmd())
-	command.AddCommand(removeCmd())
+	command.AddCommand(removeCmd(false))
 	command.AddCommand(runCmd(runFlagDefaults{}))
 	command.AddCommand(searchCmd())
 	command.AddCommand(servicesCmd())
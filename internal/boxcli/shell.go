@@ -17,10 +17,11 @@
 
 type shellCmdFlags struct {
 	envFlag
-	config     configFlags
-	omitNixEnv bool
-	printEnv   bool
-	pure       bool
+	config       configFlags
+	mergePathBin bool
+	omitNixEnv   bool
+	printEnv     bool
+	pure         bool
 }
 
 // shellFlagDefaults are the flag default values that differ
@@ -48,6 +49,8 @@ func shellCmd(defaults shellFlagDefaults) *cobra.Command {
 		&flags.printEnv, "print-env", false, "print script to setup shell environment")
 	command.Flags().BoolVar(
 		&flags.pure, "pure", false, "if this flag is specified, devbox creates an isolated shell inheriting almost no variables from the current environment. A few variables, in particular HOME, USER and DISPLAY, are retained.")
+	command.Flags().BoolVar(
+		&flags.mergePathBin, "merge-path-bin", false, mergePathBinUsage)
 	command.Flags().BoolVar(
 		&flags.omitNixEnv, "omit-nix-env", defaults.omitNixEnv,
 		"shell environment will omit the env-vars from print-dev-env",
@@ -92,8 +95,9 @@ func runShellCmd(cmd *cobra.Command, flags shellCmdFlags) error {
 	}
 
 	return box.Shell(cmd.Context(), devopt.EnvOptions{
-		OmitNixEnv: flags.omitNixEnv,
-		Pure:       flags.pure,
+		MergePathBin: flags.mergePathBin,
+		OmitNixEnv:   flags.omitNixEnv,
+		Pure:         flags.pure,
 	})
 }
 
@@ -5,25 +5,55 @@
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/mattn/go-isatty"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/devbox"
 	"go.jetpack.io/devbox/internal/devbox/devopt"
+	"go.jetpack.io/devbox/internal/shenv"
 	"go.jetpack.io/devbox/internal/ux"
+	"go.jetpack.io/devbox/internal/xdg"
 )
 
+const mergePathBinUsage = "replace the nix store directories in PATH with a single directory " +
+	"of symlinks to keep PATH short. The directory is rebuilt when the packages change"
+
 type shellEnvCmdFlags struct {
 	envFlag
 	config            configFlags
+	expandEnv         bool
+	header            bool
+	init              bool
 	omitNixEnv        bool
 	install           bool
+	listOwned         bool
+	listShells        bool
+	mergePathBin      bool
 	noRefreshAlias    bool
+	onChange          string
+	onExit            bool
+	pathLast          bool
 	preservePathStack bool
+	printPathOnly     bool
 	pure              bool
+	readonly          []string
 	recomputeEnv      bool
+	restore           string
 	runInitHook       bool
+	shell             string
+	snapshot          string
+	sourceFile        bool
 }
 
 // shellenvFlagDefaults are the flag default values that differ
@@ -31,23 +61,81 @@ type shellEnvCmdFlags struct {
 type shellenvFlagDefaults struct {
 	omitNixEnv   bool
 	recomputeEnv bool
+
+	// global adds the flags that only `devbox global shellenv` has.
+	global bool
 }
 
 func shellEnvCmd(defaults shellenvFlagDefaults) *cobra.Command {
 	flags := shellEnvCmdFlags{}
 	command := &cobra.Command{
-		Use:     "shellenv",
-		Short:   "Print shell commands that create a Devbox Environment in the shell",
-		Args:    cobra.ExactArgs(0),
-		PreRunE: ensureNixInstalled,
+		Use:   "shellenv",
+		Short: "Print shell commands that create a Devbox Environment in the shell",
+		Args:  cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			// Listing the shells, restoring a snapshot, undoing the
+			// environment and printing the init hook don't need nix.
+			if flags.listShells || flags.restore != "" || flags.onExit || flags.init {
+				return nil
+			}
+			return ensureNixInstalled(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			s, err := shellEnvFunc(cmd, flags)
+			if flags.listShells {
+				return printSupportedShells(cmd)
+			}
+			if flags.printPathOnly {
+				return printProfileBinPath(cmd, flags)
+			}
+			if flags.listOwned {
+				return printOwnedEnvKeys(cmd, flags)
+			}
+			if flags.init {
+				return printInitHook(cmd, flags)
+			}
+			if flags.restore == "" && !flags.onExit && !flags.sourceFile {
+				// Stream the environment, which can have hundreds of
+				// variables, instead of building it in memory first.
+				w := cmd.OutOrStdout()
+				if err := writeShellEnv(cmd, flags, w); err != nil {
+					return err
+				}
+				if needsHashReset(flags.shell) {
+					fmt.Fprint(w, "hash -r\n")
+				}
+				return nil
+			}
+			var s string
+			var err error
+			if flags.restore != "" {
+				s, err = restoreEnvSnapshot(flags)
+			} else if flags.onExit {
+				s, err = exitEnvExports(cmd, flags)
+			} else {
+				var b strings.Builder
+				err = writeShellEnv(cmd, flags, &b)
+				s = b.String()
+			}
+			if err != nil {
+				return err
+			}
+			s += "\n"
+			if needsHashReset(flags.shell) {
+				s += "hash -r\n"
+			}
+			if !flags.sourceFile {
+				fmt.Fprint(cmd.OutOrStdout(), s)
+				return nil
+			}
+			path, err := writeShellEnvSourceFile(s)
 			if err != nil {
 				return err
 			}
-			fmt.Fprintln(cmd.OutOrStdout(), s)
-			if !strings.HasSuffix(os.Getenv("SHELL"), "fish") {
-				fmt.Fprintln(cmd.OutOrStdout(), "hash -r")
+			fmt.Fprintln(cmd.OutOrStdout(), path)
+			// Scripts read the path from the first line, so only show
+			// the command when a person is reading the output.
+			if isatty.IsTerminal(os.Stdout.Fd()) {
+				fmt.Fprintf(cmd.OutOrStdout(), "\nApply it to your shell with:\n\n\t%s\n", shellEnvSourceCommand(path))
 			}
 			return nil
 		},
@@ -76,10 +164,96 @@ func shellEnvCmd(defaults shellenvFlagDefaults) *cobra.Command {
 	)
 	_ = command.Flags().MarkHidden("omit-nix-env")
 
+	command.Flags().BoolVar(
+		&flags.expandEnv, "expand-env", false,
+		"resolve references between env vars in devbox.json (e.g. GOBIN=$GOPATH/bin) "+
+			"so that exported values are final")
+	command.Flags().BoolVar(
+		&flags.header, "header", false,
+		"start the output with a comment saying which project and devbox version "+
+			"generated it, and when")
+	command.Flags().BoolVar(
+		&flags.mergePathBin, "merge-path-bin", false,
+		mergePathBinUsage)
+	command.Flags().BoolVar(
+		&flags.pathLast, "path-last", false,
+		"use dependency-aware ordering: export PATH and other list-like variables "+
+			"after all other variables instead of alphabetically")
+	command.Flags().StringSliceVar(
+		&flags.readonly, "readonly", nil,
+		"mark these variables as read-only after exporting them, so the shell refuses to "+
+			"reassign them. Applying the output again in the same shell fails for them. "+
+			"Not supported by fish or elvish")
+	command.Flags().BoolVar(
+		&flags.printPathOnly, "print-path-only", false,
+		"print only the absolute path of the directory with the installed binaries, "+
+			"for tools and CI configs that take a literal path")
+	command.Flags().BoolVar(
+		&flags.listOwned, "list-owned", false,
+		"print the names of the variables in the current environment that devbox set, "+
+			"instead of ones inherited from the parent environment")
+	command.Flags().BoolVar(
+		&flags.listShells, "list-shells", false,
+		"print the shells that --shell supports and whether each has a structured env format")
+	command.Flags().StringVar(
+		&flags.shell, "shell", "",
+		"print only the environment, in the syntax of this shell ("+
+			strings.Join(shenv.ShellNames(), ", ")+")")
+	_ = command.RegisterFlagCompletionFunc("shell", func(
+		*cobra.Command, []string, string,
+	) ([]string, cobra.ShellCompDirective) {
+		return shenv.ShellNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+	command.Flags().BoolVar(
+		&flags.sourceFile, "source-file", false,
+		"write the shell commands to a temporary file and print its path, "+
+			"so the environment can be applied with `source` instead of `eval`. "+
+			"In a terminal, it also prints the command that sources the file in your shell")
+	command.Flags().StringVar(
+		&flags.onChange, "on-change", "",
+		"run this command with sh when the environment differs from the last time shellenv "+
+			"ran with --on-change. The names of the changed variables are its arguments and "+
+			"its output goes to stderr")
+	command.Flags().StringVar(
+		&flags.snapshot, "snapshot", "",
+		"also save the environment, including which variables devbox set, to this file "+
+			"so it can be applied again later with --restore")
+	command.Flags().StringVar(
+		&flags.restore, "restore", "",
+		"print the environment saved in this file by --snapshot instead of computing it, "+
+			"regardless of the current devbox.json")
+	command.Flags().BoolVar(
+		&flags.onExit, "on-exit", false,
+		"print commands that unset the variables devbox set in the current environment, "+
+			"and restore PATH, so a shell can undo the environment when leaving devbox")
 	command.Flags().BoolVarP(
 		&flags.recomputeEnv, "recompute", "r", defaults.recomputeEnv,
 		"Recompute environment if needed",
 	)
+	// The flags that print something other than the environment can't be
+	// combined with each other or with the flags that change its output.
+	command.MarkFlagsMutuallyExclusive("print-path-only", "source-file")
+	command.MarkFlagsMutuallyExclusive("print-path-only", "shell")
+	command.MarkFlagsMutuallyExclusive("list-owned", "print-path-only", "source-file", "shell")
+	command.MarkFlagsMutuallyExclusive("list-shells", "list-owned", "print-path-only", "source-file", "shell")
+	command.MarkFlagsMutuallyExclusive("list-shells", "list-owned", "print-path-only", "on-change")
+	command.MarkFlagsMutuallyExclusive("list-shells", "list-owned", "print-path-only", "snapshot", "restore")
+	command.MarkFlagsMutuallyExclusive("restore", "on-change")
+	command.MarkFlagsMutuallyExclusive("restore", "install")
+	command.MarkFlagsMutuallyExclusive("on-exit", "list-shells", "list-owned", "print-path-only", "restore")
+	for _, flag := range []string{"snapshot", "on-change", "install", "init-hook"} {
+		command.MarkFlagsMutuallyExclusive("on-exit", flag)
+	}
+
+	if defaults.global {
+		command.Flags().BoolVar(
+			&flags.init, "init", false,
+			"print commands that load the global environment once when the shell starts, "+
+				"for the top of your rcfile, instead of the environment itself. They're in "+
+				"the syntax of --shell, or of $SHELL if --shell isn't set")
+		command.MarkFlagsMutuallyExclusive("init", "list-shells", "list-owned", "print-path-only", "source-file")
+		command.MarkFlagsMutuallyExclusive("init", "on-exit", "restore", "snapshot", "on-change")
+	}
 
 	flags.config.register(command)
 	flags.envFlag.register(command)
@@ -87,13 +261,16 @@ func shellEnvCmd(defaults shellenvFlagDefaults) *cobra.Command {
 	return command
 }
 
-func shellEnvFunc(
+// writeShellEnv writes the environment of the project in --config to w, in
+// the syntax of --shell if it's set.
+func writeShellEnv(
 	cmd *cobra.Command,
 	flags shellEnvCmdFlags,
-) (string, error) {
+	w io.Writer,
+) error {
 	env, err := flags.Env(flags.config.path)
 	if err != nil {
-		return "", err
+		return err
 	}
 	ctx := cmd.Context()
 	if flags.recomputeEnv {
@@ -106,28 +283,263 @@ func shellEnvFunc(
 		Env:         env,
 	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	if flags.install {
 		if err := box.Install(ctx); err != nil {
-			return "", err
+			return err
 		}
 	}
 
-	envStr, err := box.EnvExports(ctx, devopt.EnvExportsOpts{
+	opts := devopt.EnvExportsOpts{
 		DontRecomputeEnvironment: !flags.recomputeEnv,
 		EnvOptions: devopt.EnvOptions{
+			ExpandConfigEnv:   flags.expandEnv,
+			MergePathBin:      flags.mergePathBin,
 			OmitNixEnv:        flags.omitNixEnv,
 			PreservePathStack: flags.preservePathStack,
 			Pure:              flags.pure,
 		},
+		Header:         flags.header,
 		NoRefreshAlias: flags.noRefreshAlias,
+		PathLast:       flags.pathLast,
 		RunHooks:       flags.runInitHook,
+		SnapshotPath:   flags.snapshot,
+	}
+	if len(flags.readonly) > 0 {
+		opts.Readonly = lo.SliceToMap(flags.readonly, func(k string) (string, bool) {
+			return k, true
+		})
+	}
+	if flags.onChange != "" {
+		opts.OnChange = func(changed []string) error {
+			return runOnChange(cmd, flags.onChange, changed)
+		}
+	}
+	if flags.shell == "" {
+		return box.WriteEnvExports(ctx, w, opts)
+	}
+
+	sh, ok := shenv.ShellByName(flags.shell)
+	if !ok {
+		return usererr.New(
+			"Unsupported shell %q. Supported shells are: %s",
+			flags.shell,
+			strings.Join(shenv.ShellNames(), ", "),
+		)
+	}
+	return box.Shellenv(ctx, sh, w, opts)
+}
+
+// printInitHook prints the init hook of --shell, or of the user's $SHELL, for
+// the project in --config. See [devbox.Devbox.ShellInitHook].
+func printInitHook(cmd *cobra.Command, flags shellEnvCmdFlags) error {
+	name := flags.shell
+	if name == "" {
+		name = filepath.Base(os.Getenv("SHELL"))
+	}
+	sh, ok := shenv.ShellByName(name)
+	if !ok {
+		return usererr.New(
+			"Unsupported shell %q. Supported shells are: %s",
+			name,
+			strings.Join(shenv.ShellNames(), ", "),
+		)
+	}
+	box, err := devbox.Open(&devopt.Opts{
+		Dir:         flags.config.path,
+		Environment: flags.config.environment,
+		Stderr:      cmd.ErrOrStderr(),
 	})
+	if err != nil {
+		return err
+	}
+	hook, err := box.ShellInitHook(sh)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(cmd.OutOrStdout(), hook)
+	return nil
+}
+
+// restoreEnvSnapshot returns the exports of the environment in the
+// --restore file, in the syntax of --shell if it's set.
+func restoreEnvSnapshot(flags shellEnvCmdFlags) (string, error) {
+	snapshot, err := devbox.ReadEnvSnapshot(flags.restore)
 	if err != nil {
 		return "", err
 	}
+	if flags.shell == "" {
+		return snapshot.Exports(nil), nil
+	}
+	sh, ok := shenv.ShellByName(flags.shell)
+	if !ok {
+		return "", usererr.New(
+			"Unsupported shell %q. Supported shells are: %s",
+			flags.shell,
+			strings.Join(shenv.ShellNames(), ", "),
+		)
+	}
+	return snapshot.Exports(sh), nil
+}
 
-	return envStr, nil
+// exitEnvExports returns the commands that undo the environment that devbox
+// set in the current shell, in the syntax of --shell if it's set or of a POSIX
+// shell otherwise.
+func exitEnvExports(cmd *cobra.Command, flags shellEnvCmdFlags) (string, error) {
+	sh := shenv.Posix
+	if flags.shell != "" {
+		var ok bool
+		if sh, ok = shenv.ShellByName(flags.shell); !ok {
+			return "", usererr.New(
+				"Unsupported shell %q. Supported shells are: %s",
+				flags.shell,
+				strings.Join(shenv.ShellNames(), ", "),
+			)
+		}
+	}
+	box, err := devbox.Open(&devopt.Opts{
+		Dir:         flags.config.path,
+		Environment: flags.config.environment,
+		Stderr:      cmd.ErrOrStderr(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return box.ExitEnvExports(sh), nil
+}
+
+// runOnChange runs the --on-change command with the names of the changed
+// variables as its arguments. Stdout is usually evaluated by a shell, so the
+// command's output goes to stderr instead.
+func runOnChange(cmd *cobra.Command, command string, changed []string) error {
+	args := append([]string{"-c", command, "devbox-on-change"}, changed...)
+	c := exec.CommandContext(cmd.Context(), "sh", args...)
+	c.Stdout = cmd.ErrOrStderr()
+	c.Stderr = cmd.ErrOrStderr()
+	if err := c.Run(); err != nil {
+		return usererr.WithUserMessage(err, "The --on-change command %q failed: %v", command, err)
+	}
+	return nil
+}
+
+// printSupportedShells prints a table of the shells that --shell supports and
+// the optional features that each of them has.
+func printSupportedShells(cmd *cobra.Command) error {
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHELL\tSTRUCTURED DUMP")
+	for _, sh := range shenv.SupportedShells() {
+		fmt.Fprintf(tw, "%s\t%s\n", sh.Name, lo.Ternary(sh.DumpStructured, "yes", "no"))
+	}
+	return errors.WithStack(tw.Flush())
+}
+
+// printProfileBinPath prints the bin directory of the project's nix profile,
+// which is where the binaries of the installed packages are linked. It
+// doesn't resolve symlinks, so the path stays the same across installs.
+func printProfileBinPath(cmd *cobra.Command, flags shellEnvCmdFlags) error {
+	box, err := devbox.Open(&devopt.Opts{
+		Dir:         flags.config.path,
+		Environment: flags.config.environment,
+		Stderr:      cmd.ErrOrStderr(),
+	})
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs(box.ProfileBinPath())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), path)
+	return nil
+}
+
+// printOwnedEnvKeys prints the variables in the current environment that were
+// set by devbox, one per line.
+func printOwnedEnvKeys(cmd *cobra.Command, flags shellEnvCmdFlags) error {
+	box, err := devbox.Open(&devopt.Opts{
+		Dir:         flags.config.path,
+		Environment: flags.config.environment,
+		Stderr:      cmd.ErrOrStderr(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range box.OwnedEnvKeys() {
+		fmt.Fprintln(cmd.OutOrStdout(), key)
+	}
+	return nil
+}
+
+// needsHashReset reports whether the shellenv output should end with `hash -r`
+// so that the shell forgets the locations of commands that moved. shell is
+// the --shell flag, or empty to detect the shell from $SHELL.
+func needsHashReset(shell string) bool {
+	if shell == "" {
+		return !strings.HasSuffix(os.Getenv("SHELL"), "fish")
+	}
+	switch shell {
+	case "bash", "ksh", "posix", "zsh":
+		return true
+	default:
+		return false
+	}
+}
+
+// shellEnvSourceFileTTL is how long a file written by `shellenv --source-file`
+// is kept. Shells source the file right after devbox prints its path, so it
+// only needs to outlive that.
+const shellEnvSourceFileTTL = time.Hour
+
+// writeShellEnvSourceFile writes the shellenv output to a new temporary file
+// and returns its path. It also deletes any files from previous runs that are
+// older than shellEnvSourceFileTTL.
+func writeShellEnvSourceFile(contents string) (string, error) {
+	dir := xdg.StateSubpath("devbox/shellenv")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", errors.WithStack(err)
+	}
+	removeStaleShellEnvSourceFiles(dir, time.Now().Add(-shellEnvSourceFileTTL))
+
+	f, err := os.CreateTemp(dir, "shellenv-*.sh")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return f.Name(), errors.WithStack(f.Close())
+}
+
+// shellEnvSourceCommand returns the command that applies the file at path in
+// the shell from $SHELL. It falls back to the POSIX command for shells that
+// devbox doesn't know, such as sh.
+func shellEnvSourceCommand(path string) string {
+	sh, ok := shenv.ShellByName(filepath.Base(os.Getenv("SHELL")))
+	if !ok {
+		sh = shenv.Posix
+	}
+	return sh.SourceCommand(path)
+}
+
+// removeStaleShellEnvSourceFiles deletes the shellenv files in dir that were
+// last modified before cutoff. It only logs errors because a stale file is
+// harmless.
+func removeStaleShellEnvSourceFiles(dir string, cutoff time.Time) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Debug("failed to list shellenv source files", "dir", dir, "err", err)
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			slog.Debug("failed to remove stale shellenv source file", "path", entry.Name(), "err", err)
+		}
+	}
 }
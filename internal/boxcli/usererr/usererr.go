@@ -22,6 +22,9 @@ type combined struct {
 	userMessage string
 	level       level
 	logged      bool
+
+	// id is a stable name for a warning. See NewWarningWithID.
+	id string
 }
 
 // New creates new user error with the given message. By default these errors
@@ -51,6 +54,17 @@ func NewWarning(msg string, args ...any) error {
 	})
 }
 
+// NewWarningWithID is like NewWarning, but gives the warning a stable id, such
+// as "global-profile-not-in-path", so that callers can recognize a warning
+// they've already shown without comparing messages. See WarningID.
+func NewWarningWithID(id, msg string, args ...any) error {
+	return errors.WithStack(&combined{
+		userMessage: fmt.Sprintf(msg, args...),
+		level:       levelWarning,
+		id:          id,
+	})
+}
+
 func WithUserMessage(source error, msg string, args ...any) error {
 	// We don't want to wrap the error if it already has a user message. Doing
 	// so would obscure the original error message which is likely more useful.
@@ -108,6 +122,16 @@ func IsWarning(err error) bool {
 	return false
 }
 
+// WarningID returns the id of the warning in err's chain, or "" if err isn't a
+// warning or its warning doesn't have an id.
+func WarningID(err error) string {
+	c := &combined{}
+	if errors.As(err, &c) && c.level == levelWarning {
+		return c.id
+	}
+	return ""
+}
+
 func (c *combined) Error() string {
 	if c.source == nil {
 		return c.userMessage
@@ -4,7 +4,13 @@
 package conf
 
 import (
+	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strings"
+
+	"go.jetpack.io/devbox/internal/devbox/envpath"
 )
 
 func OSExpandEnvMap(env, existingEnv map[string]string, projectDir string) map[string]string {
@@ -28,3 +34,74 @@ func OSExpandEnvMap(env, existingEnv map[string]string, projectDir string) map[s
 	}
 	return res
 }
+
+// ExpandEnvMapRecursive is like [OSExpandEnvMap], except that values in env may
+// also reference other variables in env. References are resolved in
+// dependency order, so the result doesn't depend on map iteration order.
+//
+// A variable that references itself (such as PATH=$PATH:/bin) expands to its
+// value in existingEnv, the environment before env is applied. A
+// self-referencing PATH is cleaned with [envpath.JoinPathLists], so that
+// PATH=$PATH:/bin adds /bin to the inherited PATH exactly once, even if the
+// inherited PATH already has it from an earlier devbox shellenv. Any other
+// cycle, such as A=$B and B=$A, is an error.
+func ExpandEnvMapRecursive(env, existingEnv map[string]string, projectDir string) (map[string]string, error) {
+	// Snapshot the base environment so that self-references don't depend
+	// on changes that the caller makes to existingEnv.
+	base := maps.Clone(existingEnv)
+	res := make(map[string]string, len(env))
+	visiting := map[string]bool{}
+	var stack []string
+
+	var resolve func(key string) error
+	resolve = func(key string) error {
+		if _, ok := res[key]; ok {
+			return nil
+		}
+		if visiting[key] {
+			cycle := slices.Concat(stack[slices.Index(stack, key):], []string{key})
+			return fmt.Errorf("env var reference cycle: %s", strings.Join(cycle, " -> "))
+		}
+		visiting[key] = true
+		stack = append(stack, key)
+
+		var err error
+		selfRef := false
+		expanded := os.Expand(env[key], func(name string) string {
+			switch {
+			case err != nil:
+				return ""
+			case name == "PWD":
+				return projectDir
+			case name == key:
+				selfRef = true
+				return base[name]
+			}
+			if _, ok := env[name]; ok {
+				if err = resolve(name); err != nil {
+					return ""
+				}
+				return res[name]
+			}
+			return base[name]
+		})
+		if err != nil {
+			return err
+		}
+		if selfRef && key == "PATH" {
+			expanded = envpath.JoinPathLists(expanded)
+		}
+
+		stack = stack[:len(stack)-1]
+		delete(visiting, key)
+		res[key] = expanded
+		return nil
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		if err := resolve(key); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package conf
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestExpandEnvMapRecursive(t *testing.T) {
+	existing := map[string]string{"HOME": "/home/me", "PATH": "/bin"}
+	env := map[string]string{
+		"GOPATH":  "$HOME/go",
+		"GOBIN":   "${GOPATH}/bin",
+		"PATH":    "$GOBIN:$PATH",
+		"INROOT":  "$PWD/tmp",
+		"MISSING": "x${NOPE}y",
+	}
+
+	got, err := ExpandEnvMapRecursive(env, existing, "/project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"GOPATH":  "/home/me/go",
+		"GOBIN":   "/home/me/go/bin",
+		"PATH":    "/home/me/go/bin:/bin",
+		"INROOT":  "/project/tmp",
+		"MISSING": "xy",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExpandEnvMapRecursiveCycle(t *testing.T) {
+	env := map[string]string{"A": "$B", "B": "${C}", "C": "$A"}
+	_, err := ExpandEnvMapRecursive(env, nil, "/project")
+	if err == nil {
+		t.Fatal("got nil error for reference cycle")
+	}
+	want := "env var reference cycle: A -> B -> C -> A"
+	if err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
+
+func TestExpandEnvMapRecursiveSelfReference(t *testing.T) {
+	// The inherited PATH already has /x from an earlier devbox shellenv.
+	existing := map[string]string{"PATH": "/bin:/x", "MANPATH": "/man"}
+	env := map[string]string{
+		"PATH":    "$PATH:/x:/y",
+		"MANPATH": "${MANPATH}:/man/x",
+		"CMD":     "$PATH",
+	}
+
+	got, err := ExpandEnvMapRecursive(env, existing, "/project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"PATH":    "/bin:/x:/y",
+		"MANPATH": "/man:/man/x",
+		"CMD":     "/bin:/x:/y",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExpandEnvMapRecursiveMutualCycle(t *testing.T) {
+	env := map[string]string{"A": "$B", "B": "$A"}
+	_, err := ExpandEnvMapRecursive(env, map[string]string{"A": "a", "B": "b"}, "/project")
+	if err == nil {
+		t.Fatal("got nil error for reference cycle")
+	}
+	want := "env var reference cycle: A -> B -> A"
+	if err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
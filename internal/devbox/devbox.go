@@ -26,7 +26,6 @@
 	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/cachehash"
 	"go.jetpack.io/devbox/internal/cmdutil"
-	"go.jetpack.io/devbox/internal/conf"
 	"go.jetpack.io/devbox/internal/debug"
 	"go.jetpack.io/devbox/internal/devbox/devopt"
 	"go.jetpack.io/devbox/internal/devbox/envpath"
@@ -43,6 +42,7 @@
 	"go.jetpack.io/devbox/internal/searcher"
 	"go.jetpack.io/devbox/internal/services"
 	"go.jetpack.io/devbox/internal/shellgen"
+	"go.jetpack.io/devbox/internal/shenv"
 	"go.jetpack.io/devbox/internal/telemetry"
 	"go.jetpack.io/devbox/internal/ux"
 )
@@ -67,6 +67,22 @@ type Devbox struct {
 
 	// This is needed because of the --quiet flag.
 	stderr io.Writer
+
+	// timings is nil unless timings are enabled.
+	timings *timings
+
+	// verbose passes nix's own output through when installing packages.
+	verbose bool
+
+	// now returns the current time for features that record it, such as
+	// the exports header. Tests set it to get a fixed time. If it's nil,
+	// devbox uses [time.Now].
+	now func() time.Time
+
+	// validator checks that packages exist before they're added to
+	// devbox.json. Tests set it to add packages without a working Nix
+	// installation. If it's nil, devbox uses the search index and nix.
+	validator PackageValidator
 }
 
 var legacyPackagesWarningHasBeenShown = false
@@ -119,6 +135,10 @@ func Open(opts *devopt.Opts) (*Devbox, error) {
 		pluginManager:            plugin.NewManager(),
 		stderr:                   opts.Stderr,
 		customProcessComposeFile: opts.CustomProcessComposeFile,
+		verbose:                  opts.Verbose,
+	}
+	if opts.Timings {
+		box.timings = &timings{}
 	}
 
 	lock, err := lock.GetFile(box)
@@ -162,7 +182,7 @@ func Open(opts *devopt.Opts) (*Devbox, error) {
 			return nil, err
 		}
 		ux.Fwarningf(
-			os.Stderr, // Always stderr. box.writer should probably always be err.
+			box.stderr,
 			"Your devbox.json contains packages in legacy format. "+
 				"Please run `devbox %supdate` to update your devbox.json.\n",
 			lo.Ternary(box.projectDir == globalPath, "global ", ""),
@@ -205,6 +225,10 @@ func (d *Devbox) NixPkgsCommitHash() string {
 	return d.cfg.NixPkgsCommitHash()
 }
 
+func (d *Devbox) NixpkgsURL() string {
+	return d.cfg.NixpkgsURL()
+}
+
 func (d *Devbox) Generate(ctx context.Context) error {
 	ctx, task := trace.NewTask(ctx, "devboxGenerate")
 	defer task.End()
@@ -329,6 +353,58 @@ func (d *Devbox) Install(ctx context.Context) error {
 	return d.ensureStateIsUpToDate(ctx, ensure)
 }
 
+// InstallLocked is like Install, but it installs exactly what devbox.lock
+// pins. It fails instead of resolving a package that isn't in the lockfile,
+// so installs are reproducible across machines that share the lockfile.
+func (d *Devbox) InstallLocked(ctx context.Context) error {
+	if unlocked := d.unlockedPackages(); len(unlocked) > 0 {
+		lockCmd := "devbox lock"
+		if d.isGlobal() {
+			lockCmd = "devbox global lock"
+		}
+		return usererr.New(
+			"devbox.lock is missing %s. Run `%s` to resolve and pin them.",
+			strings.Join(unlocked, ", "),
+			lockCmd,
+		)
+	}
+	return d.Install(ctx)
+}
+
+// Lock resolves every package in devbox.json and writes the resolved
+// versions and store paths to devbox.lock without installing anything.
+func (d *Devbox) Lock(ctx context.Context) error {
+	ctx, task := trace.NewTask(ctx, "devboxLock")
+	defer task.End()
+
+	for _, pkg := range d.AllPackages() {
+		if !pkg.IsDevboxPackage {
+			continue
+		}
+		if _, err := d.lockfile.Resolve(pkg.LockfileKey()); err != nil {
+			return err
+		}
+	}
+	// Leave the local state stale so that the next install recomputes it.
+	if err := d.updateLockfile(false /*recomputeState*/); err != nil {
+		return err
+	}
+	return d.FixMissingStorePaths(ctx)
+}
+
+// unlockedPackages returns the devbox packages that don't have a resolved
+// entry in devbox.lock. Flake references aren't locked, so they're never
+// reported.
+func (d *Devbox) unlockedPackages() []string {
+	unlocked := []string{}
+	for _, pkg := range d.AllPackages() {
+		if pkg.IsDevboxPackage && d.lockfile.Get(pkg.LockfileKey()) == nil {
+			unlocked = append(unlocked, pkg.Raw)
+		}
+	}
+	return unlocked
+}
+
 func (d *Devbox) ListScripts() []string {
 	scripts := d.cfg.Scripts()
 	keys := make([]string, len(scripts))
@@ -344,44 +420,237 @@ func (d *Devbox) ListScripts() []string {
 // to define a Devbox environment. The string is of the form `export KEY=VALUE` for each
 // env-var that needs to be applied.
 func (d *Devbox) EnvExports(ctx context.Context, opts devopt.EnvExportsOpts) (string, error) {
+	var b strings.Builder
+	if err := d.WriteEnvExports(ctx, &b, opts); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// WriteEnvExports is like EnvExports, but streams the exports to w instead of
+// building the whole string first, which matters for environments with
+// hundreds of variables. Every line, including the last, ends in a newline.
+func (d *Devbox) WriteEnvExports(ctx context.Context, w io.Writer, opts devopt.EnvExportsOpts) error {
 	ctx, task := trace.NewTask(ctx, "devboxEnvExports")
 	defer task.End()
 
-	var envs map[string]string
-	var err error
+	envs, err := d.exportEnv(ctx, opts)
+	if err != nil {
+		return err
+	}
+	d.warnInvalidEnvNames(envs)
+	if len(opts.Readonly) > 0 && isFishShell() {
+		return errReadonlyUnsupported(shenv.Fish)
+	}
 
-	if opts.DontRecomputeEnvironment {
-		upToDate, _ := d.lockfile.IsUpToDateAndInstalled(isFishShell())
-		if !upToDate {
-			ux.FHidableWarning(
-				ctx,
-				d.stderr,
-				StateOutOfDateMessage,
-				d.refreshAliasOrCommand(),
-			)
+	if opts.Header {
+		if _, err := io.WriteString(w, d.exportsHeader()); err != nil {
+			return errors.WithStack(err)
 		}
+	}
+	keys := exportKeys(envs)
+	if opts.PathLast {
+		keys = exportKeysPathLast(envs)
+	}
+	if err := writeExports(w, envs, keys, opts.Readonly); err != nil {
+		return err
+	}
 
-		envs, err = d.computeEnv(ctx, true /*usePrintDevEnvCache*/, opts.EnvOptions)
-	} else {
-		envs, err = d.ensureStateIsUpToDateAndComputeEnv(ctx, opts.EnvOptions)
+	var tail []string
+	if opts.RunHooks {
+		tail = append(tail, ". "+shellgen.ScriptPath(d.ProjectDir(), shellgen.HooksFilename)+";")
+	}
+	if hook := d.shellenvHook(); hook != "" {
+		tail = append(tail, strings.TrimSuffix(hook, "\n"))
+	}
+	if !opts.NoRefreshAlias {
+		tail = append(tail, d.refreshAlias())
+	}
+	for _, s := range tail {
+		if _, err := io.WriteString(w, s+"\n"); err != nil {
+			return errors.WithStack(err)
+		}
 	}
+	return nil
+}
+
+// Shellenv computes the environment of the project, or of the global profile
+// if d was opened from [GlobalDataPath], and writes it to w as exports in the
+// syntax of shell. Unlike EnvExports, it only writes the environment, the
+// optional header and the global shellenv_hook: the init hook and refresh
+// alias are POSIX shell code, so opts.RunHooks and opts.NoRefreshAlias are
+// ignored. It returns an error if opts.Readonly is set and shell has no
+// read-only variables.
+func (d *Devbox) Shellenv(
+	ctx context.Context,
+	shell shenv.Shell,
+	w io.Writer,
+	opts devopt.EnvExportsOpts,
+) error {
+	ctx, task := trace.NewTask(ctx, "devboxShellenv")
+	defer task.End()
 
+	for k := range opts.Readonly {
+		if _, ok := shell.Readonly(k); !ok {
+			return errReadonlyUnsupported(shell)
+		}
+	}
+	envs, err := d.exportEnv(ctx, opts)
+	if err != nil {
+		return err
+	}
+	d.warnInvalidEnvNames(envs)
+
+	if opts.Header {
+		if _, err := io.WriteString(w, d.exportsHeader()); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	keys := exportKeys(envs)
+	if opts.PathLast {
+		keys = exportKeysPathLast(envs)
+	}
+	for _, k := range keys {
+		export := shenv.ShellExport{}
+		export.Add(k, envs[k])
+		line := strings.TrimSuffix(shell.Export(export), "\n") + "\n"
+		if opts.Readonly[k] {
+			readonly, _ := shell.Readonly(k)
+			line += readonly + "\n"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if _, err := io.WriteString(w, d.shellenvHook()); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// errReadonlyUnsupported is the error for exporting read-only variables to a
+// shell that doesn't have them.
+func errReadonlyUnsupported(shell shenv.Shell) error {
+	return usererr.New(
+		"The %s shell can't mark environment variables as read-only. Export them without --readonly.",
+		shell.Name(),
+	)
+}
+
+// ShellInitHook returns the init hook of shell for d's project, which sets up
+// the environment once when the shell starts instead of before each prompt.
+// See [shenv.Shell.InitHook].
+func (d *Devbox) ShellInitHook(shell shenv.Shell) (string, error) {
+	hook, err := shell.InitHook()
 	if err != nil {
 		return "", err
 	}
+	return shenv.ExecuteHook(shell, hook, d.projectDir)
+}
 
-	envStr := exportify(envs)
+// shellenvHook returns the commands of the global config's shellenv_hook,
+// one per line, for printing after the global environment. The commands are
+// printed as they are, so they must be in the syntax of the user's shell.
+//
+// It returns an empty string in a devbox shell, where the user's rcfile
+// evaluates `devbox global shellenv` again. The commands only run in the
+// shell that loaded the global environment and not a second time in each
+// project shell started from it.
+func (d *Devbox) shellenvHook() string {
+	if !d.isGlobal() || envir.IsDevboxShellEnabled() {
+		return ""
+	}
+	var b strings.Builder
+	for _, cmd := range d.cfg.Root.ShellenvHook().Cmds {
+		if strings.TrimSpace(cmd) != "" {
+			b.WriteString(cmd)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
 
-	if opts.RunHooks {
-		hooksStr := ". " + shellgen.ScriptPath(d.ProjectDir(), shellgen.HooksFilename)
-		envStr = fmt.Sprintf("%s\n%s;\n", envStr, hooksStr)
+// warnInvalidEnvNames warns about the variables in envs that can't be
+// exported because their names aren't valid shell identifiers. Nix sets
+// some of these itself (e.g. exported bash functions), so they're only
+// reported if devbox.json or a plugin set them.
+func (d *Devbox) warnInvalidEnvNames(envs map[string]string) {
+	configEnv := d.cfg.Env()
+	var names []string
+	for _, name := range invalidEnvNames(envs) {
+		if _, ok := configEnv[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		ux.Fwarningf(
+			d.stderr,
+			"Skipping environment variables with invalid names: %s. Names may only "+
+				"contain letters, digits and underscores, and can't start with a digit.\n",
+			strings.Join(names, ", "),
+		)
 	}
+}
 
-	if !opts.NoRefreshAlias {
-		envStr += "\n" + d.refreshAlias()
+// mergeCaseCollidingEnv merges the variables in envs whose names differ only
+// by case from one that devbox.json or a plugin sets, keeping the config's,
+// and warns about each one. Some tools on macOS and Windows treat names like
+// PATH and Path as the same variable, so exporting both behaves differently
+// from shell to shell.
+func (d *Devbox) mergeCaseCollidingEnv(envs map[string]string) {
+	for _, c := range mergeCaseCollisions(envs, d.cfg.Env()) {
+		ux.Fwarningf(
+			d.stderr,
+			"Environment variable %s differs only by case from %s. Using %s from the config.\n",
+			c.Kept, strings.Join(c.Dropped, ", "), c.Kept,
+		)
+	}
+}
+
+// exportEnv computes the environment that EnvExports and Shellenv export.
+func (d *Devbox) exportEnv(ctx context.Context, opts devopt.EnvExportsOpts) (map[string]string, error) {
+	envs, err := d.computeExportEnv(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if caseInsensitiveEnv {
+		d.mergeCaseCollidingEnv(envs)
+	}
+	if opts.SnapshotPath != "" {
+		if err := d.writeEnvSnapshot(opts.SnapshotPath, envs); err != nil {
+			return nil, err
+		}
+	}
+	if opts.OnChange == nil {
+		return envs, nil
+	}
+	changed, err := d.envChangedKeys(envs)
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) > 0 {
+		if err := opts.OnChange(changed); err != nil {
+			return nil, err
+		}
+	}
+	return envs, nil
+}
+
+func (d *Devbox) computeExportEnv(ctx context.Context, opts devopt.EnvExportsOpts) (map[string]string, error) {
+	if !opts.DontRecomputeEnvironment {
+		return d.ensureStateIsUpToDateAndComputeEnv(ctx, opts.EnvOptions)
 	}
 
-	return envStr, nil
+	upToDate, _ := d.lockfile.IsUpToDateAndInstalled(isFishShell())
+	if !upToDate {
+		ux.FHidableWarning(
+			ctx,
+			d.stderr,
+			StateOutOfDateMessage,
+			d.refreshAliasOrCommand(),
+		)
+	}
+	return d.computeEnv(ctx, true /*usePrintDevEnvCache*/, opts.EnvOptions)
 }
 
 func (d *Devbox) EnvVars(ctx context.Context) ([]string, error) {
@@ -572,6 +841,10 @@ func (d *Devbox) GenerateEnvrcFile(ctx context.Context, force bool, envFlags dev
 
 // saveCfg writes the config file to the devbox directory.
 func (d *Devbox) saveCfg() error {
+	if d.isGlobal() && envir.SortGlobalPackages() {
+		// Keep diffs stable for users who version control their global config.
+		d.cfg.PackageMutator().Sort()
+	}
 	return d.cfg.Root.SaveTo(d.ProjectDir())
 }
 
@@ -719,10 +992,7 @@ func (d *Devbox) computeEnv(
 	}
 	slog.Debug("nix environment PATH", "path", env["PATH"])
 
-	env["PATH"] = envpath.JoinPathLists(
-		nix.ProfileBinPath(d.projectDir),
-		env["PATH"],
-	)
+	env["PATH"] = envpath.JoinPathLists(d.ProfileBinPath(), env["PATH"])
 
 	wd, err := os.Getwd()
 	if err != nil {
@@ -735,14 +1005,20 @@ func (d *Devbox) computeEnv(
 	env["DEVBOX_CONFIG_DIR"] = d.projectDir + "/devbox.d"
 	env["DEVBOX_PACKAGES_DIR"] = d.projectDir + "/" + nix.ProfilePath
 
-	// Include env variables in devbox.json
-	configEnv, err := d.configEnvs(ctx, env)
+	// Include env variables from plugins and devbox.json
+	envSources, err := d.envSources(ctx, envOpts.ExpandConfigEnv)
 	if err != nil {
 		return nil, err
 	}
-	addEnvIfNotPreviouslySetByDevbox(env, configEnv)
-
-	markEnvsAsSetByDevbox(configEnv)
+	beforeSources := maps.Clone(env)
+	env, err = ComputeEnv(env, envSources)
+	if err != nil {
+		return nil, usererr.New("failed expanding env in devbox.json. Error: %v", err)
+	}
+	// Mark the variables from plugins and devbox.json as set by devbox so
+	// that `devbox shellenv --list-owned` and `--on-exit` can tell them
+	// apart from inherited ones.
+	markSetByDevbox(env, beforeSources)
 
 	// devboxEnvPath starts with the initial PATH from print-dev-env, and is
 	// transformed to be the "PATH of the Devbox environment"
@@ -780,7 +1056,7 @@ func (d *Devbox) computeEnv(
 	// TODO(gcurtis): this is a massive hack. Please get rid
 	// of this and install the package to the profile.
 	if len(glibcPatchPath) != 0 {
-		patchedPath := strings.Join(glibcPatchPath, string(filepath.ListSeparator))
+		patchedPath := envpath.JoinList(glibcPatchPath...)
 		devboxEnvPath = envpath.JoinPathLists(patchedPath, devboxEnvPath)
 		slog.Debug("PATH after glibc-patch hack", "path", devboxEnvPath)
 	}
@@ -790,13 +1066,23 @@ func (d *Devbox) computeEnv(
 		return nil, err
 	}
 	devboxEnvPath = envpath.JoinPathLists(devboxEnvPath, runXPaths)
+	if envOpts.MergePathBin {
+		devboxEnvPath, err = mergePathBin(devboxEnvPath, d.mergedBinDir())
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	pathStack := envpath.Stack(env, originalEnv)
 	pathStack.Push(env, d.ProjectDirHash(), devboxEnvPath, envOpts.PreservePathStack)
 	env["PATH"] = pathStack.Path(env)
 	slog.Debug("new path stack is", "path_stack", pathStack)
+	markPathSetByDevbox(env, d.ProjectDirHash())
 
 	slog.Debug("computed environment PATH", "path", env["PATH"])
+	if len(env["PATH"]) > longPathThreshold {
+		ux.FHidableWarning(ctx, d.stderr, longPathWarning, len(env["PATH"]))
+	}
 
 	if !envOpts.Pure {
 		// preserve the original XDG_DATA_DIRS by prepending to it
@@ -844,6 +1130,23 @@ func (d *Devbox) ensureStateIsUpToDateAndComputeEnv(
 	return d.computeEnv(ctx, true /*usePrintDevEnvCache*/, envOpts)
 }
 
+// ProfileBinPath returns the bin directory of d's nix profile, which
+// computeEnv puts at the front of PATH. For the global profile, it's also the
+// directory that EnsureGlobalProfileInPath looks for in PATH, and it goes
+// through the current link (see [envir.DevboxGlobalCurrentLink]) so that
+// switching profiles doesn't require a new PATH.
+func (d *Devbox) ProfileBinPath() string {
+	dataDir, err := globalDataDir()
+	if err != nil || d.projectDir != filepath.Join(dataDir, currentGlobalProfile) {
+		return nix.ProfileBinPath(d.projectDir)
+	}
+	currentPath, err := globalCurrentLinkPath(dataDir)
+	if err != nil {
+		return nix.ProfileBinPath(d.projectDir)
+	}
+	return nix.ProfileBinPath(currentPath)
+}
+
 func (d *Devbox) nixPrintDevEnvCachePath() string {
 	return filepath.Join(d.projectDir, ".devbox/.nix-print-dev-env-cache")
 }
@@ -907,8 +1210,9 @@ func (d *Devbox) findPackageByName(name string) (*devpkg.Package, error) {
 		return nil, errors.New("package name cannot be empty")
 	}
 	results := map[*devpkg.Package]bool{}
+	normalized := devpkg.NormalizeRaw(name)
 	for _, pkg := range d.TopLevelPackages() {
-		if pkg.Raw == name || pkg.CanonicalName() == name {
+		if pkg.Raw == name || pkg.CanonicalName() == name || devpkg.NormalizeRaw(pkg.Raw) == normalized {
 			results[pkg] = true
 		}
 	}
@@ -955,16 +1259,16 @@ func (d *Devbox) checkOldEnvrc() error {
 	return nil
 }
 
-// configEnvs takes the existing environment (nix + plugin) and adds env
-// variables defined in Config. It also parses variables in config
-// that are referenced by $VAR or ${VAR} and replaces them with
-// their value in the existing env variables. Note, this doesn't
-// allow env variables from outside the shell to be referenced so
-// no leaked variables are caused by this function.
-func (d *Devbox) configEnvs(
+// envSources returns the env variables from plugins and from Config (including
+// secrets and .env files), in the order that ComputeEnv should apply them.
+// Variables that are referenced by $VAR or ${VAR} are replaced with their
+// value in the environment computed so far. Note, this doesn't allow env
+// variables from outside the shell to be referenced so no leaked variables are
+// caused by this function.
+func (d *Devbox) envSources(
 	ctx context.Context,
-	existingEnv map[string]string,
-) (map[string]string, error) {
+	expandReferences bool,
+) ([]EnvSource, error) {
 	defer debug.FunctionTimer().End()
 	env := map[string]string{}
 	if d.cfg.IsEnvsecEnabled() {
@@ -982,7 +1286,7 @@ func (d *Devbox) configEnvs(
 			cloudSecrets, err := secrets.List(ctx)
 			if err != nil {
 				ux.Fwarningf(
-					os.Stderr,
+					d.stderr,
 					"Error reading secrets from jetify cloud: %s\n\n",
 					err,
 				)
@@ -1014,10 +1318,27 @@ func (d *Devbox) configEnvs(
 			"jetpack-cloud",
 		)
 	}
-	for k, v := range d.cfg.Env() {
+	for k, v := range d.cfg.Root.Env {
 		env[k] = v
 	}
-	return conf.OSExpandEnvMap(env, existingEnv, d.ProjectDir()), nil
+	sources := []EnvSource{
+		expandedEnv{vars: d.cfg.PluginEnv(), projectDir: d.ProjectDir(), recursive: expandReferences},
+	}
+	if d.isGlobal() {
+		sources = append(sources, expandedEnv{vars: d.globalPackageEnv(), projectDir: d.ProjectDir(), recursive: expandReferences})
+	}
+	return append(sources, expandedEnv{vars: env, projectDir: d.ProjectDir(), recursive: expandReferences}), nil
+}
+
+// globalPackageEnv returns the env variables of the packages in the global
+// config. Like plugin env, they apply before the config's own env, and a
+// package that comes later in the config takes precedence over an earlier one.
+func (d *Devbox) globalPackageEnv() map[string]string {
+	env := map[string]string{}
+	for _, pkg := range d.InstallablePackages() {
+		maps.Copy(env, pkg.Env)
+	}
+	return env
 }
 
 // ignoreCurrentEnvVar contains environment variables that Devbox should remove
@@ -1090,6 +1411,12 @@ func (d *Devbox) parseEnvAndExcludeSpecialCases(currentEnv []string, pure bool)
 		if ignoreCurrentEnvVar[key] {
 			continue
 		}
+		// Markers describe the devbox environment that's being replaced.
+		// computeEnv marks the variables of the new one itself, and
+		// leaving old markers would stop it from updating their values.
+		if strings.HasPrefix(key, devboxSetPrefix) {
+			continue
+		}
 		// handling special cases for pure shell
 		// - HOME required for devbox binary to work
 		// - PATH to find the nix installation. It is cleaned for pure mode below.
@@ -6,6 +6,7 @@
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +21,7 @@
 	"go.jetpack.io/devbox/internal/devconfig"
 	"go.jetpack.io/devbox/internal/envir"
 	"go.jetpack.io/devbox/internal/nix"
+	"go.jetpack.io/devbox/internal/shenv"
 )
 
 func TestDevbox(t *testing.T) {
@@ -53,6 +55,11 @@ func testShellPlan(t *testing.T, testPath string) {
 
 type testNix struct {
 	path string
+
+	// removed and rolledBack record the calls to ProfileRemove and
+	// ProfileRollback, which don't change the profile.
+	removed    [][]string
+	rolledBack []int
 }
 
 func (n *testNix) PrintDevEnv(ctx context.Context, args *nix.PrintDevEnvArgs) (*nix.PrintDevEnvOut, error) {
@@ -66,6 +73,16 @@ func (n *testNix) PrintDevEnv(ctx context.Context, args *nix.PrintDevEnvArgs) (*
 	}, nil
 }
 
+func (n *testNix) ProfileRemove(profilePath string, packageNames ...string) error {
+	n.removed = append(n.removed, packageNames)
+	return nil
+}
+
+func (n *testNix) ProfileRollback(ctx context.Context, profilePath string, generation int) error {
+	n.rolledBack = append(n.rolledBack, generation)
+	return nil
+}
+
 func TestComputeEnv(t *testing.T) {
 	d := devboxForTesting(t)
 	d.nix = &testNix{}
@@ -77,7 +94,7 @@ func TestComputeEnv(t *testing.T) {
 
 func TestComputeDevboxPathIsIdempotent(t *testing.T) {
 	devbox := devboxForTesting(t)
-	devbox.nix = &testNix{"/tmp/my/path"}
+	devbox.nix = &testNix{path: "/tmp/my/path"}
 	ctx := context.Background()
 	env, err := devbox.computeEnv(ctx, false /*use cache*/, devopt.EnvOptions{})
 	require.NoError(t, err, "computeEnv should not fail")
@@ -98,7 +115,7 @@ func TestComputeDevboxPathIsIdempotent(t *testing.T) {
 
 func TestComputeDevboxPathWhenRemoving(t *testing.T) {
 	devbox := devboxForTesting(t)
-	devbox.nix = &testNix{"/tmp/my/path"}
+	devbox.nix = &testNix{path: "/tmp/my/path"}
 	ctx := context.Background()
 	env, err := devbox.computeEnv(ctx, false /*use cache*/, devopt.EnvOptions{})
 	require.NoError(t, err, "computeEnv should not fail")
@@ -120,6 +137,88 @@ func TestComputeDevboxPathWhenRemoving(t *testing.T) {
 	assert.NotEqual(t, path, path2, "path should not be the same")
 }
 
+func TestShellenvReadonly(t *testing.T) {
+	d := devboxForTesting(t)
+	d.nix = &testNix{}
+	ctx := context.Background()
+	opts := devopt.EnvExportsOpts{
+		DontRecomputeEnvironment: true,
+		Readonly:                 map[string]bool{"DEVBOX_PROJECT_ROOT": true, "MISSING": true},
+	}
+
+	var b strings.Builder
+	require.NoError(t, d.Shellenv(ctx, shenv.Bash, &b, opts))
+	want := "export DEVBOX_PROJECT_ROOT=" + shenv.BashEscape(d.projectDir) + ";\nreadonly DEVBOX_PROJECT_ROOT;\n"
+	assert.Contains(t, b.String(), want)
+	assert.Equal(t, 1, strings.Count(b.String(), "\nreadonly "), "only DEVBOX_PROJECT_ROOT should be read-only")
+
+	// Fish and elvish have no read-only environment variables.
+	for _, sh := range []shenv.Shell{shenv.Fish, shenv.Elvish} {
+		b.Reset()
+		err := d.Shellenv(ctx, sh, &b, opts)
+		assert.Error(t, err, "%s shellenv with read-only variables", sh.Name())
+		assert.Empty(t, b.String(), "%s shellenv wrote exports before failing", sh.Name())
+	}
+
+	// The default doesn't mark anything read-only.
+	b.Reset()
+	opts.Readonly = nil
+	require.NoError(t, d.Shellenv(ctx, shenv.Bash, &b, opts))
+	assert.NotContains(t, b.String(), "\nreadonly ")
+}
+
+func TestEnvExportsReadonly(t *testing.T) {
+	d := devboxForTesting(t)
+	d.nix = &testNix{}
+	ctx := context.Background()
+	t.Setenv("SHELL", "/bin/bash")
+	t.Setenv("FISH_VERSION", "")
+	opts := devopt.EnvExportsOpts{
+		DontRecomputeEnvironment: true,
+		NoRefreshAlias:           true,
+		Readonly:                 map[string]bool{"DEVBOX_PROJECT_ROOT": true},
+	}
+
+	got, err := d.EnvExports(ctx, opts)
+	require.NoError(t, err)
+	assert.Contains(t, got, "export DEVBOX_PROJECT_ROOT=\""+d.projectDir+"\";\nreadonly DEVBOX_PROJECT_ROOT;\n")
+
+	t.Setenv("SHELL", "/usr/bin/fish")
+	_, err = d.EnvExports(ctx, opts)
+	assert.Error(t, err, "EnvExports with read-only variables in fish")
+}
+
+func TestWriteEnvExports(t *testing.T) {
+	d := devboxForTesting(t)
+	d.nix = &testNix{}
+	ctx := context.Background()
+	opts := devopt.EnvExportsOpts{DontRecomputeEnvironment: true}
+
+	var b strings.Builder
+	require.NoError(t, d.WriteEnvExports(ctx, &b, opts))
+	assert.Contains(t, b.String(), "export DEVBOX_PROJECT_ROOT=\""+d.projectDir+"\";\n")
+	assert.True(t, strings.HasSuffix(b.String(), "\n"), "the last line should end in a newline")
+
+	err := d.WriteEnvExports(ctx, errWriter{}, opts)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func TestShellInitHook(t *testing.T) {
+	dir := "/home/me/my projects/global"
+	d := &Devbox{projectDir: dir}
+
+	got, err := d.ShellInitHook(shenv.Bash)
+	require.NoError(t, err)
+	assert.Contains(t, got, `eval "$(devbox shellenv --config `+shenv.Bash.QuotePath(dir)+`)"`)
+	assert.NotContains(t, got, "PROMPT_COMMAND")
+
+	got, err = d.ShellInitHook(shenv.Elvish)
+	require.NoError(t, err)
+	assert.Contains(t, got, "has-external devbox")
+	assert.Contains(t, got, "eval (devbox shellenv --config "+shenv.Elvish.QuotePath(dir)+" | slurp)")
+	assert.NotContains(t, got, "before-readline")
+}
+
 func devboxForTesting(t *testing.T) *Devbox {
 	path := t.TempDir()
 	_, err := devconfig.Init(path)
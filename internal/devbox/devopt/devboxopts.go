@@ -15,6 +15,12 @@ type Opts struct {
 	IgnoreWarnings           bool
 	CustomProcessComposeFile string
 	Stderr                   io.Writer
+	// Timings records how long each phase of an operation takes. See
+	// Devbox.PrintTimings.
+	Timings bool
+	// Verbose streams nix's own output, including build logs, to Stderr
+	// when installing packages.
+	Verbose bool
 }
 
 type ProcessComposeOpts struct {
@@ -38,6 +44,16 @@ type PullboxOpts struct {
 	Overwrite   bool
 	URL         string
 	Credentials Credentials
+	// ConfigName is the name of the config file to use when URL is a
+	// directory or repository. It's installed as devbox.json. If empty, the
+	// directory must contain a devbox.json.
+	ConfigName string
+	// Preview fetches the config and prints its packages and how they
+	// differ from the project's without changing any files.
+	Preview bool
+	// NoInstall copies the pulled config into the project without
+	// installing its packages, which is left to a later install.
+	NoInstall bool
 }
 
 type Credentials struct {
@@ -54,6 +70,30 @@ type AddOpts struct {
 	DisablePlugin    bool
 	Patch            string
 	Outputs          []string
+	Priority         *int
+	// KeepGoing skips packages that fail to validate instead of failing the
+	// whole add.
+	KeepGoing bool
+	// ErrorOnSkipped returns an error listing the packages that KeepGoing
+	// skipped, after adding the rest.
+	ErrorOnSkipped bool
+	// SkipValidation adds packages without first checking that they exist
+	// and have the selected outputs, leaving nix to fail the install if
+	// they don't.
+	SkipValidation bool
+	// Force reinstalls global packages that are already in devbox.json and
+	// the nix profile. Without it, adding only such packages doesn't run
+	// nix at all.
+	Force bool
+}
+
+type RemoveOpts struct {
+	// PruneGroups deletes the package groups that the removal leaves
+	// without any packages in devbox.json.
+	PruneGroups bool
+	// Indexes are the indexes of global profile elements, as listed by `nix
+	// profile list`, whose packages to remove in addition to the named ones.
+	Indexes []int
 }
 
 type UpdateOpts struct {
@@ -64,8 +104,27 @@ type UpdateOpts struct {
 type EnvExportsOpts struct {
 	DontRecomputeEnvironment bool
 	EnvOptions               EnvOptions
-	NoRefreshAlias           bool
-	RunHooks                 bool
+	// Header starts the exports with a comment saying which project and
+	// devbox version generated them, and when.
+	Header         bool
+	NoRefreshAlias bool
+	// PathLast exports PATH and other list-like variables after everything
+	// else instead of in alphabetical order.
+	PathLast bool
+	// Readonly names the variables to mark as read-only after exporting
+	// them, so that the shell protects them from accidental reassignment.
+	// Shells without read-only environment variables, such as fish and
+	// elvish, reject it.
+	Readonly map[string]bool
+	RunHooks bool
+	// OnChange is called with the names of the variables that were added,
+	// removed or changed since the previous export of the environment that
+	// set OnChange. It isn't called on the first export or when nothing
+	// changed.
+	OnChange func(changed []string) error
+	// SnapshotPath is a file to save the exported environment to, so that
+	// it can be restored later with [devbox.ReadEnvSnapshot].
+	SnapshotPath string
 }
 
 // EnvOptions configure the Devbox Environment in the `computeEnv` function.
@@ -73,6 +132,13 @@ type EnvExportsOpts struct {
 // like `shellenv`, `shell` and `run`.
 // - The struct is designed for the "common case" to be zero-initialized as `EnvOptions{}`.
 type EnvOptions struct {
+	// ExpandConfigEnv resolves references between env vars in devbox.json
+	// (such as GOBIN=$GOPATH/bin) instead of only expanding references to
+	// the existing environment.
+	ExpandConfigEnv bool
+	// MergePathBin replaces the nix store directories in the PATH of the
+	// environment with a single directory of symlinks, to keep PATH short.
+	MergePathBin      bool
 	OmitNixEnv        bool
 	PreservePathStack bool
 	Pure              bool
@@ -0,0 +1,83 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"go.jetpack.io/devbox/internal/cachehash"
+)
+
+func (d *Devbox) envSnapshotPath() string {
+	return filepath.Join(d.projectDir, ".devbox/.shellenv-snapshot")
+}
+
+// envChangedKeys compares env to the environment that was saved by the
+// previous call and returns the names of the variables that differ, in
+// sorted order. It then saves env for the next call. The first call returns
+// no names, since there's nothing to compare to.
+//
+// The snapshot only has a hash of each value, so it doesn't write secrets
+// from the environment to disk.
+func (d *Devbox) envChangedKeys(env map[string]string) ([]string, error) {
+	current := make(map[string]string, len(env))
+	for k, v := range env {
+		// The hash of the whole environment changes along with any
+		// other variable, so it's never a change of its own.
+		if k == d.shellEnvHashKey() {
+			continue
+		}
+		current[k] = cachehash.Bytes([]byte(v))
+	}
+
+	path := d.envSnapshotPath()
+	var previous map[string]string
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &previous); err != nil {
+			slog.Debug("ignoring invalid env snapshot", "path", path, "err", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	data, err = json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, err
+	}
+	if previous == nil {
+		return nil, nil
+	}
+	return diffEnvHashes(previous, current), nil
+}
+
+// diffEnvHashes returns the sorted names of the variables that are in only
+// one of old and new, or whose values differ.
+func diffEnvHashes(old, new map[string]string) []string {
+	var changed []string
+	for k, v := range new {
+		if prev, ok := old[k]; !ok || prev != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
@@ -0,0 +1,31 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvChangedKeys(t *testing.T) {
+	d := &Devbox{projectDir: t.TempDir()}
+
+	changed, err := d.envChangedKeys(map[string]string{"PATH": "/a", "GOROOT": "/go"})
+	require.NoError(t, err)
+	assert.Empty(t, changed, "first call has nothing to compare to")
+
+	changed, err = d.envChangedKeys(map[string]string{
+		"PATH":              "/a",
+		"GOROOT":            "/go",
+		d.shellEnvHashKey(): "hash",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, changed, "the env hash alone isn't a change")
+
+	changed, err = d.envChangedKeys(map[string]string{"PATH": "/b", "CARGO_HOME": "/cargo"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CARGO_HOME", "GOROOT", "PATH"}, changed)
+}
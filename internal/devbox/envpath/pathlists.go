@@ -6,12 +6,37 @@
 	"strings"
 )
 
+// listSeparator separates the paths in a path list such as PATH. It's
+// [os.PathListSeparator] except in tests, which change it to check path
+// lists with the separator of another OS.
+var listSeparator = os.PathListSeparator
+
+// SplitList splits a path list into its paths. Unlike strings.Split, it
+// returns an empty slice for an empty list.
+func SplitList(list string) []string {
+	if listSeparator == os.PathListSeparator {
+		// filepath.SplitList also handles quoted paths on Windows.
+		return filepath.SplitList(list)
+	}
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, string(listSeparator))
+}
+
+// JoinList joins paths into a path list. It's the inverse of [SplitList] and
+// doesn't clean the paths. Use [JoinPathLists] to clean them.
+func JoinList(paths ...string) string {
+	return strings.Join(paths, string(listSeparator))
+}
+
 // JoinPathLists joins and cleans PATH-style strings of
-// [os.ListSeparator] delimited paths. To clean a path list, it splits it and
-// does the following for each element:
+// [os.PathListSeparator] delimited paths. To clean a path list, it splits it
+// and does the following for each element:
 //
 //  1. Applies [filepath.Clean].
-//  2. Removes the path if it's relative (must begin with '/' and not be '.').
+//  2. Removes the path if it's relative (must begin with '/', or a drive
+//     letter or \\ on Windows, and not be '.').
 //  3. Removes the path if it's a duplicate.
 func JoinPathLists(pathLists ...string) string {
 	if len(pathLists) == 0 {
@@ -21,9 +46,9 @@ func JoinPathLists(pathLists ...string) string {
 	seen := make(map[string]bool)
 	var cleaned []string
 	for _, path := range pathLists {
-		for _, path := range filepath.SplitList(path) {
+		for _, path := range SplitList(path) {
 			path = filepath.Clean(path)
-			if path == "." || path[0] != '/' {
+			if path == "." || !isAbs(path) {
 				// Remove empty paths and don't allow relative
 				// paths for security reasons.
 				continue
@@ -34,11 +59,25 @@ func JoinPathLists(pathLists ...string) string {
 			seen[path] = true
 		}
 	}
-	return strings.Join(cleaned, string(filepath.ListSeparator))
+	return JoinList(cleaned...)
+}
+
+// isAbs reports whether path is absolute on Unix or Windows. Unlike
+// [filepath.IsAbs], it doesn't depend on the current OS, so that path lists
+// are cleaned the same way for both separators.
+func isAbs(path string) bool {
+	switch {
+	case strings.HasPrefix(path, "/"), strings.HasPrefix(path, `\\`):
+		return true
+	case len(path) >= 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/'):
+		drive := path[0] | 0x20 // ASCII lowercase
+		return drive >= 'a' && drive <= 'z'
+	}
+	return false
 }
 
 func RemoveFromPath(path, pathToRemove string) string {
-	paths := filepath.SplitList(path)
+	paths := SplitList(path)
 
 	// Create a new slice to store the modified paths
 	var newPaths []string
@@ -50,8 +89,6 @@ func RemoveFromPath(path, pathToRemove string) string {
 		}
 	}
 
-	// Join the modified paths using ":" as the delimiter
-	newPath := strings.Join(newPaths, string(os.PathListSeparator))
-
-	return newPath
+	// Join the modified paths using the list separator as the delimiter
+	return JoinList(newPaths...)
 }
@@ -30,3 +30,52 @@ func TestCleanEnvPath(t *testing.T) {
 		})
 	}
 }
+
+// setListSeparator changes the separator of path lists for the rest of a
+// test.
+func setListSeparator(t *testing.T, sep rune) {
+	t.Helper()
+	orig := listSeparator
+	listSeparator = sep
+	t.Cleanup(func() { listSeparator = orig })
+}
+
+func TestPathListsSeparators(t *testing.T) {
+	tests := []struct {
+		sep         rune
+		lists       []string
+		joined      string
+		remove      string
+		afterRemove string
+	}{
+		{
+			sep:         ':',
+			lists:       []string{"/nix/store/a/bin:/usr/bin", "/usr/bin::relative:/bin"},
+			joined:      "/nix/store/a/bin:/usr/bin:/bin",
+			remove:      "/usr/bin",
+			afterRemove: "/nix/store/a/bin:/bin",
+		},
+		{
+			sep:         ';',
+			lists:       []string{`C:\devbox\bin;C:\Windows`, `C:\Windows;;relative;\\server\share;d:/tools`},
+			joined:      `C:\devbox\bin;C:\Windows;\\server\share;d:/tools`,
+			remove:      `C:\Windows`,
+			afterRemove: `C:\devbox\bin;\\server\share;d:/tools`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(string(test.sep), func(t *testing.T) {
+			setListSeparator(t, test.sep)
+			got := JoinPathLists(test.lists...)
+			if got != test.joined {
+				t.Errorf("got joined path list %q, want %q", got, test.joined)
+			}
+			if got := RemoveFromPath(got, test.remove); got != test.afterRemove {
+				t.Errorf("got %q after removing %s, want %q", got, test.remove, test.afterRemove)
+			}
+			if got := SplitList(""); len(got) != 0 {
+				t.Errorf("got %q for an empty path list, want no paths", got)
+			}
+		})
+	}
+}
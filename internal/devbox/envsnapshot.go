@@ -0,0 +1,104 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+	"go.jetpack.io/devbox/internal/build"
+	"go.jetpack.io/devbox/internal/shenv"
+)
+
+// envSnapshotVersion is the version of the [EnvSnapshot] format. Increment it
+// when the format changes in a way that older versions of devbox can't read.
+const envSnapshotVersion = 1
+
+// EnvSnapshot is a computed environment saved to a file with `devbox
+// shellenv --snapshot`, so that it can be applied again later with
+// --restore, even after devbox.json changed or on another machine.
+type EnvSnapshot struct {
+	Version       int       `json:"version"`
+	DevboxVersion string    `json:"devbox_version"`
+	ProjectDir    string    `json:"project_dir"`
+	Created       time.Time `json:"created"`
+
+	// Env has every exported variable, including the __DEVBOX_SET_
+	// markers of the variables that devbox set, so a restored environment
+	// has the same owned variables as the original.
+	Env map[string]string `json:"env"`
+
+	// Owned lists the variables that the markers in Env mark as set by
+	// devbox. It's informational: restoring only uses Env.
+	Owned []string `json:"owned,omitempty"`
+}
+
+// writeEnvSnapshot saves env to the file at path.
+func (d *Devbox) writeEnvSnapshot(path string, env map[string]string) error {
+	snapshot := EnvSnapshot{
+		Version:       envSnapshotVersion,
+		DevboxVersion: build.Version,
+		ProjectDir:    d.projectDir,
+		Created:       d.timeNow().UTC(),
+		Env:           env,
+	}
+	for k := range env {
+		if owned, ok := strings.CutPrefix(k, devboxSetPrefix); ok && owned != "" {
+			snapshot.Owned = append(snapshot.Owned, owned)
+		}
+	}
+	slices.Sort(snapshot.Owned)
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(path, append(data, '\n'), 0o600))
+}
+
+// ReadEnvSnapshot reads a snapshot that `devbox shellenv --snapshot` wrote to
+// path.
+func ReadEnvSnapshot(path string) (*EnvSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	snapshot := &EnvSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, usererr.WithUserMessage(err, "%s isn't a devbox environment snapshot.", path)
+	}
+	if snapshot.Version != envSnapshotVersion {
+		return nil, usererr.New(
+			"The environment snapshot %s has version %d, but this version of devbox only reads version %d.",
+			path, snapshot.Version, envSnapshotVersion,
+		)
+	}
+	return snapshot, nil
+}
+
+// Exports returns the commands that apply the snapshot's environment to a
+// POSIX shell, or to sh if it isn't nil.
+func (s *EnvSnapshot) Exports(sh shenv.Shell) string {
+	if sh == nil {
+		return exportify(s.Env)
+	}
+	return shellExports(sh, s.Env)
+}
+
+// shellExports returns the commands that export vars in the syntax of sh,
+// one per line and in the same order as exportify.
+func shellExports(sh shenv.Shell, vars map[string]string) string {
+	var b strings.Builder
+	for _, k := range exportKeys(vars) {
+		export := shenv.ShellExport{}
+		export.Add(k, vars[k])
+		b.WriteString(strings.TrimSuffix(sh.Export(export), "\n") + "\n")
+	}
+	return b.String()
+}
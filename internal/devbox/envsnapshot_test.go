@@ -0,0 +1,47 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.jetpack.io/devbox/internal/shenv"
+)
+
+func TestEnvSnapshotRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	d := &Devbox{projectDir: "/home/user/project", now: func() time.Time { return created }}
+	env := map[string]string{
+		"PATH":                     "/nix/store/a/bin:/usr/bin",
+		"GOROOT":                   "/nix/store/go",
+		devboxSetPrefix + "GOROOT": "1",
+	}
+	path := filepath.Join(t.TempDir(), "env.json")
+	require.NoError(t, d.writeEnvSnapshot(path, env))
+
+	snapshot, err := ReadEnvSnapshot(path)
+	require.NoError(t, err)
+	assert.Equal(t, env, snapshot.Env)
+	assert.Equal(t, []string{"GOROOT"}, snapshot.Owned)
+	assert.Equal(t, d.projectDir, snapshot.ProjectDir)
+	assert.True(t, created.Equal(snapshot.Created))
+
+	assert.Equal(t, exportify(env), snapshot.Exports(nil))
+	fish, ok := shenv.ShellByName("fish")
+	require.True(t, ok)
+	assert.Contains(t, snapshot.Exports(fish), "set -x -g '"+devboxSetPrefix+"GOROOT' '1';")
+}
+
+func TestReadEnvSnapshotVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 99, "env": {}}`), 0o644))
+	_, err := ReadEnvSnapshot(path)
+	assert.ErrorContains(t, err, "version 99")
+}
@@ -4,12 +4,24 @@
 package devbox
 
 import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
 	"os"
+	"runtime"
 	"slices"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
+
+	"go.jetpack.io/devbox/internal/build"
+	"go.jetpack.io/devbox/internal/conf"
 	"go.jetpack.io/devbox/internal/devbox/envpath"
 	"go.jetpack.io/devbox/internal/envir"
+	"go.jetpack.io/devbox/internal/shenv"
 )
 
 const devboxSetPrefix = "__DEVBOX_SET_"
@@ -20,39 +32,303 @@
 // literal strings; no variable expansion or command substitution will take
 // place.
 func exportify(vars map[string]string) string {
-	keys := make([]string, len(vars))
-	i := 0
+	return formatExports(vars, exportKeys(vars))
+}
+
+// timeNow returns the current time from d.now, or from [time.Now] if d.now
+// isn't set.
+func (d *Devbox) timeNow() time.Time {
+	if d.now == nil {
+		return time.Now()
+	}
+	return d.now()
+}
+
+// exportsHeader returns a comment that says which project and devbox version
+// generated a set of exports, and when. Every shell that devbox exports for
+// uses # for comments, so the header is valid in all of them. Each line of the
+// text is commented, so a project path with a newline can't escape it.
+func (d *Devbox) exportsHeader() string {
+	text := fmt.Sprintf(
+		"generated by devbox %s for %s at %s",
+		build.Version, d.projectDir, d.timeNow().Format(time.RFC3339),
+	)
+	return "# " + strings.ReplaceAll(text, "\n", "\n# ") + "\n"
+}
+
+// exportKeys returns the keys of vars in the order that exportify exports
+// them. It leaves out keys that aren't valid shell identifiers (see
+// [isValidEnvName]), since a single bad export would make the shell reject
+// the entire snippet.
+func exportKeys(vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
 	for k := range vars {
-		keys[i] = k
-		i++
+		if isValidEnvName(k) {
+			keys = append(keys, k)
+		}
 	}
 	slices.Sort(keys) // for reproducibility
+	return keys
+}
+
+// isValidEnvName reports whether name can be exported by a shell: it must
+// be made of ASCII letters, digits and underscores, and not start with a
+// digit. Environments can contain other names, such as the BASH_FUNC_name%%
+// variables that bash uses to export functions, but those can't be assigned
+// with export.
+func isValidEnvName(name string) bool {
+	if name == "" || ('0' <= name[0] && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') && !('0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidEnvNames returns the sorted keys of vars that exportify skips.
+func invalidEnvNames(vars map[string]string) []string {
+	var invalid []string
+	for k := range vars {
+		if !isValidEnvName(k) {
+			invalid = append(invalid, k)
+		}
+	}
+	slices.Sort(invalid)
+	return invalid
+}
+
+// caseInsensitiveEnv is true on platforms where some tools treat environment
+// variable names that differ only by case, like PATH and Path, as the same
+// variable.
+var caseInsensitiveEnv = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// An envCaseCollision is a group of environment variable names that differ
+// only by case, and the one that was kept when merging them.
+type envCaseCollision struct {
+	Kept    string
+	Dropped []string
+}
 
+// mergeCaseCollisions merges the variables in vars whose names differ only by
+// case when at least one of them is set by override, so that the exported
+// script doesn't set both. The override spelling and value win, since
+// override is applied last; if override sets several spellings, the one that
+// sorts last wins. Collisions between variables that override doesn't set are
+// left alone. It deletes the losing names from vars and returns the merged
+// collisions sorted by the kept name.
+func mergeCaseCollisions(vars, override map[string]string) []envCaseCollision {
+	groups := map[string][]string{}
+	for k := range vars {
+		folded := strings.ToUpper(k)
+		groups[folded] = append(groups[folded], k)
+	}
+
+	var collisions []envCaseCollision
+	for _, keys := range groups {
+		if len(keys) < 2 {
+			continue
+		}
+		slices.Sort(keys)
+		kept := ""
+		for _, k := range keys {
+			if _, ok := override[k]; ok {
+				kept = k
+			}
+		}
+		if kept == "" {
+			continue
+		}
+		collision := envCaseCollision{Kept: kept}
+		for _, k := range keys {
+			if k != kept {
+				delete(vars, k)
+				collision.Dropped = append(collision.Dropped, k)
+			}
+		}
+		collisions = append(collisions, collision)
+	}
+	slices.SortFunc(collisions, func(a, b envCaseCollision) int {
+		return strings.Compare(a.Kept, b.Kept)
+	})
+	return collisions
+}
+
+// exportifyPathLast is like exportify, but uses a dependency-aware ordering:
+// list-like variables that other values tend to reference, such as
+// LD_LIBRARY_PATH or XDG_DATA_DIRS, are exported after all other variables,
+// and PATH is always exported last. The order is still deterministic.
+func exportifyPathLast(vars map[string]string) string {
+	return formatExports(vars, exportKeysPathLast(vars))
+}
+
+// exportKeysPathLast returns the keys of vars in the order that
+// exportifyPathLast exports them.
+func exportKeysPathLast(vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		if isValidEnvName(k) {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(
+			cmp.Compare(exportRank(a), exportRank(b)),
+			strings.Compare(a, b),
+		)
+	})
+	return keys
+}
+
+// exportRank is the group a variable belongs to in exportifyPathLast.
+func exportRank(key string) int {
+	switch {
+	case key == "PATH":
+		return 2
+	case strings.HasSuffix(key, "PATH"), strings.HasSuffix(key, "_DIRS"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// formatExports returns the exports for vars in the order of keys.
+func formatExports(vars map[string]string, keys []string) string {
 	strb := strings.Builder{}
+	// Writing to a strings.Builder never fails.
+	_ = writeExports(&strb, vars, keys, nil)
+	return strings.TrimSpace(strb.String())
+}
+
+// writeExports is like formatExports, but streams the exports to w instead of
+// building the whole string in memory, which matters for environments with
+// hundreds of variables. Every export, including the last, ends in a newline.
+// The variables in readonly are marked read-only with a `readonly key;`
+// statement after their export.
+func writeExports(w io.Writer, vars map[string]string, keys []string, readonly map[string]bool) error {
+	bw := bufio.NewWriter(w)
 	for _, k := range keys {
-		strb.WriteString("export ")
-		strb.WriteString(k)
-		strb.WriteString(`="`)
-		for _, r := range vars[k] {
-			switch r {
+		bw.WriteString("export ")
+		bw.WriteString(k)
+		bw.WriteString(`="`)
+		// Loop over bytes instead of runes so that values that aren't
+		// valid UTF-8 are written unchanged.
+		value := vars[k]
+		for i := 0; i < len(value); i++ {
+			switch value[i] {
 			// Special characters inside double quotes:
 			// https://pubs.opengroup.org/onlinepubs/009604499/utilities/xcu_chap02.html#tag_02_02_03
-			case '$', '`', '"', '\\', '\n':
-				strb.WriteRune('\\')
+			//
+			// A newline is literal inside double quotes; escaping it
+			// would make it a line continuation that the shell removes.
+			case '$', '`', '"', '\\':
+				bw.WriteByte('\\')
 			}
-			strb.WriteRune(r)
+			bw.WriteByte(value[i])
+		}
+		bw.WriteString("\";\n")
+		if readonly[k] {
+			bw.WriteString("readonly ")
+			bw.WriteString(k)
+			bw.WriteString(";\n")
 		}
-		strb.WriteString("\";\n")
 	}
-	return strings.TrimSpace(strb.String())
+	// bufio.Writer keeps the first write error and returns it from Flush.
+	return errors.WithStack(bw.Flush())
+}
+
+// EnvSource is a set of environment variables that devbox layers on top of a
+// base environment, such as the env of a plugin or devbox.json.
+type EnvSource interface {
+	// Env returns the variables to set. env is the environment computed so
+	// far, which a source can use to expand references like PATH=$PATH:/bin.
+	// Implementations must not modify env.
+	Env(env map[string]string) (map[string]string, error)
+}
+
+// EnvMap is an EnvSource whose values are used as-is.
+type EnvMap map[string]string
+
+func (e EnvMap) Env(map[string]string) (map[string]string, error) {
+	return e, nil
+}
+
+// expandedEnv is an EnvSource that expands references in its values using the
+// environment computed so far.
+type expandedEnv struct {
+	vars       map[string]string
+	projectDir string
+
+	// recursive also resolves references between vars. See
+	// [conf.ExpandEnvMapRecursive].
+	recursive bool
+}
+
+func (e expandedEnv) Env(env map[string]string) (map[string]string, error) {
+	if !e.recursive {
+		return conf.OSExpandEnvMap(e.vars, env, e.projectDir), nil
+	}
+	return conf.ExpandEnvMapRecursive(e.vars, env, e.projectDir)
+}
+
+// ComputeEnv applies sources to a copy of base in order and returns the
+// result. Later sources take precedence over earlier ones, and each source
+// sees the variables set by the sources before it, so they can build on each
+// other (e.g. a plugin and devbox.json both adding to PATH). Variables that
+// base marks as previously set by devbox keep their value from base.
+func ComputeEnv(base map[string]string, sources []EnvSource) (map[string]string, error) {
+	env := make(map[string]string, len(base))
+	maps.Copy(env, base)
+	for _, src := range sources {
+		vars, err := src.Env(env)
+		if err != nil {
+			return nil, err
+		}
+		addEnvIfNotPreviouslySetByDevbox(env, vars)
+	}
+	return env, nil
+}
+
+// ComposeGlobalAndProjectEnv layers the env of a project on top of the env of
+// the global profile. It applies the global sources to base, then the project
+// sources to the result, so project variables take precedence over global
+// ones and project sources can build on global values such as PATH.
+//
+// The variables that the global sources set are marked as set by devbox in
+// the result, but only after the project sources are applied. That way, a
+// variable that base marks as set by devbox keeps its base value in both
+// layers, while the project can still override the global profile.
+//
+// This is a library-only hook: Devbox.computeEnv doesn't call it, so a
+// project shell still only applies the project's own sources. The global env
+// reaches a project shell only if the user's shell already evaluates
+// `devbox global shellenv`.
+func ComposeGlobalAndProjectEnv(base map[string]string, global, project []EnvSource) (map[string]string, error) {
+	env, err := ComputeEnv(base, global)
+	if err != nil {
+		return nil, err
+	}
+	var globalKeys []string
+	for k, v := range env {
+		if old, ok := base[k]; !ok || old != v {
+			globalKeys = append(globalKeys, k)
+		}
+	}
+
+	env, err = ComputeEnv(env, project)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range globalKeys {
+		env[devboxSetPrefix+k] = "1"
+	}
+	return env, nil
 }
 
 // addEnvIfNotPreviouslySetByDevbox adds the key-value pairs from new to existing,
-// but only if the key was not previously set by devbox
-// Caveat, this won't mark the values as set by devbox automatically. Instead,
-// you need to call markEnvAsSetByDevbox when you are done setting variables.
-// This is so you can add variables from multiple sources (e.g. plugin, devbox.json)
-// that may build on each other (e.g. PATH=$PATH:...)
+// but only if the key was not previously set by devbox.
 func addEnvIfNotPreviouslySetByDevbox(existing, new map[string]string) {
 	for k, v := range new {
 		if _, alreadySet := existing[devboxSetPrefix+k]; !alreadySet {
@@ -61,14 +337,86 @@ func addEnvIfNotPreviouslySetByDevbox(existing, new map[string]string) {
 	}
 }
 
-func markEnvsAsSetByDevbox(envs ...map[string]string) {
-	for _, env := range envs {
-		for key := range env {
-			env[devboxSetPrefix+key] = "1"
+// markSetByDevbox adds a __DEVBOX_SET_ marker to env for each variable that
+// devbox set or changed, which are the ones whose value differs from before.
+// Variables that devbox set to the value they already had aren't marked, so
+// undoing the environment leaves them alone.
+func markSetByDevbox(env, before map[string]string) {
+	for k, v := range env {
+		if strings.HasPrefix(k, devboxSetPrefix) {
+			continue
+		}
+		if old, ok := before[k]; !ok || old != v {
+			env[devboxSetPrefix+k] = "1"
+		}
+	}
+}
+
+// markPathSetByDevbox marks PATH, and the variables of the path stack that
+// built it, as set by devbox. Devbox always changes PATH, even for projects
+// without plugins or env in devbox.json.
+func markPathSetByDevbox(env map[string]string, projectHash string) {
+	for _, k := range []string{"PATH", envpath.PathStackEnv, envpath.InitPathEnv, envpath.Key(projectHash)} {
+		if _, ok := env[k]; ok {
+			env[devboxSetPrefix+k] = "1"
 		}
 	}
 }
 
+// OwnedEnvKeys returns the sorted names of the variables in the current
+// environment that devbox set, as marked by a matching __DEVBOX_SET_ variable.
+// Every other variable was inherited from the parent environment.
+func (d *Devbox) OwnedEnvKeys() []string {
+	return ownedEnvKeys(os.Environ())
+}
+
+func ownedEnvKeys(environ []string) []string {
+	var keys []string
+	for _, kv := range environ {
+		k, _, _ := strings.Cut(kv, "=")
+		if owned, ok := strings.CutPrefix(k, devboxSetPrefix); ok && owned != "" {
+			keys = append(keys, owned)
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// ExitEnvExports returns the commands, in the syntax of sh, that remove the
+// variables that devbox set in the current environment, so that leaving a
+// devbox shell doesn't leave them behind. See exitEnvExports.
+func (d *Devbox) ExitEnvExports(sh shenv.Shell) string {
+	return exitEnvExports(sh, os.Environ())
+}
+
+// exitEnvExports returns the commands that unset each variable in environ
+// that devbox owns, along with its __DEVBOX_SET_ marker. PATH is restored to
+// the value it had before any devbox environment was applied instead of being
+// unset, since a shell without a PATH can't run anything. Other owned
+// variables are unset even if they shadowed an inherited value, because
+// devbox doesn't keep the value it replaced.
+func exitEnvExports(sh shenv.Shell, environ []string) string {
+	env := envir.PairsToMap(environ)
+	var b strings.Builder
+	for _, k := range ownedEnvKeys(environ) {
+		if !isValidEnvName(k) {
+			continue
+		}
+		export := shenv.ShellExport{}
+		if initPath, ok := env[envpath.InitPathEnv]; k == "PATH" && ok {
+			export.Add(k, initPath)
+		} else {
+			export.Remove(k)
+		}
+		b.WriteString(strings.TrimSuffix(sh.Export(export), "\n") + "\n")
+
+		marker := shenv.ShellExport{}
+		marker.Remove(devboxSetPrefix + k)
+		b.WriteString(strings.TrimSuffix(sh.Export(marker), "\n") + "\n")
+	}
+	return b.String()
+}
+
 // IsEnvEnabled checks if the devbox environment is enabled.
 // This allows us to differentiate between global and
 // individual project shells.
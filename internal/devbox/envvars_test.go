@@ -0,0 +1,311 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.jetpack.io/devbox/internal/devbox/envpath"
+	"go.jetpack.io/devbox/internal/envir"
+	"go.jetpack.io/devbox/internal/shenv"
+)
+
+func TestComputeEnvPluginThenConfigPath(t *testing.T) {
+	base := map[string]string{"PATH": "/usr/bin"}
+	plugin := expandedEnv{vars: map[string]string{"PATH": "/plugin/bin:$PATH"}}
+	config := expandedEnv{vars: map[string]string{"PATH": "/config/bin:$PATH"}}
+
+	got, err := ComputeEnv(base, []EnvSource{plugin, config})
+	require.NoError(t, err)
+	assert.Equal(t, "/config/bin:/plugin/bin:/usr/bin", got["PATH"])
+	assert.Equal(t, "/usr/bin", base["PATH"], "ComputeEnv modified base")
+}
+
+func TestComputeEnvPrecedence(t *testing.T) {
+	base := map[string]string{
+		"INHERITED":               "base",
+		"OWNED":                   "base",
+		devboxSetPrefix + "OWNED": "1",
+	}
+	got, err := ComputeEnv(base, []EnvSource{
+		EnvMap{"INHERITED": "plugin", "OWNED": "plugin", "PLUGIN": "plugin"},
+		EnvMap{"INHERITED": "config", "CONFIG": "$PLUGIN"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		// Later sources win over earlier sources and base.
+		"INHERITED": "config",
+		// Variables previously set by devbox keep their base value.
+		"OWNED":                   "base",
+		devboxSetPrefix + "OWNED": "1",
+		"PLUGIN":                  "plugin",
+		// EnvMap doesn't expand references.
+		"CONFIG": "$PLUGIN",
+	}, got)
+}
+
+func TestComputeEnvError(t *testing.T) {
+	cycle := expandedEnv{
+		vars:      map[string]string{"A": "$B", "B": "$A"},
+		recursive: true,
+	}
+	_, err := ComputeEnv(nil, []EnvSource{cycle})
+	assert.Error(t, err)
+}
+
+func TestComposeGlobalAndProjectEnvPath(t *testing.T) {
+	base := map[string]string{"PATH": "/usr/bin"}
+	global := []EnvSource{
+		expandedEnv{vars: map[string]string{"PATH": "/global/plugin/bin:$PATH"}},
+		expandedEnv{vars: map[string]string{"PATH": "/global/bin:$PATH", "EDITOR": "vim"}},
+	}
+	project := []EnvSource{
+		expandedEnv{vars: map[string]string{"PATH": "/project/bin:$PATH", "EDITOR": "nano"}},
+	}
+
+	got, err := ComposeGlobalAndProjectEnv(base, global, project)
+	require.NoError(t, err)
+	assert.Equal(t, "/project/bin:/global/bin:/global/plugin/bin:/usr/bin", got["PATH"])
+	assert.Equal(t, "nano", got["EDITOR"], "project didn't override global")
+	assert.Equal(t, "1", got[devboxSetPrefix+"PATH"])
+	assert.Equal(t, "1", got[devboxSetPrefix+"EDITOR"])
+	assert.Equal(t, "/usr/bin", base["PATH"], "ComposeGlobalAndProjectEnv modified base")
+
+	// Without any project sources, the result is the global env.
+	got, err = ComposeGlobalAndProjectEnv(base, global, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/global/bin:/global/plugin/bin:/usr/bin", got["PATH"])
+}
+
+func TestComposeGlobalAndProjectEnvPrecedence(t *testing.T) {
+	base := map[string]string{
+		"OWNED":                   "base",
+		devboxSetPrefix + "OWNED": "1",
+	}
+	got, err := ComposeGlobalAndProjectEnv(base,
+		[]EnvSource{EnvMap{"OWNED": "global", "GLOBAL": "global"}},
+		[]EnvSource{EnvMap{"OWNED": "project", "PROJECT": "project"}},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		// Variables previously set by devbox keep their base value in
+		// both layers.
+		"OWNED":                    "base",
+		devboxSetPrefix + "OWNED":  "1",
+		"GLOBAL":                   "global",
+		devboxSetPrefix + "GLOBAL": "1",
+		"PROJECT":                  "project",
+	}, got)
+}
+
+func TestExportifyPathLast(t *testing.T) {
+	vars := map[string]string{
+		"PATH":            "/bin",
+		"ZED":             "z",
+		"LD_LIBRARY_PATH": "/lib",
+		"GOPATH":          "/go",
+		"XDG_DATA_DIRS":   "/share",
+		"ALPHA":           "a",
+	}
+	want := `export ALPHA="a";
+export ZED="z";
+export GOPATH="/go";
+export LD_LIBRARY_PATH="/lib";
+export XDG_DATA_DIRS="/share";
+export PATH="/bin";`
+	assert.Equal(t, want, exportifyPathLast(vars))
+}
+
+func TestExportifyInvalidNames(t *testing.T) {
+	vars := map[string]string{
+		"FOO":            "foo",
+		"_BAR2":          "bar",
+		"FOO BAR":        "space",
+		"1FOO":           "digit",
+		"FOO=BAR":        "equals",
+		"BASH_FUNC_fn%%": "() { :; }",
+		"":               "empty",
+		"café":           "unicode",
+	}
+	assert.Equal(t, "export FOO=\"foo\";\nexport _BAR2=\"bar\";", exportify(vars))
+	assert.Equal(t, "export FOO=\"foo\";\nexport _BAR2=\"bar\";", exportifyPathLast(vars))
+	assert.Equal(t,
+		[]string{"", "1FOO", "BASH_FUNC_fn%%", "FOO BAR", "FOO=BAR", "café"},
+		invalidEnvNames(vars),
+	)
+}
+
+func TestWriteExports(t *testing.T) {
+	vars := map[string]string{
+		"PATH":  "/bin:$HOME/bin",
+		"QUOTE": `say "hi"`,
+		"1FOO":  "invalid",
+		"A":     "",
+	}
+	var b strings.Builder
+	require.NoError(t, writeExports(&b, vars, exportKeys(vars), nil))
+	assert.Equal(t, exportify(vars)+"\n", b.String())
+
+	err := writeExports(errWriter{}, vars, exportKeys(vars), nil)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestMergeCaseCollisions(t *testing.T) {
+	vars := map[string]string{
+		"PATH":       "/nix/store/bin:/bin",
+		"Path":       "/custom/bin",
+		"http_proxy": "http://a",
+		"HTTP_PROXY": "http://b",
+		"Foo":        "1",
+		"FOO":        "2",
+		"foo":        "3",
+	}
+	override := map[string]string{"Path": "/custom/bin", "FOO": "2", "foo": "3"}
+	collisions := mergeCaseCollisions(vars, override)
+
+	assert.Equal(t, []envCaseCollision{
+		{Kept: "Path", Dropped: []string{"PATH"}},
+		{Kept: "foo", Dropped: []string{"FOO", "Foo"}},
+	}, collisions)
+	assert.Equal(t, map[string]string{
+		"Path":       "/custom/bin",
+		"http_proxy": "http://a",
+		"HTTP_PROXY": "http://b",
+		"foo":        "3",
+	}, vars)
+	assert.Equal(t, "export HTTP_PROXY=\"http://b\";\nexport Path=\"/custom/bin\";\n"+
+		"export foo=\"3\";\nexport http_proxy=\"http://a\";", exportify(vars))
+}
+
+func TestOwnedEnvKeys(t *testing.T) {
+	environ := []string{
+		"HOME=/home/user",
+		devboxSetPrefix + "PATH=1",
+		"PATH=/bin",
+		devboxSetPrefix + "GOPATH=1",
+		devboxSetPrefix + "=1",
+		devboxSetPrefix + "PATH=1",
+		"NOT" + devboxSetPrefix + "X=1",
+	}
+	assert.Equal(t, []string{"GOPATH", "PATH"}, ownedEnvKeys(environ))
+	assert.Empty(t, ownedEnvKeys([]string{"HOME=/home/user"}))
+}
+
+func TestOwnedEnvKeysComputed(t *testing.T) {
+	base := map[string]string{
+		"HOME":   "/home/user",
+		"EDITOR": "vim",
+		"LANG":   "C",
+	}
+	env, err := ComputeEnv(base, []EnvSource{
+		EnvMap{"GOPATH": "/plugin/go"},
+		EnvMap{"EDITOR": "nano", "LANG": "C"},
+	})
+	require.NoError(t, err)
+	markSetByDevbox(env, base)
+
+	// LANG is set to the value it already had, so it's still inherited.
+	assert.Equal(t, []string{"EDITOR", "GOPATH"}, ownedEnvKeys(envir.MapToPairs(env)))
+}
+
+func TestExitEnvExports(t *testing.T) {
+	environ := []string{
+		"HOME=/home/user",
+		"PATH=/nix/store/abc-hello/bin:/bin",
+		"DEVBOX_INIT_PATH=/bin",
+		"GOPATH=/home/user/go",
+		devboxSetPrefix + "PATH=1",
+		devboxSetPrefix + "GOPATH=1",
+	}
+	want := "unset GOPATH;\nunset " + devboxSetPrefix + "GOPATH;\n" +
+		"export PATH='/bin';\nunset " + devboxSetPrefix + "PATH;\n"
+	assert.Equal(t, want, exitEnvExports(shenv.Posix, environ))
+
+	// Without the initial PATH, there's nothing to restore it to.
+	got := exitEnvExports(shenv.Posix, []string{"PATH=/bin", devboxSetPrefix + "PATH=1"})
+	assert.Equal(t, "unset PATH;\nunset "+devboxSetPrefix+"PATH;\n", got)
+	assert.Empty(t, exitEnvExports(shenv.Posix, []string{"HOME=/home/user"}))
+}
+
+func TestExitEnvExportsComputed(t *testing.T) {
+	base := map[string]string{
+		"HOME":   "/home/user",
+		"PATH":   "/bin",
+		"EDITOR": "vim",
+	}
+	env, err := ComputeEnv(base, []EnvSource{
+		expandedEnv{vars: map[string]string{"PATH": "/plugin/bin:$PATH", "GOPATH": "/plugin/go"}},
+		EnvMap{"EDITOR": "nano"},
+	})
+	require.NoError(t, err)
+	markSetByDevbox(env, base)
+
+	// Build PATH the way Devbox.computeEnv does.
+	pathStack := envpath.Stack(env, base)
+	pathStack.Push(env, "abc123", env["PATH"], false)
+	env["PATH"] = pathStack.Path(env)
+	markPathSetByDevbox(env, "abc123")
+
+	unset := func(k string) string {
+		return "unset " + k + ";\nunset " + devboxSetPrefix + k + ";\n"
+	}
+	want := unset("DEVBOX_INIT_PATH") + unset("DEVBOX_NIX_ENV_PATH_abc123") +
+		unset(envpath.PathStackEnv) + unset("EDITOR") + unset("GOPATH") +
+		"export PATH='/bin';\nunset " + devboxSetPrefix + "PATH;\n"
+	assert.Equal(t, want, exitEnvExports(shenv.Posix, envir.MapToPairs(env)))
+}
+
+func TestExportsHeader(t *testing.T) {
+	now := func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	d := &Devbox{projectDir: "/home/user/project", now: now}
+	assert.Equal(t,
+		"# generated by devbox 0.0.0-dev for /home/user/project at 2024-05-01T12:00:00Z\n",
+		d.exportsHeader(),
+	)
+
+	// A newline in the project path must not end the comment.
+	d = &Devbox{projectDir: "/tmp/a\nexport X=1", now: now}
+	for _, line := range strings.Split(strings.TrimSuffix(d.exportsHeader(), "\n"), "\n") {
+		assert.True(t, strings.HasPrefix(line, "# "), "line %q isn't a comment", line)
+	}
+}
+
+// FuzzExportify checks that a POSIX shell that evaluates the exports from
+// exportify gets back the original value.
+func FuzzExportify(f *testing.F) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		f.Skip("sh not found")
+	}
+	for _, value := range []string{
+		"", "plain", "it's", `"double"`, "$HOME `id`", `back\slash`,
+		"trailing\\", "multi\nline", "\\\n", "\xff\xfe", "  spaces  ",
+	} {
+		f.Add(value)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		if strings.IndexByte(value, 0) != -1 {
+			t.Skip("environment values can't contain NUL")
+		}
+		script := exportify(map[string]string{"DEVBOX_FUZZ": value}) + "\nprintf %s \"$DEVBOX_FUZZ\""
+		out, err := exec.Command(sh, "-c", script).Output()
+		if err != nil {
+			t.Fatalf("sh -c %q: %v", script, err)
+		}
+		if string(out) != value {
+			t.Fatalf("got value %q after evaluating %q, want %q", out, script, value)
+		}
+	})
+}
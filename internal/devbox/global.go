@@ -4,26 +4,87 @@
 package devbox
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+	"go.jetpack.io/devbox/internal/cachehash"
+	"go.jetpack.io/devbox/internal/devbox/devopt"
+	"go.jetpack.io/devbox/internal/devbox/envpath"
+	"go.jetpack.io/devbox/internal/devconfig"
+	"go.jetpack.io/devbox/internal/devconfig/configfile"
+	"go.jetpack.io/devbox/internal/devpkg"
+	"go.jetpack.io/devbox/internal/envir"
+	"go.jetpack.io/devbox/internal/lock"
+	"go.jetpack.io/devbox/internal/nix"
+	"go.jetpack.io/devbox/internal/nix/nixprofile"
+	"go.jetpack.io/devbox/internal/ux"
 	"go.jetpack.io/devbox/internal/xdg"
+	"go.jetpack.io/devbox/nix/flake"
 )
 
 // In the future we will support multiple global profiles
 const currentGlobalProfile = "default"
 
+// defaultGlobalCurrentLink is the name of the symlink in the global data
+// directory that points to the active profile.
+const defaultGlobalCurrentLink = "current"
+
+// globalCurrentLinkPath returns the path of the symlink in dataDir that points
+// to the active profile. Set DEVBOX_GLOBAL_CURRENT_LINK to give it a
+// different name.
+func globalCurrentLinkPath(dataDir string) (string, error) {
+	name := os.Getenv(envir.DevboxGlobalCurrentLink)
+	if name == "" {
+		name = defaultGlobalCurrentLink
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." || name == currentGlobalProfile {
+		return "", usererr.New(
+			"Invalid %s %q: it must be a file name other than %q.",
+			envir.DevboxGlobalCurrentLink, name, currentGlobalProfile,
+		)
+	}
+	return filepath.Join(dataDir, name), nil
+}
+
+// globalDataDir returns the directory that contains the global profiles. Set
+// DEVBOX_GLOBAL_DATA_DIR to use a different directory, such as a temporary
+// directory in tests.
+func globalDataDir() (string, error) {
+	if dir := os.Getenv(envir.DevboxGlobalDataDir); dir != "" {
+		return dir, nil
+	}
+	return xdg.DataSubpath("devbox/global")
+}
+
 func GlobalDataPath() (string, error) {
-	path := xdg.DataSubpath(filepath.Join("devbox/global", currentGlobalProfile))
+	dataDir, err := globalDataDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dataDir, currentGlobalProfile)
 	if err := os.MkdirAll(path, 0o755); err != nil {
 		return "", errors.WithStack(err)
 	}
 
 	nixProfilePath := filepath.Join(path)
-	currentPath := xdg.DataSubpath("devbox/global/current")
+	currentPath, err := globalCurrentLinkPath(dataDir)
+	if err != nil {
+		return "", err
+	}
 
 	// For now default is always current. In the future we will support multiple
 	// and allow user to switch. Remove any existing symlink and create a new one
@@ -34,10 +95,779 @@ func GlobalDataPath() (string, error) {
 		_ = os.Remove(currentPath)
 	}
 
-	err := os.Symlink(nixProfilePath, currentPath)
+	err = os.Symlink(nixProfilePath, currentPath)
 	if err != nil && !errors.Is(err, fs.ErrExist) {
 		return "", errors.WithStack(err)
 	}
+	removeStaleGlobalCurrentLinks(dataDir, currentPath, nixProfilePath)
 
 	return path, nil
 }
+
+// removeStaleGlobalCurrentLinks removes the symlinks in dataDir, other than
+// keep, that point to profilePath. They're left behind when
+// DEVBOX_GLOBAL_CURRENT_LINK changes. Pass an empty keep to remove them all.
+func removeStaleGlobalCurrentLinks(dataDir, keep, profilePath string) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dataDir, entry.Name())
+		if entry.Type()&fs.ModeSymlink == 0 || path == keep {
+			continue
+		}
+		if target, err := os.Readlink(path); err == nil && target == profilePath {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+// GlobalDestroy deletes the global profile, including its devbox.json,
+// devbox.lock and nix profile, and the "current" symlink that points to it
+// (see [envir.DevboxGlobalCurrentLink]) along with any link to it left under
+// a previous name.
+// Deleting the nix profile's generation links lets nix garbage collect the
+// packages. It's safe to call when some or all of it is already gone.
+//
+// GlobalDestroy doesn't edit shell rcfiles, so any line that evaluates
+// `devbox global shellenv` must be removed by hand.
+func GlobalDestroy(w io.Writer) error {
+	dataDir, err := globalDataDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dataDir, currentGlobalProfile)
+	currentPath, err := globalCurrentLinkPath(dataDir)
+	if err != nil {
+		return err
+	}
+
+	_, statErr := os.Stat(path)
+	_, linkErr := os.Lstat(currentPath)
+	if errors.Is(statErr, fs.ErrNotExist) && errors.Is(linkErr, fs.ErrNotExist) {
+		ux.Finfof(w, "There is no global profile to remove.\n")
+		return nil
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Remove(currentPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.WithStack(err)
+	}
+	removeStaleGlobalCurrentLinks(dataDir, "", path)
+	ux.Fsuccessf(w, "Removed the global profile at %s.\n", path)
+	return nil
+}
+
+// ErrGlobalProfileNotInPath means that the global profile isn't in the PATH of
+// the current shell, usually because the shell's rcfile doesn't evaluate
+// `devbox global shellenv`. It's a warning that tells the user how to fix it.
+var ErrGlobalProfileNotInPath = usererr.NewWarningWithID(
+	"global-profile-not-in-path",
+	`devbox global is not activated.
+
+Add the following line to your shell's rcfile (e.g., ~/.bashrc or ~/.zshrc)
+and restart your shell to fix this:
+
+	eval "$(devbox global shellenv)"
+`,
+)
+
+// EnsureGlobalProfileInPath returns an error that wraps
+// [ErrGlobalProfileNotInPath] if the environment of d, the global profile,
+// isn't loaded in the current shell, or if its bin directory isn't in PATH.
+func (d *Devbox) EnsureGlobalProfileInPath() error {
+	if !d.IsEnvEnabled() {
+		return ErrGlobalProfileNotInPath
+	}
+	if !slices.Contains(envpath.SplitList(os.Getenv("PATH")), d.ProfileBinPath()) {
+		return ErrGlobalProfileNotInPath
+	}
+	return nil
+}
+
+// GlobalPackageNames returns the versioned names of the packages in the global
+// devbox.json. It only reads the config file, which makes it fast enough for
+// shell completion, but it may disagree with the global nix profile if the
+// profile is out of date (for example, after editing devbox.json by hand). Use
+// [GlobalProfileEntries] when accuracy matters more than speed.
+func GlobalPackageNames() ([]string, error) {
+	path, err := GlobalDataPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := devconfig.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := cfg.Root.TopLevelPackages()
+	names := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		names = append(names, pkg.VersionedName())
+	}
+	return names, nil
+}
+
+// GlobalProfileEntries returns the packages that are actually installed in the
+// global nix profile. Unlike [GlobalPackageNames], it runs nix to list the
+// profile, so it's too slow to call from shell completion.
+func GlobalProfileEntries(w io.Writer) ([]*nixprofile.NixProfileListItem, error) {
+	path, err := GlobalDataPath()
+	if err != nil {
+		return nil, err
+	}
+	return nixprofile.ProfileListItems(w, filepath.Join(path, nix.ProfilePath))
+}
+
+// InstalledPackage is a package that [Devbox.AddGlobal] installed in the
+// global nix profile.
+type InstalledPackage struct {
+	// Name is the package name as written to devbox.json.
+	Name string
+
+	// Commit is the nixpkgs commit that the package resolved to in
+	// devbox.lock. It's empty for packages that devbox doesn't lock, such
+	// as flake references.
+	Commit string
+
+	// StorePaths are the package's store paths as read from the profile's
+	// manifest after the install.
+	StorePaths []string
+}
+
+// AddGlobal is like [Devbox.Add] for the global devbox, but it also returns
+// the packages that ended up in the global nix profile. It's meant for
+// callers that need to act on the installed files, such as setting up
+// wrappers or shell completions.
+func (d *Devbox) AddGlobal(ctx context.Context, pkgsNames []string, opts devopt.AddOpts) ([]InstalledPackage, error) {
+	if !d.isGlobal() {
+		return nil, errors.Errorf("AddGlobal called on non-global devbox project %s", d.projectDir)
+	}
+	pkgs, err := d.add(ctx, pkgsNames, opts)
+	if err != nil {
+		return nil, err
+	}
+	profilePath, err := d.profilePath()
+	if err != nil {
+		return nil, err
+	}
+	return installedPackages(profilePath, d.lockfile, pkgs)
+}
+
+// PlannedPackage is one package in a plan for changing the global devbox,
+// which describes what the change would do without making it. It's the
+// schema of `devbox global add --dry-run --json`.
+type PlannedPackage struct {
+	// Package is the package as the user requested it.
+	Package string `json:"package"`
+
+	// Name is the name that the package would have in devbox.json.
+	Name string `json:"name,omitempty"`
+
+	// Installed is true if the package is already in the global
+	// devbox.json, so adding it wouldn't change anything.
+	Installed bool `json:"installed"`
+
+	// Commit is the nixpkgs commit that the package resolves to. It's
+	// empty for packages that devbox doesn't lock, such as flake
+	// references.
+	Commit string `json:"commit,omitempty"`
+
+	// Valid is true if the package passed the checks that
+	// [Devbox.AddGlobal] does before installing it. Error explains why it
+	// didn't.
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// PlanAddGlobal returns what [Devbox.AddGlobal] would do with each of
+// pkgsNames without installing anything or changing the global devbox.json
+// and devbox.lock. A package that fails validation is reported in the plan
+// instead of failing the whole plan.
+func (d *Devbox) PlanAddGlobal(ctx context.Context, pkgsNames []string, opts devopt.AddOpts) ([]PlannedPackage, error) {
+	if !d.isGlobal() {
+		return nil, errors.Errorf("PlanAddGlobal called on non-global devbox project %s", d.projectDir)
+	}
+	pkgsNames, err := d.cfg.Root.ExpandPackageGroups(pkgsNames)
+	if err != nil {
+		return nil, err
+	}
+	existing := lo.Map(d.cfg.Root.TopLevelPackages(), func(p configfile.Package, _ int) string {
+		return p.VersionedName()
+	})
+
+	plan := make([]PlannedPackage, 0, len(pkgsNames))
+	for _, raw := range lo.UniqBy(pkgsNames, devpkg.NormalizeRaw) {
+		pkg, outputs := d.newAddPackage(raw, opts)
+		_, installed := equivalentPackageName(existing, pkg.Versioned())
+		planned := PlannedPackage{
+			Package:   raw,
+			Installed: installed,
+		}
+		name, err := d.packageNameForConfig(ctx, pkg, opts)
+		if err == nil {
+			err = d.validateOutputs(name, opts, outputs)
+		}
+		if err != nil {
+			planned.Error = err.Error()
+			plan = append(plan, planned)
+			continue
+		}
+		planned.Name = name
+		planned.Valid = true
+		// The lockfile keys packages by their name in devbox.json, which
+		// can differ from raw, e.g. hello@latest for hello.
+		if locked := d.lockfile.Get(name); locked != nil {
+			if parsed, err := flake.ParseInstallable(locked.Resolved); err == nil {
+				planned.Commit = parsed.Ref.Rev
+			}
+		}
+		plan = append(plan, planned)
+	}
+	return plan, nil
+}
+
+// RemoveGlobal is like [Devbox.Remove] for the global devbox, but it also
+// checks for package groups that no longer have any of their packages in
+// devbox.json after the removal. With opts.PruneGroups it deletes those
+// groups from the config. Otherwise it leaves them and prints a warning.
+//
+// Besides package names, pkgs can have store paths of global profile
+// elements, and opts.Indexes can have their indexes. These are mapped back to
+// the packages in devbox.json that installed them. Elements that weren't
+// installed from devbox.json are removed from the profile directly.
+func (d *Devbox) RemoveGlobal(ctx context.Context, pkgs []string, opts devopt.RemoveOpts) error {
+	if !d.isGlobal() {
+		return errors.Errorf("RemoveGlobal called on non-global devbox project %s", d.projectDir)
+	}
+	pkgs, orphans, err := d.resolveProfileTargets(pkgs, opts.Indexes)
+	if err != nil {
+		return err
+	}
+	if err := d.removeProfileOrphans(orphans); err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	before := d.packageGroupsInUse()
+	if err := d.Remove(ctx, pkgs...); err != nil {
+		return err
+	}
+	after := d.packageGroupsInUse()
+	emptied := lo.Filter(before, func(group string, _ int) bool {
+		return !slices.Contains(after, group)
+	})
+	if len(emptied) == 0 {
+		return nil
+	}
+
+	if !opts.PruneGroups {
+		ux.Fwarningf(
+			d.stderr,
+			"These package groups no longer have any packages in devbox.json: %s. "+
+				"Remove them with `devbox global rm --prune-groups` or edit the config.\n",
+			strings.Join(emptied, ", "),
+		)
+		return nil
+	}
+	kept := d.cfg.Root.PrunePackageGroups(emptied)
+	if len(kept) > 0 {
+		ux.Fwarningf(
+			d.stderr,
+			"Kept these empty package groups because other groups include them: %s\n",
+			strings.Join(kept, ", "),
+		)
+	}
+	if len(kept) == len(emptied) {
+		return nil
+	}
+	defer d.timings.start("save config")()
+	return d.saveCfg()
+}
+
+// packageGroupsInUse returns the sorted names of the package groups that have
+// at least one of their packages in devbox.json.
+func (d *Devbox) packageGroupsInUse() []string {
+	var groups []string
+	for _, pkg := range d.cfg.Root.TopLevelPackages() {
+		groups = append(groups, d.cfg.Root.PackageGroupsContaining(pkg.VersionedName())...)
+	}
+	slices.Sort(groups)
+	return slices.Compact(groups)
+}
+
+// installedPackages looks up pkgs in the manifest of the profile at
+// profilePath. A package's store paths are those of the profile elements
+// that contain one of the package's resolved store paths.
+func installedPackages(profilePath string, lockfile *lock.File, pkgs []*devpkg.Package) ([]InstalledPackage, error) {
+	elements, err := nix.ProfileElements(profilePath)
+	if err != nil {
+		return nil, err
+	}
+	profileStorePaths := map[string][]string{}
+	for elem := range elements {
+		if !elem.Active {
+			continue
+		}
+		for _, storePath := range elem.StorePaths {
+			profileStorePaths[storePath] = elem.StorePaths
+		}
+	}
+
+	installed := make([]InstalledPackage, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		result := InstalledPackage{Name: pkg.Versioned()}
+		if locked := lockfile.Get(pkg.LockfileKey()); locked != nil {
+			if parsed, err := flake.ParseInstallable(locked.Resolved); err == nil {
+				result.Commit = parsed.Ref.Rev
+			}
+		}
+		resolved, err := pkg.GetResolvedStorePaths()
+		if err != nil {
+			return nil, err
+		}
+		for _, storePath := range resolved {
+			for _, p := range profileStorePaths[storePath] {
+				if !slices.Contains(result.StorePaths, p) {
+					result.StorePaths = append(result.StorePaths, p)
+				}
+			}
+		}
+		installed = append(installed, result)
+	}
+	return installed, nil
+}
+
+// GlobalDoctor checks the global nix profile for problems and writes a
+// description of each one, along with a suggested fix, to w. It currently
+// looks for packages whose store paths no longer exist, which happens when
+// nix-collect-garbage deletes a path that the profile still references, and for
+// changes made to the profile outside of devbox (such as running
+// `nix profile install` by hand).
+//
+// The check only reads the profile's manifest and stats store paths, so it
+// doesn't invoke nix.
+func GlobalDoctor(w io.Writer) error {
+	path, err := GlobalDataPath()
+	if err != nil {
+		return err
+	}
+	profilePath := filepath.Join(path, nix.ProfilePath)
+	broken, err := missingStorePaths(profilePath)
+	if err != nil {
+		return err
+	}
+	drifted, err := globalProfileDrifted(path, profilePath)
+	if err != nil {
+		return err
+	}
+	if len(broken) == 0 && !drifted {
+		ux.Fsuccessf(w, "No problems found in the global profile.\n")
+		return nil
+	}
+
+	if len(broken) > 0 {
+		ux.Fwarningf(w, "The following global packages reference store paths that no longer exist:\n\n")
+		for _, b := range broken {
+			fmt.Fprintf(w, "\t%s (%s)\n", b.pkg, b.storePath)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "This usually means they were removed by nix-collect-garbage. Reinstall them with:")
+		fmt.Fprintln(w)
+		for _, b := range broken {
+			fmt.Fprintf(w, "\tdevbox global rm %[1]s && devbox global add %[1]s\n", b.pkg)
+		}
+	}
+	if drifted {
+		if len(broken) > 0 {
+			fmt.Fprintln(w)
+		}
+		ux.Fwarningf(w, "The global profile was changed outside of devbox, so it may not match the global devbox.json.\n\n")
+		fmt.Fprintln(w, "Run `devbox global install` to sync the profile with devbox.json.")
+	}
+	return nil
+}
+
+// globalProfileStatePath is where devbox records the state of the global
+// profile, relative to GlobalDataPath.
+const globalProfileStatePath = ".devbox/global-profile-state.json"
+
+// profileState identifies a version of a nix profile so that devbox can tell
+// when the profile changes without it.
+type profileState struct {
+	// Generation is the number of the profile generation that the profile
+	// symlink points to.
+	Generation int `json:"generation"`
+
+	// ManifestHash is the hash of the generation's manifest.json.
+	ManifestHash string `json:"manifest_hash"`
+}
+
+// readProfileState returns the current state of the profile at profilePath.
+// It returns a zero profileState if the profile doesn't exist yet.
+func readProfileState(profilePath string) (profileState, error) {
+	// A profile is a symlink, such as "default", to its current generation,
+	// such as "default-3-link".
+	link, err := os.Readlink(profilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return profileState{}, nil
+	}
+	if err != nil {
+		return profileState{}, errors.WithStack(err)
+	}
+	state := profileState{}
+	var ok bool
+	if state.Generation, ok = parseGenerationLink(profilePath, filepath.Base(link)); !ok {
+		return profileState{}, errors.Errorf("unexpected nix profile generation link %q", link)
+	}
+	state.ManifestHash, err = cachehash.File(filepath.Join(profilePath, "manifest.json"))
+	if err != nil {
+		return profileState{}, err
+	}
+	return state, nil
+}
+
+// parseGenerationLink returns the generation number of a generation link for
+// the profile at profilePath, such as "default-3-link" for profile "default".
+func parseGenerationLink(profilePath, linkName string) (int, bool) {
+	gen, ok := strings.CutPrefix(linkName, filepath.Base(profilePath)+"-")
+	if !ok {
+		return 0, false
+	}
+	gen, ok = strings.CutSuffix(gen, "-link")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(gen)
+	return n, err == nil
+}
+
+// recordGlobalProfileState saves the current state of the global profile so
+// that globalProfileDrifted can later detect changes made outside of devbox.
+func recordGlobalProfileState(globalPath, profilePath string) error {
+	state, err := readProfileState(profilePath)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	statePath := filepath.Join(globalPath, globalProfileStatePath)
+	if err := os.MkdirAll(filepath.Dir(statePath), 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(statePath, append(data, '\n'), 0o644))
+}
+
+// globalProfileDrifted reports whether the global profile changed since
+// devbox last recorded its state. It returns false if devbox never recorded
+// the state, since there's nothing to compare against.
+func globalProfileDrifted(globalPath, profilePath string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(globalPath, globalProfileStatePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	var recorded profileState
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return false, errors.WithStack(err)
+	}
+	current, err := readProfileState(profilePath)
+	if err != nil {
+		return false, err
+	}
+	return current != recorded, nil
+}
+
+type missingStorePath struct {
+	pkg       string
+	storePath string
+}
+
+// missingStorePaths returns the active elements of the profile at profilePath
+// that have at least one store path that doesn't exist on disk.
+func missingStorePaths(profilePath string) ([]missingStorePath, error) {
+	elements, err := nix.ProfileElements(profilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []missingStorePath
+	for elem := range elements {
+		if !elem.Active {
+			continue
+		}
+		for _, storePath := range elem.StorePaths {
+			_, err := os.Stat(storePath)
+			if errors.Is(err, fs.ErrNotExist) {
+				missing = append(missing, missingStorePath{
+					pkg:       profileElementName(elem),
+					storePath: storePath,
+				})
+				break
+			}
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// profileElementName returns a name for elem that the user would recognize
+// from their devbox.json. Older manifests don't name their elements, so we
+// fall back to the last component of the attribute path or, for packages
+// installed by store path, the name part of the first store path.
+func profileElementName(elem nix.ProfileElement) string {
+	if elem.Name != "" {
+		return elem.Name
+	}
+	if elem.AttrPath != "" {
+		return elem.AttrPath[strings.LastIndexByte(elem.AttrPath, '.')+1:]
+	}
+	if len(elem.StorePaths) > 0 {
+		// /nix/store/<hash>-<name>
+		base := filepath.Base(elem.StorePaths[0])
+		if _, name, ok := strings.Cut(base, "-"); ok {
+			return name
+		}
+		return base
+	}
+	return "<unknown>"
+}
+
+// globalGenerationsPath is where devbox keeps a copy of the global devbox.json
+// and devbox.lock for each profile generation it creates, relative to
+// GlobalDataPath. Rolling back the profile restores these files so that the
+// config matches the profile again.
+const globalGenerationsPath = ".devbox/generations"
+
+// snapshotGlobalGeneration saves config and the global devbox.lock as the
+// files that produced the current generation of the global profile.
+func snapshotGlobalGeneration(globalPath, profilePath string, config []byte) error {
+	state, err := readProfileState(profilePath)
+	if err != nil || state.Generation == 0 {
+		return err
+	}
+	dir := filepath.Join(globalPath, globalGenerationsPath, strconv.Itoa(state.Generation))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, configfile.DefaultName), config, 0o644); err != nil {
+		return errors.WithStack(err)
+	}
+	return copyFileIfExists(filepath.Join(globalPath, "devbox.lock"), filepath.Join(dir, "devbox.lock"))
+}
+
+// snapshotNewGlobalGeneration snapshots config like snapshotGlobalGeneration,
+// but only if syncing the profile with config produced a generation other
+// than before, the generation that the profile had before the sync. If the
+// generation didn't change, config didn't produce it, so its existing
+// snapshot is kept.
+func snapshotNewGlobalGeneration(globalPath, profilePath string, before int, config []byte) error {
+	if profileGeneration(profilePath) == before {
+		return nil
+	}
+	return snapshotGlobalGeneration(globalPath, profilePath, config)
+}
+
+// profileGeneration returns the current generation of the nix profile at
+// profilePath, or 0 if it doesn't have one.
+func profileGeneration(profilePath string) int {
+	state, err := readProfileState(profilePath)
+	if err != nil {
+		slog.Debug("failed to read nix profile generation", "path", profilePath, "err", err)
+		return 0
+	}
+	return state.Generation
+}
+
+// globalGenerations returns the global profile generations that have a
+// snapshot, in ascending order.
+func globalGenerations(globalPath string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(globalPath, globalGenerationsPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var gens []int
+	for _, entry := range entries {
+		if gen, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			gens = append(gens, gen)
+		}
+	}
+	slices.Sort(gens)
+	return gens, nil
+}
+
+// GlobalRollback switches the global profile to generation and restores the
+// devbox.json and devbox.lock that produced it. If generation is 0, it rolls
+// back to the most recent generation before the current one. Only generations
+// that devbox created can be rolled back to, since devbox can't reconstruct the
+// config for changes made outside of it.
+func GlobalRollback(ctx context.Context, w io.Writer, generation int) error {
+	path, err := GlobalDataPath()
+	if err != nil {
+		return err
+	}
+	profilePath := filepath.Join(path, nix.ProfilePath)
+	current, err := readProfileState(profilePath)
+	if err != nil {
+		return err
+	}
+	gens, err := globalGenerations(path)
+	if err != nil {
+		return err
+	}
+
+	if generation == 0 {
+		for _, gen := range gens {
+			if gen < current.Generation {
+				generation = gen
+			}
+		}
+		if generation == 0 {
+			return usererr.New("There is no earlier global profile generation to roll back to.")
+		}
+	} else if !slices.Contains(gens, generation) {
+		return usererr.New(
+			"Devbox has no record of global profile generation %d. Generations that "+
+				"can be rolled back to are: %s",
+			generation, strings.Join(lo.Map(gens, func(gen, _ int) string { return strconv.Itoa(gen) }), ", "),
+		)
+	}
+	if generation == current.Generation {
+		ux.Finfof(w, "The global profile is already at generation %d.\n", generation)
+		return nil
+	}
+
+	if err := nix.ProfileRollback(ctx, profilePath, generation); err != nil {
+		return err
+	}
+	snapshot := filepath.Join(path, globalGenerationsPath, strconv.Itoa(generation))
+	for _, name := range []string{configfile.DefaultName, "devbox.lock"} {
+		if err := copyFileIfExists(filepath.Join(snapshot, name), filepath.Join(path, name)); err != nil {
+			return err
+		}
+	}
+	if err := recordGlobalProfileState(path, profilePath); err != nil {
+		return err
+	}
+	ux.Fsuccessf(w, "Rolled back the global profile to generation %d.\n", generation)
+	return nil
+}
+
+func copyFileIfExists(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(dst, data, 0o644))
+}
+
+// Generation is a generation of the global nix profile.
+type Generation struct {
+	Number int
+	Time   time.Time
+
+	// Current is true for the generation that the profile points to.
+	Current bool
+
+	// Added and Removed list the packages that were added or removed since
+	// the previous generation, as <name>-<version>.
+	Added   []string
+	Removed []string
+}
+
+// GlobalHistory returns the generations of the global nix profile, oldest
+// first. It reads the generations' manifests directly, so it doesn't invoke
+// nix.
+func GlobalHistory() ([]Generation, error) {
+	path, err := GlobalDataPath()
+	if err != nil {
+		return nil, err
+	}
+	return profileHistory(filepath.Join(path, nix.ProfilePath))
+}
+
+func profileHistory(profilePath string) ([]Generation, error) {
+	current, err := readProfileState(profilePath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Dir(profilePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var history []Generation
+	for _, entry := range entries {
+		num, ok := parseGenerationLink(profilePath, entry.Name())
+		if !ok {
+			continue
+		}
+		// Like nix profile history, use the time that the link was created.
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		history = append(history, Generation{
+			Number:  num,
+			Time:    info.ModTime(),
+			Current: num == current.Generation,
+		})
+	}
+	slices.SortFunc(history, func(a, b Generation) int { return a.Number - b.Number })
+
+	var prev []string
+	for i := range history {
+		link := filepath.Join(filepath.Dir(profilePath), fmt.Sprintf("%s-%d-link", filepath.Base(profilePath), history[i].Number))
+		pkgs, err := profilePackageVersions(link)
+		if err != nil {
+			return nil, err
+		}
+		history[i].Removed, history[i].Added = lo.Difference(prev, pkgs)
+		prev = pkgs
+	}
+	return history, nil
+}
+
+// profilePackageVersions returns the <name>-<version> of each active package
+// in the profile at profilePath.
+func profilePackageVersions(profilePath string) ([]string, error) {
+	elements, err := nix.ProfileElements(profilePath)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []string
+	for elem := range elements {
+		if !elem.Active {
+			continue
+		}
+		if len(elem.StorePaths) == 0 {
+			pkgs = append(pkgs, profileElementName(elem))
+			continue
+		}
+		// /nix/store/<hash>-<name>-<version>
+		base := filepath.Base(elem.StorePaths[0])
+		_, nameVersion, _ := strings.Cut(base, "-")
+		pkgs = append(pkgs, nameVersion)
+	}
+	slices.Sort(pkgs)
+	return pkgs, nil
+}
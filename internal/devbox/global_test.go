@@ -0,0 +1,440 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.jetpack.io/devbox/internal/devbox/devopt"
+	"go.jetpack.io/devbox/internal/devbox/envpath"
+	"go.jetpack.io/devbox/internal/envir"
+	"go.jetpack.io/devbox/internal/nix"
+	"go.jetpack.io/devbox/internal/shenv"
+)
+
+func TestMissingStorePaths(t *testing.T) {
+	store := t.TempDir()
+	present := filepath.Join(store, "aaaa-hello-2.12.1")
+	require.NoError(t, os.Mkdir(present, 0o755))
+	gone := filepath.Join(store, "bbbb-ripgrep-14.1.0")
+
+	manifests := map[string]string{
+		"modern": fmt.Sprintf(`{"version": 3, "elements": {
+			"hello": {"active": true, "storePaths": [%q]},
+			"ripgrep": {"active": true, "storePaths": [%q]}
+		}}`, present, gone),
+		"legacy": fmt.Sprintf(`{"version": 2, "elements": [
+			{"active": true, "storePaths": [%q]},
+			{"active": true, "attrPath": "legacyPackages.x86_64-linux.ripgrep", "storePaths": [%q]}
+		]}`, present, gone),
+	}
+	for name, manifest := range manifests {
+		t.Run(name, func(t *testing.T) {
+			profile := t.TempDir()
+			err := os.WriteFile(filepath.Join(profile, "manifest.json"), []byte(manifest), 0o644)
+			require.NoError(t, err)
+
+			got, err := missingStorePaths(profile)
+			require.NoError(t, err)
+			assert.Equal(t, []missingStorePath{{pkg: "ripgrep", storePath: gone}}, got)
+		})
+	}
+}
+
+func TestMissingStorePathsNoManifest(t *testing.T) {
+	got, err := missingStorePaths(t.TempDir())
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestGlobalProfileDrifted(t *testing.T) {
+	globalPath := t.TempDir()
+	profileDir := t.TempDir()
+	profilePath := filepath.Join(profileDir, "default")
+
+	// switchGeneration simulates nix creating a new profile generation.
+	switchGeneration := func(gen int, manifest string) {
+		t.Helper()
+		link := fmt.Sprintf("default-%d-link", gen)
+		require.NoError(t, os.Mkdir(filepath.Join(profileDir, link), 0o755))
+		err := os.WriteFile(filepath.Join(profileDir, link, "manifest.json"), []byte(manifest), 0o644)
+		require.NoError(t, err)
+		_ = os.Remove(profilePath)
+		require.NoError(t, os.Symlink(link, profilePath))
+	}
+
+	drifted, err := globalProfileDrifted(globalPath, profilePath)
+	require.NoError(t, err)
+	assert.False(t, drifted, "drifted without a recorded state")
+
+	switchGeneration(1, `{"version": 3, "elements": {}}`)
+	require.NoError(t, recordGlobalProfileState(globalPath, profilePath))
+	drifted, err = globalProfileDrifted(globalPath, profilePath)
+	require.NoError(t, err)
+	assert.False(t, drifted, "drifted right after recording state")
+
+	switchGeneration(2, `{"version": 3, "elements": {"hello": {"active": true}}}`)
+	drifted, err = globalProfileDrifted(globalPath, profilePath)
+	require.NoError(t, err)
+	assert.True(t, drifted, "didn't drift after a new generation")
+}
+
+func TestGlobalDataPathOverride(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(envir.DevboxGlobalDataDir, dir)
+
+	got, err := GlobalDataPath()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, currentGlobalProfile), got)
+	assert.DirExists(t, got)
+
+	current, err := os.Readlink(filepath.Join(dir, "current"))
+	require.NoError(t, err)
+	assert.Equal(t, got, current)
+}
+
+func TestGlobalDestroy(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(envir.DevboxGlobalDataDir, dir)
+
+	path, err := GlobalDataPath()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "devbox.json"), []byte("{}"), 0o644))
+
+	// Destroying twice must succeed, since it's a no-op once the profile is
+	// gone.
+	for range 2 {
+		var out bytes.Buffer
+		require.NoError(t, GlobalDestroy(&out))
+		assert.NoDirExists(t, path)
+		_, err = os.Lstat(filepath.Join(dir, "current"))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	}
+}
+
+func TestGlobalCurrentLinkOverride(t *testing.T) {
+	d, _ := globalDevboxForTesting(t, "{}")
+	d.nix = &testNix{}
+	dir := filepath.Dir(d.projectDir)
+
+	// Renaming the link removes the one left under the old name.
+	t.Setenv(envir.DevboxGlobalCurrentLink, "active")
+	path, err := GlobalDataPath()
+	require.NoError(t, err)
+	current, err := os.Readlink(filepath.Join(dir, "active"))
+	require.NoError(t, err)
+	assert.Equal(t, path, current)
+	_, err = os.Lstat(filepath.Join(dir, defaultGlobalCurrentLink))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// devbox global shellenv puts the bin path behind the renamed link in
+	// PATH.
+	var b strings.Builder
+	opts := devopt.EnvExportsOpts{DontRecomputeEnvironment: true}
+	require.NoError(t, d.WriteEnvExports(context.Background(), &b, opts))
+	assert.Contains(t, b.String(), nix.ProfileBinPath(filepath.Join(dir, "active")))
+	assert.NotContains(t, b.String(), nix.ProfileBinPath(path))
+
+	// GlobalDestroy removes the link even if it's left under an old name.
+	t.Setenv(envir.DevboxGlobalCurrentLink, "")
+	require.NoError(t, os.Symlink(path, filepath.Join(dir, "active")))
+	require.NoError(t, GlobalDestroy(&bytes.Buffer{}))
+	for _, name := range []string{"active", defaultGlobalCurrentLink} {
+		_, err = os.Lstat(filepath.Join(dir, name))
+		assert.ErrorIs(t, err, fs.ErrNotExist, "link %q", name)
+	}
+
+	for _, name := range []string{"a/b", "..", currentGlobalProfile} {
+		t.Setenv(envir.DevboxGlobalCurrentLink, name)
+		_, err = GlobalDataPath()
+		assert.Error(t, err, "link name %q", name)
+	}
+}
+
+func TestGlobalDataPathXDGDataHome(t *testing.T) {
+	dataHome := t.TempDir()
+	t.Setenv(envir.XDGDataHome, dataHome)
+	t.Setenv(envir.DevboxGlobalDataDir, "")
+
+	got, err := GlobalDataPath()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dataHome, "devbox/global", currentGlobalProfile), got)
+
+	// The bin path that devbox global shellenv exports must be the one that
+	// the in-PATH check looks for.
+	d := &Devbox{projectDir: got}
+	exported := d.ProfileBinPath()
+	assert.True(t, strings.HasPrefix(exported, dataHome+string(filepath.Separator)), "bin path %s isn't in XDG_DATA_HOME", exported)
+	t.Setenv(envpath.PathStackEnv, envpath.Key(d.ProjectDirHash())+":"+envpath.InitPathEnv)
+
+	t.Setenv("PATH", envpath.JoinPathLists(exported, "/usr/bin"))
+	assert.NoError(t, d.EnsureGlobalProfileInPath())
+
+	t.Setenv("PATH", envpath.JoinPathLists(filepath.Join(dataHome, "devbox/global/current/bin"), "/usr/bin"))
+	assert.ErrorIs(t, d.EnsureGlobalProfileInPath(), ErrGlobalProfileNotInPath)
+}
+
+func TestSnapshotGlobalGeneration(t *testing.T) {
+	globalPath := t.TempDir()
+	profileDir := t.TempDir()
+	profilePath := filepath.Join(profileDir, "default")
+
+	// No profile yet, so there's no generation to snapshot.
+	require.NoError(t, snapshotGlobalGeneration(globalPath, profilePath, []byte("{}")))
+	gens, err := globalGenerations(globalPath)
+	require.NoError(t, err)
+	assert.Empty(t, gens)
+
+	lock := []byte(`{"lockfile_version": "1"}`)
+	require.NoError(t, os.WriteFile(filepath.Join(globalPath, "devbox.lock"), lock, 0o644))
+	for _, gen := range []int{3, 10} {
+		link := fmt.Sprintf("default-%d-link", gen)
+		require.NoError(t, os.Mkdir(filepath.Join(profileDir, link), 0o755))
+		_ = os.Remove(profilePath)
+		require.NoError(t, os.Symlink(link, profilePath))
+
+		config := fmt.Sprintf(`{"packages": ["gen%d"]}`, gen)
+		require.NoError(t, snapshotGlobalGeneration(globalPath, profilePath, []byte(config)))
+
+		dir := filepath.Join(globalPath, globalGenerationsPath, fmt.Sprint(gen))
+		got, err := os.ReadFile(filepath.Join(dir, "devbox.json"))
+		require.NoError(t, err)
+		assert.Equal(t, config, string(got))
+		got, err = os.ReadFile(filepath.Join(dir, "devbox.lock"))
+		require.NoError(t, err)
+		assert.Equal(t, lock, got)
+	}
+
+	gens, err = globalGenerations(globalPath)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 10}, gens, "generations should sort numerically")
+}
+
+func TestSnapshotNewGlobalGeneration(t *testing.T) {
+	globalPath := t.TempDir()
+	profileDir := t.TempDir()
+	profilePath := filepath.Join(profileDir, "default")
+	require.NoError(t, os.Mkdir(filepath.Join(profileDir, "default-2-link"), 0o755))
+	require.NoError(t, os.Symlink("default-2-link", profilePath))
+	snapshot := filepath.Join(globalPath, globalGenerationsPath, "2", "devbox.json")
+
+	// Generation 2 is new, so it's snapshotted.
+	require.NoError(t, snapshotNewGlobalGeneration(globalPath, profilePath, 1, []byte(`{"packages": ["a"]}`)))
+	got, err := os.ReadFile(snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, `{"packages": ["a"]}`, string(got))
+
+	// Syncing didn't create a generation, so the snapshot of the config that
+	// did create it must be kept.
+	require.NoError(t, snapshotNewGlobalGeneration(globalPath, profilePath, 2, []byte(`{"packages": ["b"]}`)))
+	got, err = os.ReadFile(snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, `{"packages": ["a"]}`, string(got))
+}
+
+func TestProfileHistory(t *testing.T) {
+	profileDir := t.TempDir()
+	profilePath := filepath.Join(profileDir, "default")
+
+	manifests := []string{
+		`{"version": 3, "elements": {
+			"hello": {"active": true, "storePaths": ["/nix/store/aaaa-hello-2.12.1"]}
+		}}`,
+		`{"version": 3, "elements": {
+			"hello": {"active": true, "storePaths": ["/nix/store/aaaa-hello-2.12.1"]},
+			"jq": {"active": true, "storePaths": ["/nix/store/bbbb-jq-1.7.1"]}
+		}}`,
+		`{"version": 3, "elements": {
+			"hello": {"active": true, "storePaths": ["/nix/store/cccc-hello-2.12.2"]},
+			"jq": {"active": true, "storePaths": ["/nix/store/bbbb-jq-1.7.1"]}
+		}}`,
+	}
+	for i, manifest := range manifests {
+		link := filepath.Join(profileDir, fmt.Sprintf("default-%d-link", i+1))
+		require.NoError(t, os.Mkdir(link, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(link, "manifest.json"), []byte(manifest), 0o644))
+	}
+	require.NoError(t, os.Symlink("default-2-link", profilePath))
+
+	history, err := profileHistory(profilePath)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+
+	assert.Equal(t, 1, history[0].Number)
+	assert.Equal(t, []string{"hello-2.12.1"}, history[0].Added)
+	assert.Empty(t, history[0].Removed)
+
+	assert.True(t, history[1].Current)
+	assert.Equal(t, []string{"jq-1.7.1"}, history[1].Added)
+	assert.Empty(t, history[1].Removed)
+
+	assert.False(t, history[2].Current)
+	assert.Equal(t, []string{"hello-2.12.2"}, history[2].Added)
+	assert.Equal(t, []string{"hello-2.12.1"}, history[2].Removed)
+}
+
+func TestStorePathVersion(t *testing.T) {
+	tests := map[string]string{
+		"/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-2.12.1":   "2.12.1",
+		"/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-python3-3.12.4": "3.12.4",
+		"/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-go-1.22.1-man":  "1.22.1",
+		"/opt/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-ripgrep-14.1.0": "14.1.0",
+		"/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-source":         "",
+		"/nix/store/short-hello-2.12.1":                              "",
+	}
+	for path, want := range tests {
+		assert.Equal(t, want, storePathVersion(path), "storePathVersion(%q)", path)
+	}
+}
+
+func TestEnsureGlobalProfileInPath(t *testing.T) {
+	d := &Devbox{projectDir: t.TempDir()}
+	t.Setenv("PATH", envpath.JoinPathLists(d.ProfileBinPath(), "/usr/bin"))
+
+	t.Setenv(envpath.PathStackEnv, "")
+	err := d.EnsureGlobalProfileInPath()
+	assert.ErrorIs(t, err, ErrGlobalProfileNotInPath)
+
+	t.Setenv(envpath.PathStackEnv, envpath.Key(d.ProjectDirHash())+":"+envpath.InitPathEnv)
+	assert.NoError(t, d.EnsureGlobalProfileInPath())
+
+	t.Setenv("PATH", "/usr/bin")
+	assert.ErrorIs(t, d.EnsureGlobalProfileInPath(), ErrGlobalProfileNotInPath)
+}
+
+func TestProfileInstallTimes(t *testing.T) {
+	profileDir := t.TempDir()
+	profilePath := filepath.Join(profileDir, "default")
+
+	// hello is removed in generation 2 and installed again in generation 3.
+	manifests := []string{
+		`{"version": 3, "elements": {
+			"hello": {"active": true, "storePaths": ["/nix/store/aaaa-hello-2.12.1"]},
+			"jq": {"active": true, "storePaths": ["/nix/store/bbbb-jq-1.7.1"]}
+		}}`,
+		`{"version": 3, "elements": {
+			"jq": {"active": true, "storePaths": ["/nix/store/bbbb-jq-1.7.1"]}
+		}}`,
+		`{"version": 3, "elements": {
+			"hello": {"active": true, "storePaths": ["/nix/store/aaaa-hello-2.12.1"]},
+			"jq": {"active": true, "storePaths": ["/nix/store/bbbb-jq-1.7.1"]}
+		}}`,
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, manifest := range manifests {
+		link := filepath.Join(profileDir, fmt.Sprintf("default-%d-link", i+1))
+		require.NoError(t, os.Mkdir(link, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(link, "manifest.json"), []byte(manifest), 0o644))
+		gen := base.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, os.Chtimes(link, gen, gen))
+	}
+	require.NoError(t, os.Symlink("default-3-link", profilePath))
+
+	times, err := profileInstallTimes(profilePath)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Time{
+		"/nix/store/aaaa-hello-2.12.1": base.Add(2 * time.Hour),
+		"/nix/store/bbbb-jq-1.7.1":     base,
+	}, lo.MapValues(times, func(t time.Time, _ string) time.Time { return t.UTC() }))
+}
+
+// globalDevboxForTesting opens the global devbox in a temporary global data
+// directory, with config as its devbox.json. Everything that it prints goes
+// to the returned buffer.
+func globalDevboxForTesting(t *testing.T, config string) (*Devbox, *bytes.Buffer) {
+	t.Helper()
+	t.Setenv(envir.DevboxGlobalDataDir, t.TempDir())
+	t.Setenv(envpath.PathStackEnv, "")
+	path, err := GlobalDataPath()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "devbox.json"), []byte(config), 0o644))
+
+	stderr := &bytes.Buffer{}
+	d, err := Open(&devopt.Opts{Dir: path, Stderr: stderr})
+	require.NoError(t, err)
+	return d, stderr
+}
+
+func TestRemoveGlobalOutput(t *testing.T) {
+	config := `{"packages": ["hello@latest"], "package_groups": {"greet": ["hello"]}}`
+	ctx := context.Background()
+
+	d, stderr := globalDevboxForTesting(t, config)
+	require.NoError(t, d.RemoveGlobal(ctx, []string{"hello@latest", "ripgrep"}, devopt.RemoveOpts{}))
+	assert.Contains(t, stderr.String(), "the following packages were not found in your devbox.json: ripgrep")
+	assert.Contains(t, stderr.String(), "These package groups no longer have any packages in devbox.json: greet.")
+	saved, err := os.ReadFile(filepath.Join(d.projectDir, "devbox.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), `"greet"`, "the empty group was removed without --prune-groups")
+
+	d, stderr = globalDevboxForTesting(t, config)
+	require.NoError(t, d.RemoveGlobal(ctx, []string{"hello@latest"}, devopt.RemoveOpts{PruneGroups: true}))
+	assert.NotContains(t, stderr.String(), "no longer have any packages")
+	saved, err = os.ReadFile(filepath.Join(d.projectDir, "devbox.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(saved), `"greet"`, "the empty group was kept with --prune-groups")
+}
+
+func TestPlanAddGlobal(t *testing.T) {
+	const rev = "b22db301217578a8edfccccf5cedafe5fc54e78b"
+	d, _ := globalDevboxForTesting(t, `{"packages": ["hello@latest"]}`)
+	lock := `{"lockfile_version": "1", "packages": {
+		"hello@latest": {"resolved": "github:NixOS/nixpkgs/` + rev + `#hello"}
+	}}`
+	require.NoError(t, os.WriteFile(filepath.Join(d.projectDir, "devbox.lock"), []byte(lock), 0o644))
+	d, err := Open(&devopt.Opts{Dir: d.projectDir, Stderr: &bytes.Buffer{}})
+	require.NoError(t, err)
+	d.validator = stubValidator{packages: []string{"hello@latest", "ripgrep@latest"}}
+
+	plan, err := d.PlanAddGlobal(context.Background(), []string{"hello", "ripgrep", "nope"}, devopt.AddOpts{})
+	require.NoError(t, err)
+	want := []PlannedPackage{
+		{Package: "hello", Name: "hello@latest", Installed: true, Commit: rev, Valid: true},
+		{Package: "ripgrep", Name: "ripgrep@latest", Valid: true},
+		{Package: "nope", Error: "Package nope not found"},
+	}
+	assert.Equal(t, want, plan)
+
+	config, err := os.ReadFile(filepath.Join(d.projectDir, "devbox.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"packages": ["hello@latest"]}`, string(config), "PlanAddGlobal changed devbox.json")
+}
+
+func TestGlobalPackageEnv(t *testing.T) {
+	d, _ := globalDevboxForTesting(t, `{
+		"packages": {"hello": {"version": "latest", "env": {"GREETING": "hi", "EDITOR": "nano"}}},
+		"env": {"EDITOR": "vim"}
+	}`)
+	d.nix = &testNix{}
+	ctx := context.Background()
+	opts := devopt.EnvExportsOpts{
+		DontRecomputeEnvironment: true,
+		EnvOptions:               devopt.EnvOptions{OmitNixEnv: true},
+	}
+	export := func(k, v string) string { return "export " + k + "=" + shenv.BashEscape(v) + ";" }
+
+	var b strings.Builder
+	require.NoError(t, d.Shellenv(ctx, shenv.Bash, &b, opts))
+	assert.Contains(t, b.String(), export("GREETING", "hi"), "the global env should have the package's env")
+	assert.Contains(t, b.String(), export("EDITOR", "vim"), "the top-level env should override the package's env")
+	assert.NotContains(t, b.String(), export("EDITOR", "nano"))
+
+	d.cfg.PackageMutator().Remove("hello@latest")
+	b.Reset()
+	require.NoError(t, d.Shellenv(ctx, shenv.Bash, &b, opts))
+	assert.NotContains(t, b.String(), "GREETING", "the package's env should go away with the package")
+	assert.Contains(t, b.String(), export("EDITOR", "vim"))
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	_ "embed"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/alessio/shellescape"
+	"github.com/pkg/errors"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+	"go.jetpack.io/devbox/internal/shenv"
+	"go.jetpack.io/devbox/internal/ux"
+)
+
+//go:embed globalexport.tmpl
+var globalExportText string
+
+//go:embed globalexport_fish.tmpl
+var globalExportFishText string
+
+var globalExportFuncs = template.FuncMap{"join": strings.Join}
+
+var (
+	globalExportTmpl = template.Must(
+		template.New("globalexport").Funcs(globalExportFuncs).Parse(globalExportText))
+	globalExportFishTmpl = template.Must(
+		template.New("globalexport_fish").Funcs(globalExportFuncs).Parse(globalExportFishText))
+)
+
+// globalExportShells are the shells that ExportGlobalScript can write a
+// script for.
+var globalExportShells = []string{"bash", "fish", "ksh", "posix", "zsh"}
+
+// ExportGlobalScript writes a script in the syntax of sh that installs the
+// global packages into the default nix profile with `nix profile install`,
+// adds the profile to PATH and sets the env of the global devbox.json. It's
+// for bootstrapping a similar environment on machines without devbox, so
+// packages are pinned to the nixpkgs commit in devbox.lock. Packages that
+// can't be installed with nix, such as runx packages, are skipped with a
+// warning.
+func (d *Devbox) ExportGlobalScript(sh shenv.Shell, w io.Writer) error {
+	tmpl := globalExportTmpl
+	switch sh.Name() {
+	case "fish":
+		tmpl = globalExportFishTmpl
+	case "bash", "ksh", "posix", "zsh":
+	default:
+		return usererr.New(
+			"Can't export an activation script for %s. Supported shells are: %s",
+			sh.Name(), strings.Join(globalExportShells, ", "),
+		)
+	}
+
+	var packages, installables []string
+	for _, pkg := range d.TopLevelPackages() {
+		if pkg.IsRunX() {
+			ux.Fwarningf(d.stderr, "Skipping %s, runx packages can't be installed without devbox.\n", pkg.Raw)
+			continue
+		}
+		installable, err := pkg.FlakeInstallable()
+		if err != nil {
+			return err
+		}
+		packages = append(packages, pkg.Raw)
+		installables = append(installables, shellescape.Quote(installable.String()))
+	}
+
+	err := tmpl.Execute(w, struct {
+		Shell        string
+		Packages     []string
+		Installables []string
+		ExportEnv    string
+	}{
+		Shell:        sh.Name(),
+		Packages:     packages,
+		Installables: installables,
+		ExportEnv:    shellExports(sh, d.cfg.Env()),
+	})
+	return errors.WithStack(err)
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.jetpack.io/devbox/internal/devbox/devopt"
+	"go.jetpack.io/devbox/internal/shenv"
+)
+
+func TestExportGlobalScript(t *testing.T) {
+	d := devboxForTesting(t)
+	d.cfg.Root.Env = map[string]string{"EDITOR": "vim"}
+
+	tests := []struct {
+		sh   shenv.Shell
+		want []string
+	}{
+		{shenv.Bash, []string{`export PATH="$HOME/.nix-profile/bin:$PATH"`, "export EDITOR=$'vim';"}},
+		{shenv.Fish, []string{"set -x -g PATH $HOME/.nix-profile/bin $PATH", "set -x -g 'EDITOR' 'vim';"}},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		if err := d.ExportGlobalScript(test.sh, &b); err != nil {
+			t.Fatalf("ExportGlobalScript(%s) error: %v", test.sh.Name(), err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("ExportGlobalScript(%s) = %q, want it to contain %q", test.sh.Name(), b.String(), want)
+			}
+		}
+		if strings.Contains(b.String(), "profile install") {
+			t.Errorf("ExportGlobalScript(%s) installs packages for a config without any", test.sh.Name())
+		}
+	}
+
+	if err := d.ExportGlobalScript(shenv.Elvish, &strings.Builder{}); err == nil {
+		t.Error("ExportGlobalScript(elvish) succeeded, want an unsupported shell error")
+	}
+}
+
+func TestExportGlobalScriptLocked(t *testing.T) {
+	const rev = "b22db301217578a8edfccccf5cedafe5fc54e78b"
+	dir := t.TempDir()
+	config := `{"packages": ["hello@latest"]}`
+	lock := `{"lockfile_version": "1", "packages": {
+		"hello@latest": {"resolved": "github:NixOS/nixpkgs/` + rev + `#hello", "version": "2.12.1"}
+	}}`
+	if err := os.WriteFile(filepath.Join(dir, "devbox.json"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "devbox.lock"), []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Open(&devopt.Opts{Dir: dir, Stderr: os.Stderr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := d.ExportGlobalScript(shenv.Bash, &b); err != nil {
+		t.Fatalf("ExportGlobalScript(bash) error: %v", err)
+	}
+	want := "profile install 'github:NixOS/nixpkgs/" + rev + "#hello'"
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("ExportGlobalScript(bash) = %q, want it to install the locked revision with %q", b.String(), want)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+	"go.jetpack.io/devbox/internal/devpkg"
+	"go.jetpack.io/devbox/internal/nix"
+	"go.jetpack.io/devbox/internal/ux"
+)
+
+// profileTarget is an element of the global profile that the user asked to
+// remove by store path or index instead of by package name.
+type profileTarget struct {
+	// arg is how the user referred to the element, for messages.
+	arg   string
+	index int
+	elem  nix.ProfileElement
+}
+
+// resolveProfileTargets replaces the store paths in pkgs, and adds the
+// elements at indexes, with the names of the devbox.json packages that
+// installed them. It's for recovering when the config and the profile have
+// drifted apart and the user only knows what `nix profile list` shows.
+//
+// Elements that no package in devbox.json installed are returned separately,
+// since there's no config entry to remove for them.
+func (d *Devbox) resolveProfileTargets(pkgs []string, indexes []int) (names []string, orphans []profileTarget, err error) {
+	var targets []profileTarget
+	for _, pkg := range pkgs {
+		if storePath, _ := nix.StorePathOf(pkg); storePath == "" {
+			names = append(names, pkg)
+			continue
+		}
+		targets = append(targets, profileTarget{arg: pkg, index: -1})
+	}
+	for _, i := range indexes {
+		targets = append(targets, profileTarget{arg: "index " + strconv.Itoa(i), index: i})
+	}
+	if len(targets) == 0 {
+		return names, nil, nil
+	}
+
+	elements, err := nix.ProfileElements(filepath.Join(d.projectDir, nix.ProfilePath))
+	if err != nil {
+		return nil, nil, err
+	}
+	// The index of an element is its position in the manifest, which is
+	// the order that `nix profile list` prints elements in.
+	manifest := slices.Collect(elements)
+	for _, target := range targets {
+		found := false
+		if target.index >= 0 {
+			found = target.index < len(manifest)
+			if found {
+				target.elem = manifest[target.index]
+			}
+		} else {
+			target.elem, target.index, found = elementContaining(manifest, target.arg)
+		}
+		if !found {
+			return nil, nil, usererr.New(
+				"Nothing in the global profile matches %s. Run `nix profile list --profile %s` to see its packages.",
+				target.arg, filepath.Join(d.projectDir, nix.ProfilePath),
+			)
+		}
+
+		pkg := d.packageForProfileElement(target.elem)
+		if pkg == nil {
+			orphans = append(orphans, target)
+			continue
+		}
+		ux.Finfof(d.stderr, "%s is package %s from devbox.json\n", target.arg, pkg.Raw)
+		names = append(names, pkg.Raw)
+	}
+	return names, orphans, nil
+}
+
+// elementContaining returns the element of manifest, and its index, that
+// has the store path of path, which may also be a file in the store path,
+// such as the path of one of the package's binaries.
+func elementContaining(manifest []nix.ProfileElement, path string) (nix.ProfileElement, int, bool) {
+	storePath, _ := nix.StorePathOf(path)
+	for i, elem := range manifest {
+		if slices.Contains(elem.StorePaths, storePath) {
+			return elem, i, true
+		}
+	}
+	return nix.ProfileElement{}, -1, false
+}
+
+// packageForProfileElement returns the top-level package in devbox.json that
+// installed elem, or nil if none did. A package matches if one of its
+// resolved store paths is in elem or, failing that, if its name is the name
+// that nix gave elem.
+func (d *Devbox) packageForProfileElement(elem nix.ProfileElement) *devpkg.Package {
+	pkgs := d.TopLevelPackages()
+	for _, pkg := range pkgs {
+		resolved, err := pkg.GetResolvedStorePaths()
+		if err != nil {
+			continue
+		}
+		for _, p := range resolved {
+			if slices.Contains(elem.StorePaths, p) {
+				return pkg
+			}
+		}
+	}
+	name := profileElementName(elem)
+	for _, pkg := range pkgs {
+		if pkg.CanonicalName() == name {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// removeProfileOrphans removes elements from the global profile that aren't
+// installed by any package in devbox.json. Nix >= 2.20 only removes elements
+// by name, so the index is only used for older profiles that don't name
+// their elements.
+func (d *Devbox) removeProfileOrphans(orphans []profileTarget) error {
+	if len(orphans) == 0 {
+		return nil
+	}
+	refs := make([]string, 0, len(orphans))
+	for _, orphan := range orphans {
+		ref := orphan.elem.Name
+		if ref == "" {
+			ref = strconv.Itoa(orphan.index)
+		}
+		ux.Finfof(
+			d.stderr,
+			"%s (%s) isn't in devbox.json, removing it from the global profile only\n",
+			orphan.arg, profileElementName(orphan.elem),
+		)
+		refs = append(refs, ref)
+	}
+	return d.nix.ProfileRemove(filepath.Join(d.projectDir, nix.ProfilePath), refs...)
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.jetpack.io/devbox/internal/nix"
+)
+
+func TestElementContaining(t *testing.T) {
+	manifest, err := nix.ParseProfileManifest([]byte(`{"version": 3, "elements": {
+		"hello": {"active": true, "storePaths": ["/nix/store/aaaa-hello-2.12.1"]},
+		"ripgrep": {"active": true, "storePaths": ["/nix/store/bbbb-ripgrep-14.1.0", "/nix/store/cccc-ripgrep-14.1.0-doc"]}
+	}}`))
+	assert.NoError(t, err)
+
+	tests := map[string]int{
+		"/nix/store/aaaa-hello-2.12.1":             0,
+		"/nix/store/aaaa-hello-2.12.1/bin/hello":   0,
+		"/nix/store/cccc-ripgrep-14.1.0-doc":       1,
+		"/nix/store/bbbb-ripgrep-14.1.0/bin/rg":    1,
+		"/nix/store/dddd-jq-1.7":                   -1,
+		"/nix/store/aaaa-hello-2.12.1-doc/share/x": -1,
+	}
+	for path, want := range tests {
+		elem, got, ok := elementContaining(manifest, path)
+		if assert.Equal(t, want, got, "elementContaining(%q)", path) && ok {
+			assert.Equal(t, manifest[want].Name, elem.Name)
+		}
+		assert.Equal(t, want >= 0, ok, "elementContaining(%q)", path)
+	}
+}
+
+func TestResolveProfileTargets(t *testing.T) {
+	t.Setenv("__DEVBOX_NIX_SYSTEM", "x86_64-linux")
+	const (
+		hello   = "/nix/store/00000000000000000000000000000000-hello-2.12.1"
+		ripgrep = "/nix/store/11111111111111111111111111111111-ripgrep-14.1.0"
+	)
+	config := `{"packages": ["hello@latest"]}`
+	lock := fmt.Sprintf(`{"lockfile_version": "1", "packages": {"hello@latest": {
+		"resolved": "github:NixOS/nixpkgs/b22db301217578a8edfccccf5cedafe5fc54e78b#hello",
+		"version": "2.12.1",
+		"systems": {"x86_64-linux": {"outputs": [{"name": "out", "path": %q, "default": true}], "store_path": %q}}
+	}}}`, hello, hello)
+	// Elements are indexed in name order: hello is 0 and ripgrep is 1.
+	manifest := fmt.Sprintf(`{"version": 3, "elements": {
+		"hello": {"active": true, "storePaths": [%q]},
+		"ripgrep": {"active": true, "storePaths": [%q]}
+	}}`, hello, ripgrep)
+
+	t.Run("config packages", func(t *testing.T) {
+		d, stderr, _ := globalDevboxWithProfile(t, config, lock, manifest)
+		names, orphans, err := d.resolveProfileTargets([]string{"jq", hello + "/bin/hello"}, []int{0})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"jq", "hello@latest", "hello@latest"}, names)
+		assert.Empty(t, orphans)
+		assert.Contains(t, stderr.String(), hello+"/bin/hello is package hello@latest from devbox.json")
+		assert.Contains(t, stderr.String(), "index 0 is package hello@latest from devbox.json")
+	})
+
+	t.Run("orphans", func(t *testing.T) {
+		d, _, n := globalDevboxWithProfile(t, config, lock, manifest)
+		names, orphans, err := d.resolveProfileTargets([]string{ripgrep}, nil)
+		require.NoError(t, err)
+		assert.Empty(t, names)
+		require.NoError(t, d.removeProfileOrphans(orphans))
+		assert.Equal(t, [][]string{{"ripgrep"}}, n.removed, "orphans are removed by element name")
+
+		// Profiles from nix < 2.20 don't name their elements, so the
+		// orphan is removed by index.
+		legacy := fmt.Sprintf(`{"version": 2, "elements": [
+			{"active": true, "storePaths": [%q]},
+			{"active": true, "storePaths": [%q]}
+		]}`, hello, ripgrep)
+		d, _, n = globalDevboxWithProfile(t, config, lock, legacy)
+		_, orphans, err = d.resolveProfileTargets(nil, []int{1})
+		require.NoError(t, err)
+		require.NoError(t, d.removeProfileOrphans(orphans))
+		assert.Equal(t, [][]string{{"1"}}, n.removed, "legacy orphans are removed by index")
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		d, _, n := globalDevboxWithProfile(t, config, lock, manifest)
+		_, _, err := d.resolveProfileTargets(nil, []int{2})
+		assert.ErrorContains(t, err, "Nothing in the global profile matches index 2")
+		assert.Empty(t, n.removed)
+	})
+}
@@ -0,0 +1,185 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.jetpack.io/devbox/internal/devpkg"
+	"go.jetpack.io/devbox/internal/nix"
+	"go.jetpack.io/devbox/internal/ux"
+)
+
+// Orders that SortGlobalPackages can sort packages in.
+const (
+	GlobalSortName = "name"
+	GlobalSortDate = "date"
+	GlobalSortSize = "size"
+)
+
+// GlobalSortOrders lists the orders that SortGlobalPackages accepts.
+var GlobalSortOrders = []string{GlobalSortName, GlobalSortDate, GlobalSortSize}
+
+// SortGlobalPackages sorts the packages of the global profile by name, by
+// install date (newest first) or by closure size (largest first). Packages
+// without a date or size, such as those that aren't installed yet, sort last
+// by name. If the dates or sizes can't be read at all, it warns and sorts by
+// name instead.
+func (d *Devbox) SortGlobalPackages(ctx context.Context, pkgs []*devpkg.Package, by string) ([]*devpkg.Package, error) {
+	if !slices.Contains(GlobalSortOrders, by) {
+		return nil, errors.Errorf("unknown sort order %q, must be one of: %s", by, strings.Join(GlobalSortOrders, ", "))
+	}
+	sorted := slices.Clone(pkgs)
+	slices.SortStableFunc(sorted, func(a, b *devpkg.Package) int {
+		return strings.Compare(a.Versioned(), b.Versioned())
+	})
+	if by == GlobalSortName {
+		return sorted, nil
+	}
+
+	keys, err := d.globalSortKeys(ctx, sorted, by)
+	if err != nil {
+		ux.Fwarningf(d.stderr, "Unable to sort packages by %s, sorting by name instead: %v\n", by, err)
+		return sorted, nil
+	}
+	slices.SortStableFunc(sorted, func(a, b *devpkg.Package) int {
+		ka, oka := keys[a]
+		kb, okb := keys[b]
+		switch {
+		case oka && okb:
+			return cmp.Compare(kb, ka)
+		case oka:
+			return -1
+		case okb:
+			return 1
+		}
+		return 0
+	})
+	return sorted, nil
+}
+
+// globalSortKeys returns the install time in Unix nanoseconds or the closure
+// size in bytes of each package in pkgs that's in the global profile.
+func (d *Devbox) globalSortKeys(ctx context.Context, pkgs []*devpkg.Package, by string) (map[*devpkg.Package]int64, error) {
+	profilePath, err := d.profilePath()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := installedPackages(profilePath, d.lockfile, pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]int64
+	if by == GlobalSortDate {
+		times, err := profileInstallTimes(profilePath)
+		if err != nil {
+			return nil, err
+		}
+		values = make(map[string]int64, len(times))
+		for storePath, t := range times {
+			values[storePath] = t.UnixNano()
+		}
+	} else {
+		var storePaths []string
+		for _, pkg := range installed {
+			storePaths = append(storePaths, pkg.StorePaths...)
+		}
+		values, err = nix.ClosureSizes(ctx, storePaths)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := map[*devpkg.Package]int64{}
+	for i, pkg := range installed {
+		for _, storePath := range pkg.StorePaths {
+			v, ok := values[storePath]
+			if !ok {
+				continue
+			}
+			// A package's outputs are installed together, so use the
+			// newest time, and count the size of every output.
+			if by == GlobalSortDate {
+				keys[pkgs[i]] = max(keys[pkgs[i]], v)
+			} else {
+				keys[pkgs[i]] += v
+			}
+		}
+	}
+	return keys, nil
+}
+
+// profileInstallTimes returns the time that each store path in the current
+// generation of the profile at profilePath was installed. That's the time of
+// the earliest generation that it's been in ever since, so a package that was
+// removed and then installed again gets the time of the reinstall.
+func profileInstallTimes(profilePath string) (map[string]time.Time, error) {
+	entries, err := os.ReadDir(filepath.Dir(profilePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]time.Time{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	current, err := readProfileState(profilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	type generation struct {
+		num  int
+		time time.Time
+	}
+	var gens []generation
+	for _, entry := range entries {
+		num, ok := parseGenerationLink(profilePath, entry.Name())
+		if !ok || num > current.Generation {
+			continue
+		}
+		// Like nix profile history, use the time that the link was created.
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		gens = append(gens, generation{num: num, time: info.ModTime()})
+	}
+	slices.SortFunc(gens, func(a, b generation) int { return a.num - b.num })
+
+	times := map[string]time.Time{}
+	for _, gen := range gens {
+		link := filepath.Join(filepath.Dir(profilePath), fmt.Sprintf("%s-%d-link", filepath.Base(profilePath), gen.num))
+		elements, err := nix.ProfileElements(link)
+		if err != nil {
+			slog.Debug("unable to read profile generation", "gen", gen.num, "err", err)
+			continue
+		}
+		next := map[string]time.Time{}
+		for elem := range elements {
+			if !elem.Active {
+				continue
+			}
+			for _, storePath := range elem.StorePaths {
+				if t, ok := times[storePath]; ok {
+					next[storePath] = t
+				} else {
+					next[storePath] = gen.time
+				}
+			}
+		}
+		times = next
+	}
+	return times, nil
+}
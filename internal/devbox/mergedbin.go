@@ -0,0 +1,114 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"cmp"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.jetpack.io/devbox/internal/cachehash"
+	"go.jetpack.io/devbox/internal/devbox/envpath"
+)
+
+// longPathThreshold is the length of PATH that devbox warns about. Linux
+// refuses to start a program with an environment variable longer than 128
+// KiB, and some tools have lower limits.
+const longPathThreshold = 64 * 1024
+
+const longPathWarning = "The PATH of the devbox environment is %d bytes long, " +
+	"which can exceed the limits of some shells and programs. " +
+	"Use --merge-path-bin to replace its nix store directories with a single directory.\n"
+
+// mergedBinMembersFile is the file in a merged bin directory that records
+// which directories it links to.
+const mergedBinMembersFile = ".devbox-members"
+
+func (d *Devbox) mergedBinDir() string {
+	return filepath.Join(d.projectDir, ".devbox/merged-bin")
+}
+
+// mergePathBin replaces the nix store directories in the path list with dir,
+// a single directory of symlinks to the files in them. When more than one
+// store directory has a file with the same name, dir links to the one that
+// comes first, the same as a PATH lookup would find.
+//
+// dir takes the place of the first store directory, so a store directory
+// that came after another directory now comes before it. Directories outside
+// of the nix store are left as is, since their contents can change.
+//
+// Store paths don't change, so dir is only rebuilt when the set of store
+// directories changes.
+func mergePathBin(path, dir string) (string, error) {
+	nixStore := cmp.Or(os.Getenv("NIX_STORE"), "/nix/store") + "/"
+
+	var storeDirs, merged []string
+	for _, entry := range envpath.SplitList(path) {
+		if !strings.HasPrefix(entry, nixStore) {
+			merged = append(merged, entry)
+			continue
+		}
+		if len(storeDirs) == 0 {
+			merged = append(merged, dir)
+		}
+		storeDirs = append(storeDirs, entry)
+	}
+	if len(storeDirs) == 0 {
+		return path, nil
+	}
+	if err := ensureMergedBin(dir, storeDirs); err != nil {
+		return "", err
+	}
+	return envpath.JoinList(merged...), nil
+}
+
+// ensureMergedBin builds dir from storeDirs unless it's already up to date.
+// It builds the new directory next to dir and then swaps it in, so that a
+// failed build doesn't leave dir half built.
+func ensureMergedBin(dir string, storeDirs []string) error {
+	members, err := cachehash.JSON(storeDirs)
+	if err != nil {
+		return err
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, mergedBinMembersFile)); err == nil && string(got) == members {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".merged-bin-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, storeDir := range storeDirs {
+		entries, err := os.ReadDir(storeDir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || entry.Name() == mergedBinMembersFile {
+				continue
+			}
+			err := os.Symlink(filepath.Join(storeDir, entry.Name()), filepath.Join(tmp, entry.Name()))
+			if err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmp, mergedBinMembersFile), []byte(members), 0o644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
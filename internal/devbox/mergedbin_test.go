@@ -0,0 +1,67 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePathBin(t *testing.T) {
+	store := t.TempDir()
+	t.Setenv("NIX_STORE", store)
+	mkbin := func(pkg string, names ...string) string {
+		t.Helper()
+		bin := filepath.Join(store, pkg, "bin")
+		require.NoError(t, os.MkdirAll(bin, 0o755))
+		for _, name := range names {
+			require.NoError(t, os.WriteFile(filepath.Join(bin, name), nil, 0o755))
+		}
+		return bin
+	}
+	hello := mkbin("aaaa-hello", "hello", "shared")
+	jq := mkbin("bbbb-jq", "jq", "shared")
+	dir := filepath.Join(t.TempDir(), "merged-bin")
+
+	list := func(paths ...string) string { return strings.Join(paths, string(filepath.ListSeparator)) }
+	got, err := mergePathBin(list("/usr/local/bin", hello, "/usr/bin", jq), dir)
+	require.NoError(t, err)
+	assert.Equal(t, list("/usr/local/bin", dir, "/usr/bin"), got)
+
+	for name, want := range map[string]string{"hello": hello, "jq": jq, "shared": hello} {
+		target, err := os.Readlink(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(want, name), target, "link for %s", name)
+	}
+
+	// The directory isn't rebuilt while the store directories stay the same.
+	marker := filepath.Join(dir, "marker")
+	require.NoError(t, os.WriteFile(marker, nil, 0o644))
+	_, err = mergePathBin(list(hello, jq), dir)
+	require.NoError(t, err)
+	assert.FileExists(t, marker)
+
+	// It's rebuilt when they change.
+	_, err = mergePathBin(list(jq), dir)
+	require.NoError(t, err)
+	assert.NoFileExists(t, marker)
+	assert.NoFileExists(t, filepath.Join(dir, "hello"))
+	target, err := os.Readlink(filepath.Join(dir, "shared"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(jq, "shared"), target)
+}
+
+func TestMergePathBinNoStoreDirs(t *testing.T) {
+	t.Setenv("NIX_STORE", t.TempDir())
+	dir := filepath.Join(t.TempDir(), "merged-bin")
+	got, err := mergePathBin("/usr/bin", dir)
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/bin", got)
+	assert.NoDirExists(t, dir)
+}
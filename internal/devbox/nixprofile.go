@@ -5,12 +5,17 @@
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"os/exec"
 	"strings"
 
 	"github.com/samber/lo"
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/debug"
+	"go.jetpack.io/devbox/internal/devbox/envpath"
 	"go.jetpack.io/devbox/internal/nix"
 	"go.jetpack.io/devbox/internal/nix/nixprofile"
+	"go.jetpack.io/devbox/internal/ux"
 )
 
 // syncNixProfileFromFlake ensures the nix profile has the packages from the buildInputs
@@ -64,25 +69,169 @@ func (d *Devbox) syncNixProfileFromFlake(ctx context.Context) error {
 		}
 	}
 	if len(add) > 0 {
-		if err = nix.ProfileInstall(ctx, &nix.ProfileInstallArgs{
-			Installables: add,
+		if err := d.installToNixProfile(ctx, profilePath, add); err != nil {
+			return err
+		}
+		if d.isGlobal() {
+			d.runPostInstallHooks(ctx, add)
+		}
+	}
+	if d.isGlobal() {
+		// Record the state of the profile so that `devbox global doctor` can
+		// tell if it's changed outside of devbox.
+		if err := recordGlobalProfileState(d.projectDir, profilePath); err != nil {
+			slog.Error("failed to record global profile state", "err", err)
+		}
+	}
+	return nil
+}
+
+// installToNixProfile installs store paths into the nix profile at
+// profilePath.
+func (d *Devbox) installToNixProfile(ctx context.Context, profilePath string, add []string) error {
+	// Install packages with a user-specified priority separately so that nix
+	// uses their priority instead of the next available one.
+	priorities := d.storePathPriorities()
+	var addDefault []string
+	for _, addPath := range add {
+		priority, ok := priorities[addPath]
+		if !ok {
+			addDefault = append(addDefault, addPath)
+			continue
+		}
+		err := nix.ProfileInstall(ctx, &nix.ProfileInstallArgs{
+			Installables: []string{addPath},
 			ProfilePath:  profilePath,
 			Writer:       d.stderr,
-		}); errors.Is(err, nix.ErrPriorityConflict) {
-			// We need to install the packages one by one because there was possibly a priority conflict
-			// This is slower, but uncommon.
-			for _, addPath := range add {
-				if err = nix.ProfileInstall(ctx, &nix.ProfileInstallArgs{
-					Installables: []string{addPath},
-					ProfilePath:  profilePath,
-					Writer:       d.stderr,
-				}); err != nil {
-					return fmt.Errorf("error installing package in nix profile %s: %w", addPath, err)
-				}
-			}
+			Priority:     &priority,
+			Verbose:      d.verbose,
+		})
+		if errors.Is(err, nix.ErrPriorityConflict) {
+			// Both packages have the same priority, so nix can't pick one.
+			return d.priorityConflictError(err, addPath)
 		} else if err != nil {
-			return fmt.Errorf("error installing packages in nix profile %s: %w", add, err)
+			return fmt.Errorf("error installing package in nix profile %s: %w", addPath, err)
+		}
+	}
+	if len(addDefault) == 0 {
+		return nil
+	}
+
+	err := nix.ProfileInstall(ctx, &nix.ProfileInstallArgs{
+		Installables: addDefault,
+		ProfilePath:  profilePath,
+		Writer:       d.stderr,
+		Verbose:      d.verbose,
+	})
+	if errors.Is(err, nix.ErrPriorityConflict) {
+		// We need to install the packages one by one because there was possibly a priority conflict
+		// This is slower, but uncommon.
+		for _, addPath := range addDefault {
+			err = nix.ProfileInstall(ctx, &nix.ProfileInstallArgs{
+				Installables: []string{addPath},
+				ProfilePath:  profilePath,
+				Writer:       d.stderr,
+				Verbose:      d.verbose,
+			})
+			if errors.Is(err, nix.ErrPriorityConflict) {
+				return d.priorityConflictError(err, addPath)
+			} else if err != nil {
+				return fmt.Errorf("error installing package in nix profile %s: %w", addPath, err)
+			}
 		}
+	} else if err != nil {
+		return fmt.Errorf("error installing packages in nix profile %s: %w", addDefault, err)
 	}
 	return nil
 }
+
+// priorityConflictError explains a conflict between the package at addPath
+// and a package that's already in the nix profile. If nix named the
+// conflicting files, it names both packages and the file they both provide.
+func (d *Devbox) priorityConflictError(err error, addPath string) error {
+	addCmd := "devbox add"
+	if d.isGlobal() {
+		addCmd = "devbox global add"
+	}
+	fix := fmt.Sprintf(
+		"Re-add one of the packages with `%s --priority <n> <pkg>` to choose which one "+
+			"wins (lower values take precedence).", addCmd)
+
+	var conflict *nix.PriorityConflictError
+	if errors.As(err, &conflict) {
+		existing, file := nix.StorePathOf(conflict.Existing)
+		added, _ := nix.StorePathOf(conflict.New)
+		if existing != "" && added != "" {
+			return usererr.WithUserMessage(err,
+				"Package %s conflicts with package %s in the nix profile, since both provide %s. %s",
+				storePathPackageName(added), storePathPackageName(existing), file, fix,
+			)
+		}
+	}
+	return usererr.WithUserMessage(err,
+		"Package %s provides a file that conflicts with another package in the nix profile. %s",
+		storePathPackageName(addPath), fix,
+	)
+}
+
+// storePathPackageName returns the name of the package at storePath, such as
+// hello for /nix/store/<hash>-hello-2.12.1, or storePath itself if it isn't
+// a store path.
+func storePathPackageName(storePath string) string {
+	if len(strings.TrimPrefix(storePath, "/nix/store/")) <= 33 {
+		return storePath
+	}
+	return nix.NewStorePathParts(storePath).Name
+}
+
+// storePathPriorities maps the store paths of packages that have a
+// user-specified profile priority to that priority. It only consults the
+// lockfile, so packages without resolved store paths are omitted.
+func (d *Devbox) storePathPriorities() map[string]int {
+	priorities := map[string]int{}
+	for _, pkg := range d.InstallablePackages() {
+		if pkg.Priority == nil {
+			continue
+		}
+		storePaths, err := pkg.GetResolvedStorePaths()
+		if err != nil {
+			slog.Debug("failed to get store paths for package priority", "pkg", pkg.Raw, "err", err)
+			continue
+		}
+		for _, p := range storePaths {
+			priorities[p] = *pkg.Priority
+		}
+	}
+	return priorities
+}
+
+// runPostInstallHooks runs the post_install command of each package that had
+// a store path in installed. The commands run with the profile's bin directory
+// at the front of PATH so that they can use the package they follow. A failing
+// command is reported as a warning without undoing the install.
+func (d *Devbox) runPostInstallHooks(ctx context.Context, installed []string) {
+	for _, pkg := range d.InstallablePackages() {
+		if pkg.PostInstall == "" {
+			continue
+		}
+		storePaths, err := pkg.GetResolvedStorePaths()
+		if err != nil {
+			slog.Debug("failed to get store paths for post-install hook", "pkg", pkg.Raw, "err", err)
+			continue
+		}
+		if !lo.Some(installed, storePaths) {
+			continue
+		}
+
+		ux.Finfof(d.stderr, "Running post_install for %s\n", pkg.Raw)
+		cmd := exec.CommandContext(ctx, "sh", "-c", pkg.PostInstall)
+		cmd.Dir = d.projectDir
+		cmd.Env = append(os.Environ(), "PATH="+envpath.JoinPathLists(
+			d.ProfileBinPath(), os.Getenv("PATH")))
+		cmd.Stdout = d.stderr
+		cmd.Stderr = d.stderr
+		if err := cmd.Run(); err != nil {
+			ux.Fwarningf(d.stderr, "post_install for %s failed: %v\n", pkg.Raw, err)
+		}
+	}
+}
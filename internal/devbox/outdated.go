@@ -0,0 +1,113 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"go.jetpack.io/devbox/internal/devpkg"
+	"go.jetpack.io/devbox/internal/nix"
+)
+
+// Statuses of an [OutdatedPackage].
+const (
+	PackageUpToDate      = "up-to-date"
+	PackageOutdated      = "outdated"
+	PackageStatusUnknown = "unknown"
+)
+
+// OutdatedPackage compares the installed version of a package with the
+// newest version that `devbox update` would upgrade it to.
+type OutdatedPackage struct {
+	Name      string `json:"name"`
+	Installed string `json:"installed"`
+	Latest    string `json:"latest"`
+	Status    string `json:"status"`
+
+	// Error explains why the status is unknown.
+	Error string `json:"error,omitempty"`
+}
+
+// Outdated checks each of the packages in devbox.json for a newer version.
+// It reads the installed versions from the nix profile's manifest and
+// resolves the latest versions the same way as Update, but it doesn't change
+// devbox.lock or the profile. Packages that can't be checked, such as flake
+// references, have an unknown status instead of failing the whole check.
+func (d *Devbox) Outdated(ctx context.Context) ([]OutdatedPackage, error) {
+	profilePath, err := d.profilePath()
+	if err != nil {
+		return nil, err
+	}
+	pkgs := d.TopLevelPackages()
+	installed, err := installedPackages(profilePath, d.lockfile, pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	outdated := make([]OutdatedPackage, 0, len(pkgs))
+	for i, pkg := range pkgs {
+		result := OutdatedPackage{Name: pkg.Raw}
+		if len(installed[i].StorePaths) == 0 {
+			result.Status = PackageStatusUnknown
+			result.Error = "package isn't installed in the nix profile"
+			outdated = append(outdated, result)
+			continue
+		}
+		result.Installed = storePathVersion(installed[i].StorePaths[0])
+
+		result.Latest, err = d.latestVersion(pkg)
+		switch {
+		case err != nil:
+			result.Status = PackageStatusUnknown
+			result.Error = err.Error()
+		case result.Installed == result.Latest:
+			result.Status = PackageUpToDate
+		default:
+			result.Status = PackageOutdated
+		}
+		outdated = append(outdated, result)
+	}
+	return outdated, nil
+}
+
+// latestVersion returns the version that pkg resolves to now. Versioned
+// packages resolve with the Devbox search service and packages without a
+// version resolve in the nixpkgs from devbox.json.
+func (d *Devbox) latestVersion(pkg *devpkg.Package) (string, error) {
+	if pkg.IsLegacy() {
+		results, err := nix.Search(d.lockfile.LegacyNixpkgsPath(pkg.Raw))
+		if err != nil {
+			return "", err
+		}
+		for _, info := range results {
+			return info.Version, nil
+		}
+		return "", errors.Errorf("%s not found in nixpkgs", pkg.Raw)
+	}
+	if !pkg.IsDevboxPackage {
+		return "", errors.New("can't check flake references for newer versions")
+	}
+	resolved, err := d.lockfile.FetchResolvedPackage(pkg.Raw)
+	if err != nil {
+		return "", err
+	}
+	if resolved == nil {
+		return "", errors.Errorf("couldn't resolve %s", pkg.Raw)
+	}
+	return resolved.Version, nil
+}
+
+// storePathVersion returns the package version in a store path, such as
+// 2.12.1 for /nix/store/<hash>-hello-2.12.1.
+func storePathVersion(storePath string) string {
+	// <32 character hash>-<name>-<version>
+	base := filepath.Base(storePath)
+	if len(base) < 34 || base[32] != '-' {
+		return ""
+	}
+	return nix.NewStorePathParts(base).Version
+}
@@ -4,6 +4,7 @@
 package devbox
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -24,6 +25,7 @@
 	"go.jetpack.io/devbox/internal/devconfig/configfile"
 	"go.jetpack.io/devbox/internal/devpkg"
 	"go.jetpack.io/devbox/internal/devpkg/pkgtype"
+	"go.jetpack.io/devbox/internal/envir"
 	"go.jetpack.io/devbox/internal/lock"
 	"go.jetpack.io/devbox/internal/setup"
 	"go.jetpack.io/devbox/internal/shellgen"
@@ -45,33 +47,110 @@
 // Add adds the `pkgs` to the config (i.e. devbox.json) and nix profile for this
 // devbox project
 func (d *Devbox) Add(ctx context.Context, pkgsNames []string, opts devopt.AddOpts) error {
+	_, err := d.add(ctx, pkgsNames, opts)
+	return err
+}
+
+// add implements Add and returns the packages that it added or that were
+// already in the config.
+func (d *Devbox) add(ctx context.Context, pkgsNames []string, opts devopt.AddOpts) ([]*devpkg.Package, error) {
 	ctx, task := trace.NewTask(ctx, "devboxAdd")
 	defer task.End()
 
+	pkgsNames, err := d.cfg.Root.ExpandPackageGroups(pkgsNames)
+	if err != nil {
+		return nil, err
+	}
+
+	// cfgBefore tells whether the add changed devbox.json at all.
+	cfgBefore := d.cfg.Root.Bytes()
+
+	// Pin the global config's nixpkgs commit before validating the packages,
+	// so that it's saved along with them. Global packages are long-lived, and
+	// they shouldn't move to a different nixpkgs when devbox changes the
+	// default.
+	if d.isGlobal() {
+		d.cfg.Root.PinNixpkgsCommit()
+	}
+
 	// Track which packages had no changes so we can report that to the user.
 	unchangedPackageNames := []string{}
+	// With opts.KeepGoing, track which packages we skipped because they failed
+	// to validate.
+	failedPackageNames := []string{}
+	failures := []PackageFailure{}
 
 	// Only add packages that are not already in config. If same canonical exists,
-	// replace it.
-	pkgs := devpkg.PackagesFromStringsWithOptions(lo.Uniq(pkgsNames), d.lockfile, opts)
+	// replace it. Devbox packages can select their own outputs in addition to
+	// opts.Outputs with name^out1,out2.
+	pkgs := []*devpkg.Package{}
+	pkgOutputs := map[*devpkg.Package][]string{}
+	for _, raw := range lo.UniqBy(pkgsNames, devpkg.NormalizeRaw) {
+		pkg, outputs := d.newAddPackage(raw, opts)
+		pkgs = append(pkgs, pkg)
+		pkgOutputs[pkg] = outputs
+	}
 
 	// addedPackageNames keeps track of the possibly transformed (versioned)
 	// names of added packages (even if they are already in config). We use this
 	// to know the exact name to mark as allowed insecure later on.
 	addedPackageNames := []string{}
+	// addedOutputs are the outputs to record for each of addedPackageNames.
+	addedOutputs := map[string][]string{}
 	existingPackageNames := lo.Map(
 		d.cfg.Root.TopLevelPackages(), func(p configfile.Package, _ int) string {
 			return p.VersionedName()
 		})
+	// installedCount is the number of global packages that are already in
+	// both devbox.json and the nix profile, and reinstall are the profile
+	// store paths to remove so that --force installs them again.
+	installedCount := 0
+	reinstall := []string{}
 	for _, pkg := range pkgs {
 		// If exact versioned package is already in the config, we can skip the
-		// next loop that only deals with newPackages.
-		if slices.Contains(existingPackageNames, pkg.Versioned()) {
+		// next loop that only deals with newPackages. The config may spell
+		// it differently, such as nixpkgs#go for go@latest, so use its name.
+		if name, ok := equivalentPackageName(existingPackageNames, pkg.Versioned()); ok {
 			// But we still need to add to addedPackageNames. See its comment.
-			addedPackageNames = append(addedPackageNames, pkg.Versioned())
-			unchangedPackageNames = append(unchangedPackageNames, pkg.Versioned())
-			ux.Finfof(d.stderr, "Package %q already in devbox.json\n", pkg.Versioned())
+			if err := d.validateOutputs(name, opts, pkgOutputs[pkg]); err != nil {
+				return nil, err
+			}
+			addedPackageNames = append(addedPackageNames, name)
+			addedOutputs[name] = pkgOutputs[pkg]
+			unchangedPackageNames = append(unchangedPackageNames, name)
+			storePaths := d.globalProfileStorePaths(pkg)
+			switch {
+			case len(storePaths) > 0 && opts.Force:
+				ux.Finfof(d.stderr, "Reinstalling package %q\n", name)
+				reinstall = append(reinstall, storePaths...)
+			case len(storePaths) > 0:
+				ux.Finfof(d.stderr, "%s is already installed (use --force to reinstall).\n", name)
+				installedCount++
+			default:
+				ux.Finfof(d.stderr, "Package %q already in devbox.json\n", name)
+			}
+			continue
+		}
+
+		endValidate := d.timings.start("validate " + pkg.Raw)
+		packageNameForConfig, err := d.packageNameForConfig(ctx, pkg, opts)
+		if err == nil && !opts.SkipValidation {
+			err = d.validateOutputs(packageNameForConfig, opts, pkgOutputs[pkg])
+		}
+		endValidate()
+		if err != nil {
+			failures = append(failures, PackageFailure{
+				Package: pkg.Raw,
+				Stage:   AddStageValidate,
+				Message: err.Error(),
+			})
+		}
+		if err != nil && opts.KeepGoing {
+			ux.Ferrorf(d.stderr, "Failed to add package %q: %v\n", pkg.Raw, err)
+			failedPackageNames = append(failedPackageNames, pkg.Raw)
 			continue
+		} else if err != nil {
+			return nil, &AddError{Failures: failures, err: err}
 		}
 
 		// On the other hand, if there's a package with same canonical name, replace
@@ -82,50 +161,274 @@ func (d *Devbox) Add(ctx context.Context, pkgsNames []string, opts devopt.AddOpt
 		if found != nil {
 			ux.Finfof(d.stderr, "Replacing package %q in devbox.json\n", found.Raw)
 			if err := d.Remove(ctx, found.Raw); err != nil {
-				return err
+				return nil, err
 			}
 		}
 
-		// validate that the versioned package exists in the search endpoint.
-		// if not, fallback to legacy vanilla nix.
-		versionedPkg := devpkg.PackageFromStringWithOptions(pkg.Versioned(), d.lockfile, opts)
-
-		packageNameForConfig := pkg.Raw
-		ok, err := versionedPkg.ValidateExists(ctx)
-		if (err == nil && ok) || errors.Is(err, devpkg.ErrCannotBuildPackageOnSystem) {
-			// Only use versioned if it exists in search. We can disregard the error
-			// about not building on the current system, since user's can continue
-			// via --exclude-platform flag.
-			packageNameForConfig = pkg.Versioned()
-		} else if !versionedPkg.IsDevboxPackage {
-			// This means it didn't validate and we don't want to fallback to legacy
-			// Just propagate the error.
-			return err
-		} else if _, err := nix.Search(d.lockfile.LegacyNixpkgsPath(pkg.Raw)); err != nil {
-			// This means it looked like a devbox package or attribute path, but we
-			// could not find it in search or in the legacy nixpkgs path.
-			return usererr.New("Package %s not found", pkg.Raw)
-		}
-
 		ux.Finfof(d.stderr, "Adding package %q to devbox.json\n", packageNameForConfig)
 		d.cfg.PackageMutator().Add(packageNameForConfig)
 		addedPackageNames = append(addedPackageNames, packageNameForConfig)
+		addedOutputs[packageNameForConfig] = pkgOutputs[pkg]
 	}
 
+	if len(failedPackageNames) > 0 && len(addedPackageNames) == 0 {
+		return nil, &AddError{
+			Failures: failures,
+			err:      usererr.New("Failed to add packages: %s", strings.Join(failedPackageNames, ", ")),
+		}
+	}
 	// Options must be set before ensureStateIsUpToDate. See comment in function
 	if err := d.setPackageOptions(addedPackageNames, opts); err != nil {
-		return err
+		return nil, err
+	}
+	for _, name := range addedPackageNames {
+		if err := d.cfg.PackageMutator().SetOutputs(d.stderr, name, addedOutputs[name]); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := d.ensureStateIsUpToDate(ctx, install); err != nil {
-		return usererr.WithUserMessage(err, "There was an error installing nix packages")
+	// Syncing the profile evaluates the whole flake even when it ends up
+	// installing nothing, so skip it if every package is already installed
+	// and the add didn't change any of their options.
+	if installedCount > 0 && installedCount == len(pkgs) && bytes.Equal(cfgBefore, d.cfg.Root.Bytes()) {
+		return pkgs, nil
 	}
 
-	if err := d.saveCfg(); err != nil {
+	sync := func() error { return d.ensureStateIsUpToDate(ctx, install) }
+	if len(reinstall) > 0 {
+		err = d.reinstallGlobalPackages(ctx, reinstall, sync)
+	} else {
+		err = sync()
+	}
+	if err != nil {
+		// Nix installs all of the packages at once, so blame every package
+		// that this add changed.
+		for _, name := range addedPackageNames {
+			if !slices.Contains(unchangedPackageNames, name) {
+				failures = append(failures, PackageFailure{
+					Package: name,
+					Stage:   AddStageInstall,
+					Message: err.Error(),
+				})
+			}
+		}
+		return nil, &AddError{
+			Failures: failures,
+			err:      usererr.WithUserMessage(err, "There was an error installing nix packages"),
+		}
+	}
+
+	endSave := d.timings.start("save config")
+	err = d.saveCfg()
+	endSave()
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs = lo.Filter(pkgs, func(p *devpkg.Package, _ int) bool {
+		return !slices.Contains(failedPackageNames, p.Raw)
+	})
+	if err := d.printPostAddMessage(ctx, pkgs, unchangedPackageNames, opts); err != nil {
+		return nil, err
+	}
+	if len(failedPackageNames) > 0 {
+		ux.Fwarningf(
+			d.stderr,
+			"Skipped packages that could not be added: %s\n",
+			strings.Join(failedPackageNames, ", "),
+		)
+		if opts.ErrorOnSkipped {
+			return pkgs, &AddError{
+				Failures: failures,
+				err: usererr.New(
+					"Skipped packages that could not be added: %s",
+					strings.Join(failedPackageNames, ", "),
+				),
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// Stages of [Devbox.Add] that a package can fail in.
+const (
+	// AddStageValidate is when devbox checks that the package exists.
+	AddStageValidate = "validate"
+	// AddStageInstall is when nix installs the package.
+	AddStageInstall = "install"
+)
+
+// PackageFailure describes why [Devbox.Add] failed to add a package.
+type PackageFailure struct {
+	Package string `json:"package"`
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+}
+
+// AddError is returned by [Devbox.Add] when it fails to add one or more
+// packages. It lists each failed package so that callers can report them in a
+// structured form.
+type AddError struct {
+	Failures []PackageFailure
+
+	// err is the error that describes the failure to the user.
+	err error
+}
+
+func (e *AddError) Error() string { return e.err.Error() }
+
+func (e *AddError) Unwrap() error { return e.err }
+
+// equivalentPackageName returns the name in names that refers to the same
+// package as name, according to [devpkg.NormalizeRaw].
+func equivalentPackageName(names []string, name string) (string, bool) {
+	normalized := devpkg.NormalizeRaw(name)
+	for _, n := range names {
+		if devpkg.NormalizeRaw(n) == normalized {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// globalProfileStorePaths returns the store paths of the elements of the
+// global nix profile that provide pkg, or nil if d isn't the global project or
+// pkg isn't installed. It only reads the profile's manifest, so it's cheap to
+// call before deciding whether an add needs to run nix.
+func (d *Devbox) globalProfileStorePaths(pkg *devpkg.Package) []string {
+	if !d.isGlobal() {
+		return nil
+	}
+	profilePath, err := d.profilePath()
+	if err != nil {
+		return nil
+	}
+	installed, err := installedPackages(profilePath, d.lockfile, []*devpkg.Package{pkg})
+	if err != nil {
+		slog.Debug("unable to check if package is in the global profile", "pkg", pkg.Raw, "err", err)
+		return nil
+	}
+	return installed[0].StorePaths
+}
+
+// reinstallGlobalPackages removes storePaths from the global profile and
+// then calls sync, which installs them again because they're still in the
+// config. If sync fails, it rolls the profile back to the generation from
+// before the removal so that a failed reinstall doesn't lose the packages.
+func (d *Devbox) reinstallGlobalPackages(ctx context.Context, storePaths []string, sync func() error) error {
+	profilePath, err := d.profilePath()
+	if err != nil {
+		return err
+	}
+	before := profileGeneration(profilePath)
+	if err := d.nix.ProfileRemove(profilePath, storePaths...); err != nil {
+		return err
+	}
+	err = sync()
+	if err == nil || before == 0 {
 		return err
 	}
+	if rollbackErr := d.nix.ProfileRollback(ctx, profilePath, before); rollbackErr != nil {
+		slog.Error("failed to restore global profile after failed reinstall", "generation", before, "err", rollbackErr)
+		ux.Fwarningf(
+			d.stderr,
+			"Failed to restore the packages that were removed to reinstall them. "+
+				"Run `devbox global rollback --to %d` to restore them.\n",
+			before,
+		)
+	}
+	return err
+}
+
+// newAddPackage returns the package for a name that was passed to add, and
+// its outputs: opts.Outputs plus any that the name selects with
+// name^out1,out2.
+func (d *Devbox) newAddPackage(raw string, opts devopt.AddOpts) (*devpkg.Package, []string) {
+	name, outputs := devpkg.SplitOutputs(raw)
+	opts.Outputs = append(slices.Clone(opts.Outputs), outputs...)
+	return devpkg.PackageFromStringWithOptions(name, d.lockfile, opts), opts.Outputs
+}
+
+// validateOutputs checks that the package with the name from
+// packageNameForConfig has each of the outputs that the user selected.
+func (d *Devbox) validateOutputs(name string, opts devopt.AddOpts, outputs []string) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+	opts.Outputs = outputs
+	return devpkg.PackageFromStringWithOptions(name, d.lockfile, opts).ValidateOutputs()
+}
+
+// packageNameForConfig validates that pkg exists and returns the name to
+// write to devbox.json. It prefers the versioned name, and falls back to the
+// legacy nixpkgs name if the package isn't in the search index. With
+// opts.SkipValidation, it returns the versioned name without any checks.
+func (d *Devbox) packageNameForConfig(
+	ctx context.Context,
+	pkg *devpkg.Package,
+	opts devopt.AddOpts,
+) (string, error) {
+	if opts.SkipValidation {
+		return pkg.Versioned(), nil
+	}
+	// validate that the versioned package exists in the search endpoint.
+	// if not, fallback to legacy vanilla nix.
+	versionedPkg := devpkg.PackageFromStringWithOptions(pkg.Versioned(), d.lockfile, opts)
+
+	ok, err := d.packageValidator().ValidateExists(ctx, versionedPkg)
+	if (err == nil && ok) || errors.Is(err, devpkg.ErrCannotBuildPackageOnSystem) {
+		// Only use versioned if it exists in search. We can disregard the error
+		// about not building on the current system, since user's can continue
+		// via --exclude-platform flag.
+		return pkg.Versioned(), nil
+	} else if err != nil && envir.IsOffline() {
+		// The legacy fallback can't help offline, and the error explains why
+		// the package isn't available.
+		return "", err
+	} else if !versionedPkg.IsDevboxPackage {
+		// This means it didn't validate and we don't want to fallback to legacy
+		// Just propagate the error.
+		return "", err
+	} else if !d.packageValidator().LegacyExists(d.lockfile.LegacyNixpkgsPath(pkg.Raw)) {
+		// This means it looked like a devbox package or attribute path, but we
+		// could not find it in search or in the legacy nixpkgs path.
+		return "", usererr.New("Package %s not found", pkg.Raw)
+	}
+	return pkg.Raw, nil
+}
+
+// PackageValidator checks that packages exist before devbox adds them to
+// devbox.json.
+type PackageValidator interface {
+	// ValidateExists reports whether pkg is in the search index or the
+	// binary cache. See [devpkg.Package.ValidateExists].
+	ValidateExists(ctx context.Context, pkg *devpkg.Package) (bool, error)
+
+	// LegacyExists reports whether a nixpkgs installable, such as
+	// github:NixOS/nixpkgs/<commit>#hello, exists.
+	LegacyExists(installable string) bool
+}
+
+// nixPackageValidator is the PackageValidator that devbox uses unless a test
+// replaces it.
+type nixPackageValidator struct{}
 
-	return d.printPostAddMessage(ctx, pkgs, unchangedPackageNames, opts)
+func (nixPackageValidator) ValidateExists(ctx context.Context, pkg *devpkg.Package) (bool, error) {
+	return pkg.ValidateExists(ctx)
+}
+
+func (nixPackageValidator) LegacyExists(installable string) bool {
+	_, err := nix.Search(installable)
+	return err == nil
+}
+
+// packageValidator returns d.validator, or the nix-backed validator if
+// d.validator is nil.
+func (d *Devbox) packageValidator() PackageValidator {
+	if d.validator == nil {
+		return nixPackageValidator{}
+	}
+	return d.validator
 }
 
 func (d *Devbox) setPackageOptions(pkgs []string, opts devopt.AddOpts) error {
@@ -156,6 +459,12 @@ func (d *Devbox) setPackageOptions(pkgs []string, opts devopt.AddOpts) error {
 			d.stderr, pkg, opts.AllowInsecure); err != nil {
 			return err
 		}
+		if opts.Priority != nil {
+			if err := d.cfg.PackageMutator().SetPriority(
+				pkg, *opts.Priority); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -179,7 +488,8 @@ func (d *Devbox) printPostAddMessage(
 		}
 	}
 
-	if len(opts.Platforms) == 0 && len(opts.ExcludePlatforms) == 0 && len(opts.Outputs) == 0 && len(opts.AllowInsecure) == 0 {
+	if len(opts.Platforms) == 0 && len(opts.ExcludePlatforms) == 0 && len(opts.Outputs) == 0 &&
+		len(opts.AllowInsecure) == 0 && opts.Priority == nil {
 		if len(unchangedPackageNames) == 1 {
 			ux.Finfof(d.stderr, "Package %q was already in devbox.json and was not modified\n", unchangedPackageNames[0])
 		} else if len(unchangedPackageNames) > 1 {
@@ -199,7 +509,7 @@ func (d *Devbox) Remove(ctx context.Context, pkgs ...string) error {
 
 	packagesToUninstall := []string{}
 	missingPkgs := []string{}
-	for _, pkg := range lo.Uniq(pkgs) {
+	for _, pkg := range lo.UniqBy(pkgs, devpkg.NormalizeRaw) {
 		found, _ := d.findPackageByName(pkg)
 		if found != nil {
 			packagesToUninstall = append(packagesToUninstall, found.Raw)
@@ -226,6 +536,7 @@ func (d *Devbox) Remove(ctx context.Context, pkgs ...string) error {
 		return err
 	}
 
+	defer d.timings.start("save config")()
 	return d.saveCfg()
 }
 
@@ -272,6 +583,13 @@ func (d *Devbox) ensureStateIsUpToDate(ctx context.Context, mode installMode) er
 		ux.Finfof(d.stderr, "Ensuring packages are installed.\n")
 	}
 
+	// Remember the global profile's generation so that we only snapshot the
+	// config below if syncing the profile creates a new one.
+	generationBefore := 0
+	if d.isGlobal() {
+		generationBefore = profileGeneration(filepath.Join(d.projectDir, nix.ProfilePath))
+	}
+
 	if mode != ensure {
 		// Reload includes because added/removed packages might change plugins. Cases:
 		// * New package adds built-in plugin. We wanna make sure the plugin is in config.
@@ -283,14 +601,20 @@ func (d *Devbox) ensureStateIsUpToDate(ctx context.Context, mode installMode) er
 	}
 
 	if mode == install || mode == update || mode == ensure {
-		if err := d.installPackages(ctx, mode); err != nil {
+		endInstall := d.timings.start("install packages")
+		err := d.installPackages(ctx, mode)
+		endInstall()
+		if err != nil {
 			return err
 		}
 	}
 
 	recomputeState := mode == ensure || d.IsEnvEnabled()
 	if recomputeState {
-		if err := d.recomputeState(ctx); err != nil {
+		endRecompute := d.timings.start("compute environment")
+		err := d.recomputeState(ctx)
+		endRecompute()
+		if err != nil {
 			return err
 		}
 	}
@@ -307,7 +631,27 @@ func (d *Devbox) ensureStateIsUpToDate(ctx context.Context, mode installMode) er
 		)
 	}
 
-	return d.updateLockfile(recomputeState)
+	endLockfile := d.timings.start("update lockfile")
+	err = d.updateLockfile(recomputeState)
+	endLockfile()
+	if err != nil {
+		return err
+	}
+	if d.isGlobal() {
+		// Snapshot the config that produced this profile generation so that
+		// `devbox global rollback` can restore it. devbox.json may not be
+		// saved yet, so use the in-memory config.
+		err := snapshotNewGlobalGeneration(
+			d.projectDir,
+			filepath.Join(d.projectDir, nix.ProfilePath),
+			generationBefore,
+			d.cfg.Root.Bytes(),
+		)
+		if err != nil {
+			slog.Error("failed to snapshot global profile generation", "err", err)
+		}
+	}
+	return nil
 }
 
 // updateLockfile will ensure devbox.lock is up to date with the current state of the project.update
@@ -481,8 +825,20 @@ func (d *Devbox) installNixPackagesToStore(ctx context.Context, mode installMode
 	}
 
 	args := &nix.BuildArgs{
-		Flags:  flags,
-		Writer: d.stderr,
+		Flags:   flags,
+		Writer:  d.stderr,
+		Verbose: d.verbose,
+	}
+	if d.isGlobal() {
+		// Global installs tend to be one-off adds where a long, silent
+		// build is confusing, so tell the user why it's taking a while.
+		args.OnBuildFromSource = func(drvName string) {
+			ux.Finfof(
+				d.stderr,
+				"No binary cache hit for %s; building from source (this may take a while).\n",
+				drvName,
+			)
+		}
 	}
 	err = d.appendExtraSubstituters(ctx, args)
 	if err != nil {
@@ -518,7 +874,9 @@ func(p *devpkg.Package, _ int) string { return p.Raw },
 		eventStart := time.Now()
 		args.AllowInsecure = allowInsecure
 		err = nix.Build(ctx, args, installables...)
-		if err != nil {
+		if err != nil && !allowInsecure {
+			return d.explainInsecureBuildFailure(ctx, err, packages, installables)
+		} else if err != nil {
 			return err
 		}
 		telemetry.Event(telemetry.EventNixBuildSuccess, telemetry.Metadata{
@@ -530,6 +888,68 @@ func(p *devpkg.Package, _ int) string { return p.Raw },
 	return nil
 }
 
+// explainInsecureBuildFailure checks if a nix build failed because one of
+// packages is marked as insecure and, if so, returns an error suggesting the
+// --allow-insecure flag. Packages with locked store paths skip evaluation
+// before the build, so otherwise the only sign of the problem is nix's build
+// output. It returns buildErr unchanged if no package is insecure.
+//
+// The build's output went to the terminal, so it evaluates installables again
+// with a dry run to see which packages nix refused. Only those packages are
+// evaluated one by one to find their known vulnerabilities.
+func (d *Devbox) explainInsecureBuildFailure(
+	ctx context.Context,
+	buildErr error,
+	packages []*devpkg.Package,
+	installables []string,
+) error {
+	evalErr := nix.DryRunBuild(ctx, &nix.BuildArgs{}, installables...)
+	insecure := nix.InsecurePackageNames(evalErr)
+	if len(insecure) == 0 {
+		return buildErr
+	}
+
+	addCmd := "devbox add"
+	if d.isGlobal() {
+		addCmd = "devbox global add"
+	}
+	for _, pkg := range packages {
+		if pkg.HasAllowInsecure() || !packageNamedIn(pkg, insecure) {
+			continue
+		}
+		installables, err := pkg.Installables()
+		if err != nil {
+			continue
+		}
+		for _, installable := range installables {
+			_, err := nix.StorePathsFromInstallable(ctx, installable, false /*allowInsecure*/)
+			if insecure, userErr := nix.IsExitErrorInsecurePackage(err, addCmd, pkg.Versioned(), installable); insecure {
+				return userErr
+			}
+		}
+	}
+	// The insecure package is a dependency of one of packages, so there's
+	// no top-level package to name.
+	_, userErr := nix.IsExitErrorInsecurePackage(evalErr, addCmd, "" /*pkgName*/, "" /*installable*/)
+	return userErr
+}
+
+// packageNamedIn reports whether one of pkg's locked store paths is for a
+// derivation in names, such as "openssl-1.1.1w".
+func packageNamedIn(pkg *devpkg.Package, names []string) bool {
+	storePaths, err := pkg.GetResolvedStorePaths()
+	if err != nil {
+		return false
+	}
+	for _, p := range storePaths {
+		parts := nix.NewStorePathParts(p)
+		if slices.Contains(names, parts.Name+"-"+parts.Version) {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Devbox) appendExtraSubstituters(ctx context.Context, args *nix.BuildArgs) error {
 	creds, err := nixcache.CachedCredentials(ctx)
 	if errors.Is(err, auth.ErrNotLoggedIn) {
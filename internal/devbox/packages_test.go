@@ -0,0 +1,144 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.jetpack.io/devbox/internal/devbox/devopt"
+	"go.jetpack.io/devbox/internal/devpkg"
+	"go.jetpack.io/devbox/internal/nix"
+)
+
+// stubValidator is a PackageValidator that only knows about the packages it
+// lists, so tests can add packages without nix.
+type stubValidator struct {
+	packages []string
+	legacy   []string
+}
+
+func (s stubValidator) ValidateExists(_ context.Context, pkg *devpkg.Package) (bool, error) {
+	return slices.Contains(s.packages, pkg.Raw), nil
+}
+
+func (s stubValidator) LegacyExists(installable string) bool {
+	return slices.Contains(s.legacy, installable)
+}
+
+func TestPackageNameForConfig(t *testing.T) {
+	d := devboxForTesting(t)
+	d.validator = stubValidator{
+		packages: []string{"hello@latest"},
+		legacy:   []string{d.lockfile.LegacyNixpkgsPath("python3Packages.requests")},
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"hello", "hello@latest", false},
+		{"python3Packages.requests", "python3Packages.requests", false},
+		{"nope", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			pkg := devpkg.PackageFromStringWithDefaults(tt.raw, d.lockfile)
+			got, err := d.packageNameForConfig(ctx, pkg, devopt.AddOpts{})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// globalDevboxWithProfile is like globalDevboxForTesting, but it also writes
+// lock to devbox.lock and gives the global profile a generation 3 with
+// manifest. Its nix is a testNix.
+func globalDevboxWithProfile(t *testing.T, config, lock, manifest string) (*Devbox, *bytes.Buffer, *testNix) {
+	t.Helper()
+	d, _ := globalDevboxForTesting(t, config)
+	require.NoError(t, os.WriteFile(filepath.Join(d.projectDir, "devbox.lock"), []byte(lock), 0o644))
+	profilePath := filepath.Join(d.projectDir, nix.ProfilePath)
+	generation := filepath.Join(filepath.Dir(profilePath), "default-3-link")
+	require.NoError(t, os.MkdirAll(generation, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(generation, "manifest.json"), []byte(manifest), 0o644))
+	require.NoError(t, os.Symlink(filepath.Base(generation), profilePath))
+
+	stderr := &bytes.Buffer{}
+	d, err := Open(&devopt.Opts{Dir: d.projectDir, Stderr: stderr})
+	require.NoError(t, err)
+	n := &testNix{}
+	d.nix = n
+	return d, stderr, n
+}
+
+func TestReinstallGlobalPackages(t *testing.T) {
+	storePaths := []string{"/nix/store/00000000000000000000000000000000-hello-2.12.1"}
+	ctx := context.Background()
+
+	d, _, n := globalDevboxWithProfile(t, `{}`, `{"lockfile_version": "1"}`, `{"version": 3, "elements": {}}`)
+	require.NoError(t, d.reinstallGlobalPackages(ctx, storePaths, func() error { return nil }))
+	assert.Equal(t, [][]string{storePaths}, n.removed)
+	assert.Empty(t, n.rolledBack, "rolled back after a successful reinstall")
+
+	// A failed reinstall restores the generation that still had the
+	// packages.
+	d, _, n = globalDevboxWithProfile(t, `{}`, `{"lockfile_version": "1"}`, `{"version": 3, "elements": {}}`)
+	errSync := errors.New("nix build failed")
+	err := d.reinstallGlobalPackages(ctx, storePaths, func() error { return errSync })
+	assert.ErrorIs(t, err, errSync)
+	assert.Equal(t, [][]string{storePaths}, n.removed)
+	assert.Equal(t, []int{3}, n.rolledBack)
+}
+
+func TestAddGlobalInstalled(t *testing.T) {
+	if _, err := exec.LookPath("nix"); err != nil {
+		t.Skip("nix not found in PATH")
+	}
+	t.Setenv("__DEVBOX_NIX_SYSTEM", "x86_64-linux")
+	const storePath = "/nix/store/00000000000000000000000000000000-hello-2.12.1"
+	config := `{"packages": ["hello@latest"], "nixpkgs": {"commit": "b22db301217578a8edfccccf5cedafe5fc54e78b"}}`
+	// The package resolves to a flake that doesn't exist, so installing it
+	// again fails.
+	lock := fmt.Sprintf(`{"lockfile_version": "1", "packages": {"hello@latest": {
+		"resolved": "path:%s#hello",
+		"version": "2.12.1",
+		"systems": {"x86_64-linux": {"outputs": [{"name": "out", "path": %q, "default": true}], "store_path": %q}}
+	}}}`, filepath.Join(t.TempDir(), "missing"), storePath, storePath)
+	manifest := fmt.Sprintf(`{"version": 3, "elements": {"hello": {"active": true, "storePaths": [%q]}}}`, storePath)
+	ctx := context.Background()
+
+	t.Run("skip", func(t *testing.T) {
+		d, stderr, n := globalDevboxWithProfile(t, config, lock, manifest)
+		err := d.Add(ctx, []string{"hello@latest"}, devopt.AddOpts{})
+		require.NoError(t, err)
+		assert.Contains(t, stderr.String(), "hello@latest is already installed (use --force to reinstall).")
+		assert.Empty(t, n.removed)
+	})
+
+	t.Run("force", func(t *testing.T) {
+		d, stderr, n := globalDevboxWithProfile(t, config, lock, manifest)
+		err := d.Add(ctx, []string{"hello@latest"}, devopt.AddOpts{Force: true})
+		assert.Error(t, err, "reinstalling a package that can't be built")
+		assert.Contains(t, stderr.String(), `Reinstalling package "hello@latest"`)
+		assert.Equal(t, [][]string{{storePath}}, n.removed)
+		assert.Equal(t, []int{3}, n.rolledBack, "the removed package wasn't restored")
+	})
+}
@@ -14,11 +14,12 @@
 func (d *Devbox) Pull(ctx context.Context, opts devopt.PullboxOpts) error {
 	ctx, task := trace.NewTask(ctx, "devboxPull")
 	defer task.End()
-	return pullbox.New(d, opts).Pull(ctx)
+	defer d.timings.start("pull config")()
+	return pullbox.New(d, d.stderr, opts).Pull(ctx)
 }
 
 func (d *Devbox) Push(ctx context.Context, opts devopt.PullboxOpts) error {
 	ctx, task := trace.NewTask(ctx, "devboxPush")
 	defer task.End()
-	return pullbox.New(d, opts).Push(ctx)
+	return pullbox.New(d, d.stderr, opts).Push(ctx)
 }
@@ -19,6 +19,7 @@
 	"github.com/alessio/shellescape"
 	"github.com/pkg/errors"
 	"go.jetpack.io/devbox/internal/devbox/devopt"
+	"go.jetpack.io/devbox/internal/devbox/envpath"
 	"go.jetpack.io/devbox/internal/shellgen"
 	"go.jetpack.io/devbox/internal/telemetry"
 
@@ -387,12 +388,12 @@ func (s *DevboxShell) linkShellStartupFiles(shellSettingsDir string) {
 
 func filterPathList(pathList string, keep func(string) bool) string {
 	filtered := []string{}
-	for _, path := range filepath.SplitList(pathList) {
+	for _, path := range envpath.SplitList(pathList) {
 		if keep(path) {
 			filtered = append(filtered, path)
 		}
 	}
-	return strings.Join(filtered, string(filepath.ListSeparator))
+	return envpath.JoinList(filtered...)
 }
 
 func isFishShell() bool {
@@ -0,0 +1,63 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// timings records how long each phase of an operation takes so that
+// --timings can show where the time went. A nil *timings records nothing,
+// which keeps the instrumented code free of checks when timings are off.
+type timings struct {
+	phases []timedPhase
+}
+
+type timedPhase struct {
+	name string
+	dur  time.Duration
+}
+
+// noopPhase is returned by a nil *timings so that timing a phase doesn't
+// allocate a closure when timings are off.
+var noopPhase = func() {}
+
+// start begins timing a phase and returns a function that ends it. Phases
+// with the same name, such as repeated installs, are reported separately.
+func (t *timings) start(name string) (end func()) {
+	if t == nil {
+		return noopPhase
+	}
+	start := time.Now()
+	return func() {
+		t.phases = append(t.phases, timedPhase{name: name, dur: time.Since(start)})
+	}
+}
+
+// print writes the recorded phases and their total to w.
+func (t *timings) print(w io.Writer) {
+	if t == nil || len(t.phases) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nTimings:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var total time.Duration
+	for _, phase := range t.phases {
+		fmt.Fprintf(tw, "  %s\t%s\n", phase.name, phase.dur.Round(time.Millisecond))
+		total += phase.dur
+	}
+	fmt.Fprintf(tw, "  total\t%s\n", total.Round(time.Millisecond))
+	tw.Flush()
+	t.phases = nil
+}
+
+// PrintTimings writes how long each phase of the operations since Open or
+// the last call to PrintTimings took. It does nothing unless Open was called
+// with [devopt.Opts.Timings].
+func (d *Devbox) PrintTimings() {
+	d.timings.print(d.stderr)
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimings(t *testing.T) {
+	timings := &timings{}
+	timings.start("validate hello")()
+	timings.start("install packages")()
+
+	var out bytes.Buffer
+	timings.print(&out)
+	for _, want := range []string{"Timings:", "validate hello", "install packages", "total"} {
+		assert.Contains(t, out.String(), want)
+	}
+	assert.Less(t, strings.Index(out.String(), "validate hello"), strings.Index(out.String(), "install packages"))
+
+	// Printing resets the phases.
+	out.Reset()
+	timings.print(&out)
+	assert.Empty(t, out.String())
+}
+
+func TestTimingsNil(t *testing.T) {
+	var timings *timings
+	assert.Zero(t, testing.AllocsPerRun(10, func() {
+		timings.start("install packages")()
+	}))
+
+	var out bytes.Buffer
+	timings.print(&out)
+	assert.Empty(t, out.String())
+}
@@ -73,7 +73,7 @@ func (d *Devbox) Update(ctx context.Context, opts devopt.UpdateOpts) error {
 	// It's definitely not needed for non-flakes. (which is 99.9% of packages)
 	// It will return an error if .devbox/gen/flake is missing
 	// TODO: Remove this if it's not needed.
-	_ = nix.FlakeUpdate(shellgen.FlakePath(d))
+	_ = nix.FlakeUpdate(d.stderr, shellgen.FlakePath(d))
 
 	// fix any missing store paths.
 	if err = d.FixMissingStorePaths(ctx); err != nil {
@@ -192,7 +192,7 @@ func (d *Devbox) attemptToUpgradeFlake(pkg *devpkg.Package) error {
 		pkg.Raw,
 	)
 
-	err = nixprofile.ProfileUpgrade(profilePath, pkg, d.lockfile)
+	err = nixprofile.ProfileUpgrade(d.stderr, profilePath, pkg, d.lockfile)
 	if err != nil {
 		ux.Fwarningf(
 			d.stderr,
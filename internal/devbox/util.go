@@ -80,7 +80,10 @@ func utilityLookPath(binName string) (string, error) {
 }
 
 func utilityDataPath() (string, error) {
-	path := xdg.DataSubpath("devbox/util")
+	path, err := xdg.DataSubpath("devbox/util")
+	if err != nil {
+		return "", err
+	}
 	return path, errors.WithStack(os.MkdirAll(path, 0o755))
 }
 
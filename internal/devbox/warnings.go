@@ -0,0 +1,40 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"sync"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+	"go.jetpack.io/devbox/internal/ux"
+)
+
+// shownWarnings holds the IDs of the warnings that PrintWarningOnce printed.
+// It's shared by every Devbox instead of being a field, since a single
+// command can open the same project more than once, such as once to run the
+// command and again to check that the global profile is in PATH.
+var shownWarnings = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: map[string]bool{}}
+
+// PrintWarningOnce prints the warning err unless a warning with the same
+// [usererr.WarningID] was already printed during this run of devbox, so that
+// multi-step commands don't repeat themselves. Warnings without an ID are
+// always printed.
+func (d *Devbox) PrintWarningOnce(err error) {
+	if err == nil {
+		return
+	}
+	if id := usererr.WarningID(err); id != "" {
+		shownWarnings.Lock()
+		shown := shownWarnings.ids[id]
+		shownWarnings.ids[id] = true
+		shownWarnings.Unlock()
+		if shown {
+			return
+		}
+	}
+	ux.Fwarning(d.stderr, err.Error())
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package devbox
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+)
+
+func TestPrintWarningOnce(t *testing.T) {
+	var out bytes.Buffer
+	d := &Devbox{stderr: &out}
+	other := &Devbox{stderr: &out}
+
+	warning := usererr.NewWarningWithID("test-print-warning-once", "repeated warning\n")
+	d.PrintWarningOnce(warning)
+	d.PrintWarningOnce(warning)
+	// A different Devbox in the same run must not print it again either.
+	other.PrintWarningOnce(usererr.NewWarningWithID("test-print-warning-once", "repeated warning\n"))
+	assert.Equal(t, 1, strings.Count(out.String(), "repeated warning"))
+
+	// Warnings without an ID are always printed.
+	out.Reset()
+	d.PrintWarningOnce(usererr.NewWarning("plain warning\n"))
+	d.PrintWarningOnce(usererr.NewWarning("plain warning\n"))
+	assert.Equal(t, 2, strings.Count(out.String(), "plain warning"))
+
+	assert.Equal(t, "global-profile-not-in-path", usererr.WarningID(ErrGlobalProfileNotInPath))
+	assert.Empty(t, usererr.WarningID(usererr.New("not a warning")))
+}
@@ -343,12 +343,23 @@ func (c *Config) NixPkgsCommitHash() string {
 	return c.Root.NixPkgsCommitHash()
 }
 
+func (c *Config) NixpkgsURL() string {
+	return c.Root.NixpkgsURL()
+}
+
 func (c *Config) Env() map[string]string {
+	env := c.PluginEnv()
+	maps.Copy(env, c.Root.Env)
+	return env
+}
+
+// PluginEnv returns the env of the included plugins, without the env from
+// the root config.
+func (c *Config) PluginEnv() map[string]string {
 	env := map[string]string{}
 	for _, i := range c.included {
 		maps.Copy(env, i.Env())
 	}
-	maps.Copy(env, c.Root.Env)
 	return env
 }
 
@@ -4,6 +4,7 @@
 	"bytes"
 	"regexp"
 	"slices"
+	"strings"
 
 	"github.com/tailscale/hujson"
 )
@@ -155,6 +156,95 @@ func (c *configAST) removePackage(name string) {
 	c.root.Format()
 }
 
+// setNixpkgsCommit sets the commit in the nixpkgs field, adding the field if
+// necessary.
+func (c *configAST) setNixpkgsCommit(commit string) {
+	root := c.root.Value.(*hujson.Object)
+	i := c.memberIndex(root, "nixpkgs")
+	if i == -1 {
+		root.Members = append(root.Members, hujson.ObjectMember{
+			Name:  hujson.Value{Value: hujson.String("nixpkgs"), BeforeExtra: []byte{'\n'}},
+			Value: hujson.Value{Value: &hujson.Object{}},
+		})
+		i = len(root.Members) - 1
+	}
+	nixpkgs, ok := root.Members[i].Value.Value.(*hujson.Object)
+	if !ok {
+		// Replace a null nixpkgs field.
+		nixpkgs = &hujson.Object{}
+		root.Members[i].Value.Value = nixpkgs
+	}
+
+	if j := c.memberIndex(nixpkgs, "commit"); j == -1 {
+		nixpkgs.Members = append(nixpkgs.Members, hujson.ObjectMember{
+			Name:  hujson.Value{Value: hujson.String("commit"), BeforeExtra: []byte{'\n'}},
+			Value: hujson.Value{Value: hujson.String(commit)},
+		})
+	} else {
+		nixpkgs.Members[j].Value.Value = hujson.String(commit)
+	}
+	c.root.Format()
+}
+
+// removePackageGroup removes a group from the package_groups field.
+func (c *configAST) removePackageGroup(name string) {
+	root, ok := c.root.Value.(*hujson.Object)
+	if !ok {
+		return
+	}
+	i := c.memberIndex(root, "package_groups")
+	if i == -1 {
+		return
+	}
+	groups, ok := root.Members[i].Value.Value.(*hujson.Object)
+	if !ok {
+		return
+	}
+	c.removePackageMember(groups, name)
+	c.root.Format()
+}
+
+// sortPackages sorts the packages field by package name. Comments stay with
+// the package they precede.
+func (c *configAST) sortPackages() {
+	switch val := c.packagesField(false).Value.Value.(type) {
+	case *hujson.Object:
+		if len(val.Members) == 0 {
+			return
+		}
+		// hujson emits a trailing comma when the last value has a non-nil
+		// AfterExtra, so keep the original last value's extra at the end.
+		trailing := val.Members[len(val.Members)-1].Value.AfterExtra
+		slices.SortStableFunc(val.Members, func(a, b hujson.ObjectMember) int {
+			return strings.Compare(
+				a.Name.Value.(hujson.Literal).String(),
+				b.Name.Value.(hujson.Literal).String(),
+			)
+		})
+		for i := range val.Members {
+			val.Members[i].Value.AfterExtra = nil
+		}
+		val.Members[len(val.Members)-1].Value.AfterExtra = trailing
+	case *hujson.Array:
+		if len(val.Elements) == 0 {
+			return
+		}
+		trailing := val.Elements[len(val.Elements)-1].AfterExtra
+		slices.SortStableFunc(val.Elements, func(a, b hujson.Value) int {
+			aName, _ := parseVersionedName(a.Value.(hujson.Literal).String())
+			bName, _ := parseVersionedName(b.Value.(hujson.Literal).String())
+			return strings.Compare(aName, bName)
+		})
+		for i := range val.Elements {
+			val.Elements[i].AfterExtra = nil
+		}
+		val.Elements[len(val.Elements)-1].AfterExtra = trailing
+	default:
+		panic("packages field must be an object or array")
+	}
+	c.root.Format()
+}
+
 func (c *configAST) removePackageMember(pkgs *hujson.Object, name string) {
 	i := c.memberIndex(pkgs, name)
 	if i == -1 {
@@ -192,6 +282,27 @@ func (c *configAST) setPackageBool(name, fieldName string, val bool) {
 	c.root.Format()
 }
 
+// setPackageInt sets an int field on a package.
+func (c *configAST) setPackageInt(name, fieldName string, val int) {
+	pkgObject := c.findPkgObject(name)
+	if pkgObject == nil {
+		return
+	}
+	if i := c.memberIndex(pkgObject, fieldName); i == -1 {
+		pkgObject.Members = append(pkgObject.Members, hujson.ObjectMember{
+			Name: hujson.Value{
+				Value:       hujson.String(fieldName),
+				BeforeExtra: []byte{'\n'},
+			},
+			Value: hujson.Value{Value: hujson.Int(int64(val))},
+		})
+	} else {
+		pkgObject.Members[i].Value.Value = hujson.Int(int64(val))
+	}
+
+	c.root.Format()
+}
+
 func (c *configAST) appendPlatforms(name, fieldName string, platforms []string) {
 	if len(platforms) == 0 {
 		return
@@ -17,6 +17,7 @@
 	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/cachehash"
 	"go.jetpack.io/devbox/internal/devbox/shellcmd"
+	"go.jetpack.io/devbox/nix/flake"
 )
 
 const (
@@ -36,6 +37,11 @@ type ConfigFile struct {
 	// its environment. Deliberately do not omitempty.
 	PackagesMutator PackagesMutator `json:"packages"`
 
+	// PackageGroups names lists of packages that can be added together with
+	// `devbox add @<group>`. A group can include other groups by listing
+	// them with an @ prefix.
+	PackageGroups map[string][]string `json:"package_groups,omitempty"`
+
 	// Env allows specifying env variables
 	Env map[string]string `json:"env,omitempty"`
 
@@ -62,10 +68,21 @@ type shellConfig struct {
 	// InitHook contains commands that will run at shell startup.
 	InitHook *shellcmd.Commands            `json:"init_hook,omitempty"`
 	Scripts  map[string]*shellcmd.Commands `json:"scripts,omitempty"`
+
+	// ShellenvHook contains commands that `devbox global shellenv` prints
+	// after the environment, so that they run whenever the global
+	// environment is loaded. Only the global config uses it.
+	ShellenvHook *shellcmd.Commands `json:"shellenv_hook,omitempty"`
 }
 
 type NixpkgsConfig struct {
 	Commit string `json:"commit,omitempty"`
+
+	// URL is a flake reference to the nixpkgs that packages without a
+	// version are installed from, such as a channel
+	// ("nixpkgs/nixos-unstable") or a branch or fork
+	// ("github:NixOS/nixpkgs/<ref>"). It takes precedence over Commit.
+	URL string `json:"url,omitempty"`
 }
 
 // Stage contains a subset of fields from plansdk.Stage
@@ -93,16 +110,44 @@ func (c *ConfigFile) Equals(other *ConfigFile) bool {
 	return hash1 == hash2
 }
 
-func (c *ConfigFile) NixPkgsCommitHash() string {
-	// The commit hash for nixpkgs-unstable on 2023-10-25 from status.nixos.org
-	const DefaultNixpkgsCommit = "75a52265bda7fd25e06e3a67dee3f0354e73243c"
+// DefaultNixpkgsCommit is the nixpkgs commit for configs that don't set one.
+// It's the commit hash for nixpkgs-unstable on 2023-10-25 from
+// status.nixos.org.
+const DefaultNixpkgsCommit = "75a52265bda7fd25e06e3a67dee3f0354e73243c"
 
+func (c *ConfigFile) NixPkgsCommitHash() string {
 	if c == nil || c.Nixpkgs == nil || c.Nixpkgs.Commit == "" {
 		return DefaultNixpkgsCommit
 	}
 	return c.Nixpkgs.Commit
 }
 
+// PinNixpkgsCommit sets nixpkgs.commit to [DefaultNixpkgsCommit] if the config
+// doesn't set a commit or URL. Pinning it keeps packages without a version
+// resolving to the same nixpkgs after an upgraded devbox changes the default.
+// It reports whether it changed the config.
+func (c *ConfigFile) PinNixpkgsCommit() bool {
+	if c.Nixpkgs != nil && (c.Nixpkgs.Commit != "" || c.Nixpkgs.URL != "") {
+		return false
+	}
+	if c.Nixpkgs == nil {
+		c.Nixpkgs = &NixpkgsConfig{}
+	}
+	c.Nixpkgs.Commit = DefaultNixpkgsCommit
+	c.ast.setNixpkgsCommit(DefaultNixpkgsCommit)
+	return true
+}
+
+// NixpkgsURL returns the flake reference of the nixpkgs that packages without
+// a version resolve to. It's the configured URL if there is one, or the
+// GitHub repository at NixPkgsCommitHash otherwise.
+func (c *ConfigFile) NixpkgsURL() string {
+	if c != nil && c.Nixpkgs != nil && c.Nixpkgs.URL != "" {
+		return c.Nixpkgs.URL
+	}
+	return "github:NixOS/nixpkgs/" + c.NixPkgsCommitHash()
+}
+
 func (c *ConfigFile) InitHook() *shellcmd.Commands {
 	if c == nil || c.Shell == nil || c.Shell.InitHook == nil {
 		return &shellcmd.Commands{}
@@ -110,6 +155,15 @@ func (c *ConfigFile) InitHook() *shellcmd.Commands {
 	return c.Shell.InitHook
 }
 
+// ShellenvHook returns the commands that `devbox global shellenv` prints after
+// the environment.
+func (c *ConfigFile) ShellenvHook() *shellcmd.Commands {
+	if c == nil || c.Shell == nil || c.Shell.ShellenvHook == nil {
+		return &shellcmd.Commands{}
+	}
+	return c.Shell.ShellenvHook
+}
+
 // SaveTo writes the config to a file.
 func (c *ConfigFile) SaveTo(path string) error {
 	return os.WriteFile(filepath.Join(path, DefaultName), c.Bytes(), 0o644)
@@ -204,5 +258,11 @@ func ValidateNixpkg(cfg *ConfigFile) error {
 			len(hash),
 		)
 	}
+	if cfg.Nixpkgs != nil && cfg.Nixpkgs.URL != "" {
+		if _, err := flake.ParseRef(cfg.Nixpkgs.URL); err != nil {
+			return usererr.WithUserMessage(err,
+				"Expected nixpkgs.url to be a flake reference, such as nixpkgs/nixos-unstable")
+		}
+	}
 	return nil
 }
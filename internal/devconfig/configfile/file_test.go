@@ -684,6 +684,35 @@ func TestSetAllowInsecure(t *testing.T) {
 	}
 }
 
+func TestSetPriority(t *testing.T) {
+	in, want := parseConfigTxtarTest(t, `
+-- in --
+{
+  "packages": ["go", "coreutils@latest"]
+}
+-- want --
+{
+  "packages": {
+    "go": "",
+    "coreutils": {
+      "version":  "latest",
+      "priority": 4
+    }
+  }
+}`)
+
+	err := in.PackagesMutator.SetPriority("coreutils@latest", 4)
+	if err != nil {
+		t.Error(err)
+	}
+	if diff := cmp.Diff(want, in.Bytes(), optParseHujson()); diff != "" {
+		t.Errorf("wrong parsed config json (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, in.Bytes()); diff != "" {
+		t.Errorf("wrong raw config hujson (-want +got):\n%s", diff)
+	}
+}
+
 func TestNixpkgsValidation(t *testing.T) {
 	testCases := map[string]struct {
 		commit   string
@@ -710,3 +739,156 @@ func TestNixpkgsValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestSortPackages(t *testing.T) {
+	in, want := parseConfigTxtarTest(t, `
+-- in --
+{
+  "packages": {
+    "ripgrep": "latest",
+    "go": "1.22",
+    "fd": {
+      "version": "latest"
+    }
+  }
+}
+-- want --
+{
+  "packages": {
+    "fd": {
+      "version": "latest"
+    },
+    "go":      "1.22",
+    "ripgrep": "latest"
+  }
+}`)
+
+	in.PackagesMutator.Sort()
+	if diff := cmp.Diff(want, in.Bytes(), optParseHujson()); diff != "" {
+		t.Errorf("wrong parsed config json (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, in.Bytes()); diff != "" {
+		t.Errorf("wrong raw config hujson (-want +got):\n%s", diff)
+	}
+}
+
+func TestSortPackagesArray(t *testing.T) {
+	in, want := parseConfigTxtarTest(t, `
+-- in --
+{
+  "packages": ["ripgrep@latest", "go@1.22", "fd"]
+}
+-- want --
+{
+  "packages": ["fd", "go@1.22", "ripgrep@latest"]
+}`)
+
+	in.PackagesMutator.Sort()
+	if diff := cmp.Diff(want, in.Bytes(), optParseHujson()); diff != "" {
+		t.Errorf("wrong parsed config json (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, in.Bytes()); diff != "" {
+		t.Errorf("wrong raw config hujson (-want +got):\n%s", diff)
+	}
+}
+
+func TestNixpkgsURL(t *testing.T) {
+	const commit = "5233fd2ba76a3accb5aaa999c00509a11fd0793c"
+	tests := map[string]string{
+		`{}`: "github:NixOS/nixpkgs/75a52265bda7fd25e06e3a67dee3f0354e73243c",
+		`{"nixpkgs": {"commit": "` + commit + `"}}`:      "github:NixOS/nixpkgs/" + commit,
+		`{"nixpkgs": {"url": "nixpkgs/nixos-unstable"}}`: "nixpkgs/nixos-unstable",
+		`{"nixpkgs": {"url": "github:me/nixpkgs/x"}}`:    "github:me/nixpkgs/x",
+	}
+	for config, want := range tests {
+		cfg, err := LoadBytes([]byte(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, cfg.NixpkgsURL(), "config %s", config)
+	}
+
+	_, err := LoadBytes([]byte(`{"nixpkgs": {"url": "nixpkgs#hello"}}`))
+	assert.Error(t, err, "nixpkgs.url with a fragment should be invalid")
+}
+
+func TestPinNixpkgsCommit(t *testing.T) {
+	const commit = "5233fd2ba76a3accb5aaa999c00509a11fd0793c"
+	tests := []struct {
+		config     string
+		wantPinned bool
+		wantCommit string
+	}{
+		{`{}`, true, DefaultNixpkgsCommit},
+		{`{"nixpkgs": {}}`, true, DefaultNixpkgsCommit},
+		{`{"nixpkgs": {"commit": ""}}`, true, DefaultNixpkgsCommit},
+		{`{"nixpkgs": null}`, true, DefaultNixpkgsCommit},
+		{`{"nixpkgs": {"commit": "` + commit + `"}}`, false, commit},
+		{`{"nixpkgs": {"url": "nixpkgs/nixos-unstable"}}`, false, ""},
+	}
+	for _, test := range tests {
+		cfg, err := LoadBytes([]byte(test.config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, test.wantPinned, cfg.PinNixpkgsCommit(), "config %s", test.config)
+
+		// The pinned commit must survive saving and reloading the config.
+		saved, err := LoadBytes(cfg.Bytes())
+		if err != nil {
+			t.Fatalf("reload config %s: %v\n%s", test.config, err, cfg.Bytes())
+		}
+		got := ""
+		if saved.Nixpkgs != nil {
+			got = saved.Nixpkgs.Commit
+		}
+		assert.Equal(t, test.wantCommit, got, "config %s saved as:\n%s", test.config, cfg.Bytes())
+	}
+}
+
+func TestShellenvHook(t *testing.T) {
+	tests := map[string][]string{
+		`{}`:                                    nil,
+		`{"shell": {"init_hook": "echo init"}}`: nil,
+		`{"shell": {"shellenv_hook": "echo hi"}}`: {"echo hi"},
+		`{"shell": {"shellenv_hook": ["alias ll='ls -l'", "source ~/.completions"]}}`: {
+			"alias ll='ls -l'", "source ~/.completions",
+		},
+	}
+	for config, want := range tests {
+		cfg, err := LoadBytes([]byte(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, cfg.ShellenvHook().Cmds, "config %s", config)
+	}
+}
+
+func TestSetPriorityZero(t *testing.T) {
+	in, want := parseConfigTxtarTest(t, `
+-- in --
+{
+  "packages": ["go", "coreutils@latest"]
+}
+-- want --
+{
+  "packages": {
+    "go": "",
+    "coreutils": {
+      "version":  "latest",
+      "priority": 0
+    }
+  }
+}`)
+
+	err := in.PackagesMutator.SetPriority("coreutils@latest", 0)
+	if err != nil {
+		t.Error(err)
+	}
+	if diff := cmp.Diff(want, in.Bytes(), optParseHujson()); diff != "" {
+		t.Errorf("wrong parsed config json (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, in.Bytes()); diff != "" {
+		t.Errorf("wrong raw config hujson (-want +got):\n%s", diff)
+	}
+}
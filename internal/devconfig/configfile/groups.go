@@ -0,0 +1,124 @@
+package configfile
+
+import (
+	"slices"
+	"strings"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+)
+
+// packageGroupPrefix marks a package name as a reference to a package group.
+const packageGroupPrefix = "@"
+
+// ExpandPackageGroups replaces the references to package groups in names,
+// such as @rust, with the packages in those groups. Groups that include other
+// groups are expanded recursively. The result keeps the order of names and
+// leaves out duplicates.
+func (c *ConfigFile) ExpandPackageGroups(names []string) ([]string, error) {
+	var expanded []string
+	for _, name := range names {
+		pkgs, err := c.expandPackageGroup(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range pkgs {
+			if !slices.Contains(expanded, pkg) {
+				expanded = append(expanded, pkg)
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// expandPackageGroup expands name if it's a group reference. path is the
+// chain of groups being expanded, for detecting cycles.
+func (c *ConfigFile) expandPackageGroup(name string, path []string) ([]string, error) {
+	group, ok := strings.CutPrefix(name, packageGroupPrefix)
+	if !ok {
+		return []string{name}, nil
+	}
+	if slices.Contains(path, group) {
+		cycle := append(slices.Clip(path), group)
+		return nil, usererr.New(
+			"Package group %q includes itself: %s",
+			group,
+			packageGroupPrefix+strings.Join(cycle, " -> "+packageGroupPrefix),
+		)
+	}
+	members, ok := c.PackageGroups[group]
+	if !ok {
+		return nil, usererr.New(
+			"Package group %q is not defined. Add it to \"package_groups\" in %s.",
+			group,
+			DefaultName,
+		)
+	}
+
+	var pkgs []string
+	for _, member := range members {
+		expanded, err := c.expandPackageGroup(member, append(slices.Clip(path), group))
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, expanded...)
+	}
+	return pkgs, nil
+}
+
+// PackageGroupsContaining returns the sorted names of the groups that include
+// pkg, either directly or through another group. pkg is matched against the
+// group members as written, or with @latest for members without a version.
+func (c *ConfigFile) PackageGroupsContaining(pkg string) []string {
+	var groups []string
+	for group := range c.PackageGroups {
+		members, err := c.expandPackageGroup(packageGroupPrefix+group, nil)
+		if err != nil {
+			continue
+		}
+		if slices.ContainsFunc(members, func(member string) bool {
+			return member == pkg || (!strings.Contains(member, "@") && member+"@latest" == pkg)
+		}) {
+			groups = append(groups, group)
+		}
+	}
+	slices.Sort(groups)
+	return groups
+}
+
+// PrunePackageGroups deletes groups from "package_groups". A group that's
+// included by another group that isn't being deleted is kept, since removing
+// it would break the including group. It returns the sorted names of the
+// groups that were kept.
+func (c *ConfigFile) PrunePackageGroups(groups []string) (kept []string) {
+	prune := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		if _, ok := c.PackageGroups[group]; ok {
+			prune[group] = true
+		}
+	}
+
+	// Keeping a group can make a group that it includes unprunable, so
+	// repeat until nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for group := range prune {
+			for includer, members := range c.PackageGroups {
+				if !prune[includer] && slices.Contains(members, packageGroupPrefix+group) {
+					delete(prune, group)
+					kept = append(kept, group)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	for group := range prune {
+		delete(c.PackageGroups, group)
+		if c.ast != nil {
+			c.ast.removePackageGroup(group)
+		}
+	}
+	slices.Sort(kept)
+	return kept
+}
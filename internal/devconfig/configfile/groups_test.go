@@ -0,0 +1,92 @@
+package configfile
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExpandPackageGroups(t *testing.T) {
+	cfg := &ConfigFile{PackageGroups: map[string][]string{
+		"rust":    {"rustc", "cargo", "rustfmt", "clippy"},
+		"web":     {"nodejs@20", "@tooling"},
+		"tooling": {"jq", "ripgrep"},
+		"cycle-a": {"hello", "@cycle-b"},
+		"cycle-b": {"@cycle-a"},
+	}}
+
+	got, err := cfg.ExpandPackageGroups([]string{"go@1.22", "@rust", "jq", "@web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"go@1.22", "rustc", "cargo", "rustfmt", "clippy", "jq", "nodejs@20", "ripgrep"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := cfg.ExpandPackageGroups([]string{"@cycle-a"}); err == nil {
+		t.Error("got nil error for a group cycle")
+	}
+	if _, err := cfg.ExpandPackageGroups([]string{"@missing"}); err == nil {
+		t.Error("got nil error for an undefined group")
+	}
+}
+
+func TestPackageGroupsContaining(t *testing.T) {
+	cfg := &ConfigFile{PackageGroups: map[string][]string{
+		"shell": {"jq", "ripgrep@14"},
+		"web":   {"nodejs@20", "@shell"},
+	}}
+	tests := map[string][]string{
+		"jq@latest":    {"shell", "web"},
+		"ripgrep@14":   {"shell", "web"},
+		"ripgrep@15":   nil,
+		"nodejs@20":    {"web"},
+		"hello@latest": nil,
+	}
+	for pkg, want := range tests {
+		if got := cfg.PackageGroupsContaining(pkg); !slices.Equal(got, want) {
+			t.Errorf("PackageGroupsContaining(%q) = %v, want %v", pkg, got, want)
+		}
+	}
+}
+
+func TestPrunePackageGroups(t *testing.T) {
+	cfg, err := LoadBytes([]byte(`{
+  "packages": [],
+  "package_groups": {
+    // Shell utilities.
+    "shell": ["jq", "ripgrep"],
+    "web":   ["nodejs@20", "@shell"],
+    "rust":  ["rustc", "cargo"]
+  }
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept := cfg.PrunePackageGroups([]string{"shell", "rust", "missing"})
+	if want := []string{"shell"}; !slices.Equal(kept, want) {
+		t.Errorf("got kept groups %v, want %v", kept, want)
+	}
+	if _, ok := cfg.PackageGroups["rust"]; ok {
+		t.Error("rust group wasn't pruned")
+	}
+	if _, ok := cfg.PackageGroups["shell"]; !ok {
+		t.Error("shell group was pruned while web still includes it")
+	}
+	if strings.Contains(string(cfg.Bytes()), `"rust"`) {
+		t.Errorf("rust group is still in the config:\n%s", cfg.Bytes())
+	}
+	if !strings.Contains(string(cfg.Bytes()), "// Shell utilities.") {
+		t.Errorf("pruning lost a comment:\n%s", cfg.Bytes())
+	}
+
+	// Pruning the including group too frees the included one.
+	if kept := cfg.PrunePackageGroups([]string{"shell", "web"}); len(kept) != 0 {
+		t.Errorf("got kept groups %v, want none", kept)
+	}
+	if len(cfg.PackageGroups) != 0 {
+		t.Errorf("got groups %v after pruning all of them", cfg.PackageGroups)
+	}
+}
@@ -44,6 +44,19 @@ func (pkgs *PackagesMutator) Remove(versionedName string) {
 	pkgs.ast.removePackage(name)
 }
 
+// Sort sorts the packages by name. Sorted packages produce stable diffs when a
+// config is edited on multiple machines, but note that package order also
+// determines which package wins when two provide the same binary.
+func (pkgs *PackagesMutator) Sort() {
+	if len(pkgs.collection) == 0 {
+		return
+	}
+	slices.SortStableFunc(pkgs.collection, func(a, b Package) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	pkgs.ast.sortPackages()
+}
+
 // AddPlatforms adds a platform to the list of platforms for a given package
 func (pkgs *PackagesMutator) AddPlatforms(writer io.Writer, versionedname string, platforms []string) error {
 	if len(platforms) == 0 {
@@ -235,6 +248,23 @@ func (pkgs *PackagesMutator) SetAllowInsecure(writer io.Writer, versionedName st
 	return nil
 }
 
+func (pkgs *PackagesMutator) SetPriority(versionedName string, priority int) error {
+	if priority < 0 {
+		return fmt.Errorf("set priority field for %s: priority must not be negative", versionedName)
+	}
+
+	name, version := parseVersionedName(versionedName)
+	i := pkgs.index(name, version)
+	if i == -1 {
+		return errors.Errorf("package %s not found", versionedName)
+	}
+	if p := pkgs.collection[i].Priority; p == nil || *p != priority {
+		pkgs.collection[i].Priority = &priority
+		pkgs.ast.setPackageInt(name, "priority", priority)
+	}
+	return nil
+}
+
 func (pkgs *PackagesMutator) index(name, version string) int {
 	return slices.IndexFunc(pkgs.collection, func(p Package) bool {
 		return p.Name == name && p.Version == version
@@ -294,6 +324,21 @@ type Package struct {
 	// AllowInsecure is a whitelist of packages that may be marked insecure
 	// in nixpkgs, but are allowed by the user to be installed.
 	AllowInsecure []string `json:"allow_insecure,omitempty"`
+
+	// Priority is the nix profile priority to install the package with.
+	// Lower values take precedence when two packages provide the same file.
+	// If unset, Devbox picks a priority lower than any existing package.
+	Priority *int `json:"priority,omitempty"`
+
+	// PostInstall is a shell command to run after the package is installed
+	// into the global profile, such as to rebuild a font cache. It is only
+	// used by the global config.
+	PostInstall string `json:"post_install,omitempty"`
+
+	// Env is a set of environment variables that the global shellenv
+	// exports while the package is in the global config, such as EDITOR
+	// for an editor. It is only used by the global config.
+	Env map[string]string `json:"env,omitempty"`
 }
 
 func NewVersionOnlyPackage(name, version string) Package {
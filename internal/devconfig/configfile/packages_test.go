@@ -204,6 +204,34 @@ func TestJsonifyConfigPackages(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "map-with-post-install",
+			jsonConfig: `{"packages":{"fontconfig":{"version":"latest",` +
+				`"post_install":"fc-cache -f"}}}`,
+			expected: PackagesMutator{
+				collection: []Package{
+					{
+						Name:        "fontconfig",
+						Version:     "latest",
+						PostInstall: "fc-cache -f",
+					},
+				},
+			},
+		},
+		{
+			name: "map-with-env",
+			jsonConfig: `{"packages":{"neovim":{"version":"latest",` +
+				`"env":{"EDITOR":"nvim"}}}}`,
+			expected: PackagesMutator{
+				collection: []Package{
+					{
+						Name:    "neovim",
+						Version: "latest",
+						Env:     map[string]string{"EDITOR": "nvim"},
+					},
+				},
+			},
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -15,6 +15,7 @@
 	"github.com/pkg/errors"
 	"go.jetpack.io/devbox/internal/debug"
 	"go.jetpack.io/devbox/internal/devbox/providers/nixcache"
+	"go.jetpack.io/devbox/internal/envir"
 	"go.jetpack.io/devbox/internal/goutil"
 	"go.jetpack.io/devbox/internal/lock"
 	"go.jetpack.io/devbox/internal/nix"
@@ -132,13 +133,16 @@ func (p *Package) fetchNarInfoStatusOnce(
 ) (map[string]string, error) {
 	ctx := context.TODO()
 
-	outputToCache := map[string]string{}
-	caches, err := readCaches(ctx)
+	outputs, err := p.outputsForOutputName(outputName)
 	if err != nil {
 		return nil, err
 	}
+	if envir.IsOffline() {
+		return localOutputs(ctx, outputs)
+	}
 
-	outputs, err := p.outputsForOutputName(outputName)
+	outputToCache := map[string]string{}
+	caches, err := readCaches(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -170,6 +174,28 @@ func (p *Package) fetchNarInfoStatusOnce(
 	return outputToCache, nil
 }
 
+// localOutputs is the offline counterpart to the narinfo lookups. It reports
+// the outputs that are already in the local store as cached, since
+// builtins.fetchClosure doesn't download paths that are valid locally.
+func localOutputs(ctx context.Context, outputs []lock.Output) (map[string]string, error) {
+	paths := make([]string, len(outputs))
+	for i, output := range outputs {
+		paths[i] = output.Path
+	}
+	inStore, err := nix.StorePathsAreInStore(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	outputToCache := map[string]string{}
+	for _, output := range outputs {
+		if inStore[output.Path] {
+			outputToCache[output.Name] = binaryCache
+		}
+	}
+	return outputToCache, nil
+}
+
 func (p *Package) AreAllOutputsInCache(
 	ctx context.Context, w io.Writer, cacheURI string,
 ) (bool, error) {
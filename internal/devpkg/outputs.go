@@ -1,5 +1,12 @@
 package devpkg
 
+import (
+	"strings"
+
+	"go.jetpack.io/devbox/internal/devpkg/pkgtype"
+	"go.jetpack.io/devbox/nix/flake"
+)
+
 type Output struct {
 	Name     string
 	CacheURI string
@@ -45,3 +52,19 @@ func (out *outputs) initDefaultNames(pkg *Package) error {
 	}
 	return nil
 }
+
+// SplitOutputs splits a Devbox package string that selects outputs, such as
+// glibc^bin,dev, into the package string and the output names. Flake
+// installables keep their outputs in the installable, so they're returned
+// unchanged.
+func SplitOutputs(raw string) (name string, outputs []string) {
+	parsed, err := flake.ParseInstallable(raw)
+	if err == nil && !pkgtype.IsAmbiguous(raw, parsed) {
+		return raw, nil
+	}
+	name, selected, ok := strings.Cut(raw, "^")
+	if !ok || selected == "" {
+		return name, nil
+	}
+	return name, strings.Split(selected, ",")
+}
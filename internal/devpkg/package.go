@@ -91,6 +91,18 @@ type Package struct {
 	// installed even if they are marked as insecure.
 	AllowInsecure []string
 
+	// Priority is the nix profile priority of the package. Nil means
+	// Devbox chooses one.
+	Priority *int
+
+	// PostInstall is a shell command to run after installing the package to
+	// the global profile.
+	PostInstall string
+
+	// Env is the environment variables to set when the package is in the
+	// global profile.
+	Env map[string]string
+
 	// isInstallable is true if the package may be enabled on the current platform.
 	// It's a function to allow deferring nix System call until it's needed.
 	isInstallable func() bool
@@ -114,6 +126,9 @@ func PackagesFromConfig(packages []configfile.Package, l lock.Locker) []*Package
 		pkg.Patch = pkgNeedsPatch(pkg.CanonicalName(), cfgPkg.Patch)
 		pkg.outputs.selectedNames = lo.Uniq(append(pkg.outputs.selectedNames, cfgPkg.Outputs...))
 		pkg.AllowInsecure = cfgPkg.AllowInsecure
+		pkg.Priority = cfgPkg.Priority
+		pkg.PostInstall = cfgPkg.PostInstall
+		pkg.Env = cfgPkg.Env
 		result = append(result, pkg)
 	}
 	return result
@@ -129,6 +144,7 @@ func PackageFromStringWithOptions(raw string, locker lock.Locker, opts devopt.Ad
 	pkg.Patch = pkgNeedsPatch(pkg.CanonicalName(), configfile.PatchMode(opts.Patch))
 	pkg.outputs.selectedNames = lo.Uniq(append(pkg.outputs.selectedNames, opts.Outputs...))
 	pkg.AllowInsecure = opts.AllowInsecure
+	pkg.Priority = opts.Priority
 	return pkg
 }
 
@@ -508,6 +524,35 @@ func (p *Package) Versioned() string {
 	return p.Raw
 }
 
+// NormalizeRaw returns a form of the package string raw that's the same for
+// equivalent references to a package, so that lists of packages can be
+// deduplicated and compared without treating them as different packages:
+//
+//   - A Devbox package without a version gets the @latest version, which is
+//     what it resolves to.
+//   - A flake installable is normalized with [flake.Installable.String], which
+//     removes differences such as trailing slashes and the order of outputs.
+//   - An attribute of the nixpkgs flake registry entry without a revision,
+//     such as nixpkgs#ripgrep, is the same as the Devbox package ripgrep.
+//
+// Nix attribute names are case-sensitive, so the case of raw is kept.
+func NormalizeRaw(raw string) string {
+	raw = strings.TrimSpace(raw)
+	parsed, err := flake.ParseInstallable(raw)
+	if err != nil || pkgtype.IsAmbiguous(raw, parsed) {
+		if !strings.Contains(raw, "@") {
+			return raw + "@latest"
+		}
+		return raw
+	}
+	ref := parsed.Ref
+	if ref.Type == flake.TypeIndirect && ref.ID == "nixpkgs" && ref.Rev == "" && ref.Ref == "" &&
+		parsed.AttrPath != "" && parsed.Outputs == "" {
+		return parsed.AttrPath + "@latest"
+	}
+	return parsed.String()
+}
+
 func (p *Package) IsLegacy() bool {
 	return p.IsDevboxPackage && !p.isVersioned() && p.lockfile.Get(p.Raw).GetSource() == ""
 }
@@ -828,7 +873,7 @@ func packageInstallErrorHandler(err error, pkg *Package, installableOrEmpty stri
 		)
 	}
 
-	if isInsecureErr, userErr := nix.IsExitErrorInsecurePackage(err, pkg.Versioned(), installableOrEmpty); isInsecureErr {
+	if isInsecureErr, userErr := nix.IsExitErrorInsecurePackage(err, "devbox add", pkg.Versioned(), installableOrEmpty); isInsecureErr {
 		return userErr
 	}
 
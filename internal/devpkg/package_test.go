@@ -6,6 +6,7 @@
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -206,3 +207,58 @@ func TestCanonicalName(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitOutputs(t *testing.T) {
+	tests := []struct {
+		raw         string
+		wantName    string
+		wantOutputs []string
+	}{
+		{"glibc", "glibc", nil},
+		{"glibc^bin", "glibc", []string{"bin"}},
+		{"glibc@2.38^bin,dev", "glibc@2.38", []string{"bin", "dev"}},
+		{"glibc^", "glibc", nil},
+		{"nixpkgs#glibc^bin", "nixpkgs#glibc^bin", nil},
+		{"github:NixOS/nixpkgs/12345#glibc^dev", "github:NixOS/nixpkgs/12345#glibc^dev", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			name, outputs := SplitOutputs(tt.raw)
+			if name != tt.wantName {
+				t.Errorf("Got name %q, want %q", name, tt.wantName)
+			}
+			if !slices.Equal(outputs, tt.wantOutputs) {
+				t.Errorf("Got outputs %q, want %q", outputs, tt.wantOutputs)
+			}
+		})
+	}
+}
+
+func TestNormalizeRaw(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"ripgrep", "nixpkgs#ripgrep", true},
+		{"ripgrep", "flake:nixpkgs#ripgrep", true},
+		{"ripgrep", "ripgrep@latest", true},
+		{" ripgrep ", "ripgrep", true},
+		{"path:./flake/#hello", "./flake#hello", true},
+		{"github:NixOS/nixpkgs/#hello", "github:NixOS/nixpkgs#hello", true},
+		{"nixpkgs#glibc^dev,bin", "nixpkgs#glibc^bin,dev", true},
+		{"ripgrep", "Ripgrep", false},
+		{"ripgrep", "ripgrep@14", false},
+		{"hello", "github:NixOS/nixpkgs/12345#hello", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			a, b := NormalizeRaw(tt.a), NormalizeRaw(tt.b)
+			if (a == b) != tt.same {
+				t.Errorf("Got NormalizeRaw(%q) = %q and NormalizeRaw(%q) = %q, want equal = %v",
+					tt.a, a, tt.b, b, tt.same)
+			}
+		})
+	}
+}
@@ -2,9 +2,14 @@
 
 import (
 	"context"
+	"slices"
 	"strings"
 
+	"github.com/samber/lo"
+
 	"go.jetpack.io/devbox/internal/boxcli/usererr"
+	"go.jetpack.io/devbox/internal/envir"
+	"go.jetpack.io/devbox/internal/lock"
 	"go.jetpack.io/devbox/internal/nix"
 )
 
@@ -24,11 +29,43 @@ func (p *Package) ValidateExists(ctx context.Context) (bool, error) {
 	if inCache {
 		return true, nil
 	}
+	if envir.IsOffline() && p.isVersioned() {
+		// Offline, "in the binary cache" means in the local store, so there's
+		// nowhere else to get the package from.
+		return false, usererr.New(
+			"Package %q is not available offline because it isn't in the local nix store.",
+			p.Raw,
+		)
+	}
 
 	info, err := p.NormalizedPackageAttributePath()
 	return info != "", err
 }
 
+// ValidateOutputs checks that the package has each of its selected outputs.
+// Only versioned packages list their outputs in devbox.lock, so other packages
+// are left for nix to check when it installs them.
+func (p *Package) ValidateOutputs() error {
+	selected := lo.Compact(p.outputs.selectedNames)
+	if len(selected) == 0 {
+		return nil
+	}
+	sysInfo, err := p.sysInfoIfExists()
+	if err != nil || sysInfo == nil || len(sysInfo.Outputs) == 0 {
+		return err
+	}
+	available := lo.Map(sysInfo.Outputs, func(o lock.Output, _ int) string { return o.Name })
+	for _, name := range selected {
+		if !slices.Contains(available, name) {
+			return usererr.New(
+				"Package %q doesn't have an output named %q. Its outputs are: %s",
+				p.Raw, name, strings.Join(available, ", "),
+			)
+		}
+	}
+	return nil
+}
+
 func (p *Package) ValidateInstallsOnSystem() (bool, error) {
 	u, err := p.urlForInstall()
 	if err != nil {
@@ -6,14 +6,35 @@
 const (
 	DevboxCache   = "DEVBOX_CACHE"
 	DevboxGateway = "DEVBOX_GATEWAY"
+	// DevboxGlobalDataDir overrides the directory that holds the global
+	// profiles, which is $XDG_DATA_HOME/devbox/global by default.
+	DevboxGlobalDataDir = "DEVBOX_GLOBAL_DATA_DIR"
+	// DevboxGlobalCurrentLink overrides the name of the symlink in the global
+	// data directory that points to the active profile. It defaults to
+	// "current".
+	DevboxGlobalCurrentLink = "DEVBOX_GLOBAL_CURRENT_LINK"
+	// DevboxGlobalSortPackages controls whether the global devbox.json keeps
+	// its packages sorted by name. It defaults to true.
+	DevboxGlobalSortPackages = "DEVBOX_GLOBAL_SORT_PACKAGES"
+	// DevboxGlobalRmRequireConfirm makes `devbox global rm` fail instead of
+	// removing many packages when it can't prompt for confirmation, such as
+	// when stdin isn't a terminal.
+	DevboxGlobalRmRequireConfirm = "DEVBOX_GLOBAL_RM_REQUIRE_CONFIRM"
 	// DevboxLatestVersion is the latest version available of the devbox CLI binary.
 	// NOTE: it should NOT start with v (like 0.4.8)
-	DevboxLatestVersion  = "DEVBOX_LATEST_VERSION"
-	DevboxRegion         = "DEVBOX_REGION"
-	DevboxSearchHost     = "DEVBOX_SEARCH_HOST"
-	DevboxShellEnabled   = "DEVBOX_SHELL_ENABLED"
-	DevboxShellStartTime = "DEVBOX_SHELL_START_TIME"
-	DevboxVM             = "DEVBOX_VM"
+	DevboxLatestVersion = "DEVBOX_LATEST_VERSION"
+	// DevboxOffline makes devbox rely only on devbox.lock and the local nix
+	// store instead of the search API and binary caches.
+	DevboxOffline = "DEVBOX_OFFLINE"
+	// DevboxPatchSearchCache is a directory where `devbox patch` caches the
+	// results of searching store paths for removed references. There's no
+	// cache if it's unset.
+	DevboxPatchSearchCache = "DEVBOX_PATCH_SEARCH_CACHE"
+	DevboxRegion           = "DEVBOX_REGION"
+	DevboxSearchHost       = "DEVBOX_SEARCH_HOST"
+	DevboxShellEnabled     = "DEVBOX_SHELL_ENABLED"
+	DevboxShellStartTime   = "DEVBOX_SHELL_START_TIME"
+	DevboxVM               = "DEVBOX_VM"
 
 	LauncherVersion = "LAUNCHER_VERSION"
 	LauncherPath    = "LAUNCHER_PATH"
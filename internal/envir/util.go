@@ -35,6 +35,29 @@ func IsCI() bool {
 	return ci && err == nil
 }
 
+// IsOffline reports whether devbox should avoid the network. It's set with
+// DEVBOX_OFFLINE=1 or the --offline flag.
+func IsOffline() bool {
+	offline, _ := strconv.ParseBool(os.Getenv(DevboxOffline))
+	return offline
+}
+
+// SortGlobalPackages reports whether the global config should keep its
+// packages sorted by name. Users who care about install order can opt out by
+// setting DEVBOX_GLOBAL_SORT_PACKAGES=0.
+func SortGlobalPackages() bool {
+	sortPkgs, err := strconv.ParseBool(os.Getenv(DevboxGlobalSortPackages))
+	return sortPkgs || err != nil
+}
+
+// RequireGlobalRemoveConfirm reports whether `devbox global rm` must get
+// confirmation before removing many packages, even when it can't prompt. It's
+// set with DEVBOX_GLOBAL_RM_REQUIRE_CONFIRM=1.
+func RequireGlobalRemoveConfirm() bool {
+	require, _ := strconv.ParseBool(os.Getenv(DevboxGlobalRmRequireConfirm))
+	return require
+}
+
 // GetValueOrDefault gets the value of an environment variable.
 // If it's empty, it will return the given default value instead.
 func GetValueOrDefault(key, def string) string {
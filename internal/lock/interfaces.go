@@ -6,6 +6,7 @@
 type devboxProject interface {
 	ConfigHash() (string, error)
 	NixPkgsCommitHash() string
+	NixpkgsURL() string
 	AllPackageNamesIncludingRemovedTriggerPackages() []string
 	ProjectDir() string
 }
@@ -12,10 +12,12 @@
 
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/cachehash"
 	"go.jetpack.io/devbox/internal/devpkg/pkgtype"
 	"go.jetpack.io/devbox/internal/nix"
 	"go.jetpack.io/devbox/internal/searcher"
+	"go.jetpack.io/devbox/nix/flake"
 	"go.jetpack.io/pkg/runx/impl/types"
 
 	"go.jetpack.io/devbox/internal/cuecfg"
@@ -86,8 +88,12 @@ func (f *File) Resolve(pkg string) (*Package, error) {
 		} else if IsLegacyPackage(pkg) {
 			// These are legacy packages without a version. Resolve to nixpkgs with
 			// whatever hash is in the devbox.json
+			resolved, err := f.lockedLegacyNixpkgsPath(pkg)
+			if err != nil {
+				return nil, err
+			}
 			locked = &Package{
-				Resolved: f.LegacyNixpkgsPath(pkg),
+				Resolved: resolved,
 				Source:   nixpkgSource,
 			}
 		}
@@ -134,11 +140,27 @@ func (f *File) Save() error {
 }
 
 func (f *File) LegacyNixpkgsPath(pkg string) string {
-	return fmt.Sprintf(
-		"github:NixOS/nixpkgs/%s#%s",
-		f.NixPkgsCommitHash(),
-		pkg,
-	)
+	return fmt.Sprintf("%s#%s", f.NixpkgsURL(), pkg)
+}
+
+// lockedLegacyNixpkgsPath is like LegacyNixpkgsPath, but it pins the nixpkgs
+// flake reference to a revision so that devbox.lock records exactly what was
+// installed. A nixpkgs URL without a revision, such as a channel, is
+// validated and locked with nix.
+func (f *File) lockedLegacyNixpkgsPath(pkg string) (string, error) {
+	url := f.NixpkgsURL()
+	ref, err := flake.ParseRef(url)
+	if err != nil {
+		return "", usererr.WithUserMessage(err, "Invalid nixpkgs URL %q in devbox.json", url)
+	}
+	if ref.Rev == "" {
+		url, err = nix.LockedFlakeURL(context.TODO(), url)
+		if err != nil {
+			return "", usererr.WithUserMessage(
+				err, "Failed to resolve nixpkgs URL %q in devbox.json", f.NixpkgsURL())
+		}
+	}
+	return fmt.Sprintf("%s#%s", url, pkg), nil
 }
 
 func (f *File) Get(pkg string) *Package {
@@ -15,6 +15,7 @@
 	"go.jetpack.io/devbox/internal/boxcli/featureflag"
 	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/devpkg/pkgtype"
+	"go.jetpack.io/devbox/internal/envir"
 	"go.jetpack.io/devbox/internal/nix"
 	"go.jetpack.io/devbox/internal/redact"
 	"go.jetpack.io/devbox/internal/searcher"
@@ -37,6 +38,14 @@ func (f *File) FetchResolvedPackage(pkg string) (*Package, error) {
 		return nil, usererr.New("No version specified for %q.", name)
 	}
 
+	if envir.IsOffline() {
+		return nil, usererr.New(
+			"Package %q is not available offline because it isn't pinned in devbox.lock. "+
+				"Reconnect to the network and try again.",
+			pkg,
+		)
+	}
+
 	if pkgtype.IsRunX(pkg) {
 		ref, err := ResolveRunXPackage(context.TODO(), pkg)
 		if err != nil {
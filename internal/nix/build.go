@@ -16,6 +16,15 @@ type BuildArgs struct {
 	ExtraSubstituters []string
 	Flags             []string
 	Writer            io.Writer
+
+	// OnBuildFromSource, if set, is called with the name of each derivation
+	// that nix has to build locally because no binary cache has it. Setting
+	// it means that nix no longer writes directly to a terminal, so it prints
+	// plain log lines instead of its progress bar.
+	OnBuildFromSource func(drvName string)
+
+	// Verbose makes nix print more of its own output, including build logs.
+	Verbose bool
 }
 
 func Build(ctx context.Context, args *BuildArgs, installables ...string) error {
@@ -23,6 +32,9 @@ func Build(ctx context.Context, args *BuildArgs, installables ...string) error {
 	// --impure is required for allowUnfreeEnv/allowInsecureEnv to work.
 	cmd := command("build", "--impure")
 	cmd.Args = appendArgs(cmd.Args, args.Flags)
+	if args.Verbose {
+		cmd.Args = appendArgs(cmd.Args, verboseFlags)
+	}
 	cmd.Args = appendArgs(cmd.Args, installables)
 	// Adding extra substituters only here to be conservative, but this could also
 	// be added to ExperimentalFlags() in the future.
@@ -44,5 +56,104 @@ func Build(ctx context.Context, args *BuildArgs, installables ...string) error {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = args.Writer
 	cmd.Stderr = args.Writer
+	if args.OnBuildFromSource != nil {
+		w := &buildLogWriter{w: args.Writer, onBuild: args.OnBuildFromSource}
+		defer w.Flush()
+		cmd.Stderr = w
+	}
 	return cmd.Run(ctx)
 }
+
+// DryRunBuild evaluates installables like [Build] would, but doesn't build or
+// fetch anything. Build writes nix's output straight to args.Writer, so a
+// failed Build returns an error without it. DryRunBuild captures it in the
+// returned error instead, which lets callers find out why the build failed.
+func DryRunBuild(ctx context.Context, args *BuildArgs, installables ...string) error {
+	defer debug.FunctionTimer().End()
+	cmd := command("build", "--impure", "--dry-run", "--no-link")
+	cmd.Args = appendArgs(cmd.Args, installables)
+	cmd.Env = append(allowUnfreeEnv(os.Environ()), args.Env...)
+	if args.AllowInsecure {
+		cmd.Env = allowInsecureEnv(cmd.Env)
+	}
+	_, err := cmd.Output(ctx)
+	return err
+}
+
+// buildLogWriter passes nix build output through to another writer while
+// watching it for derivations that are going to be built from source. Nix
+// lists them before it starts fetching anything:
+//
+//	these 2 derivations will be built:
+//	  /nix/store/<hash>-foo-1.0.drv
+//	  /nix/store/<hash>-bar-2.0.drv
+//
+// and later logs "building '/nix/store/<hash>-foo-1.0.drv'..." as each build
+// starts.
+type buildLogWriter struct {
+	w       io.Writer
+	onBuild func(drvName string)
+
+	line   []byte
+	inPlan bool
+	seen   map[string]bool
+}
+
+func (b *buildLogWriter) Write(p []byte) (int, error) {
+	n, err := b.w.Write(p)
+	for _, c := range p[:n] {
+		if c != '\n' {
+			b.line = append(b.line, c)
+			continue
+		}
+		b.scanLine(string(b.line))
+		b.line = b.line[:0]
+	}
+	return n, err
+}
+
+// Flush scans any remaining output that didn't end with a newline.
+func (b *buildLogWriter) Flush() {
+	if len(b.line) > 0 {
+		b.scanLine(string(b.line))
+		b.line = b.line[:0]
+	}
+}
+
+func (b *buildLogWriter) scanLine(line string) {
+	if b.inPlan {
+		if drv, ok := strings.CutPrefix(line, "  "); ok {
+			b.found(strings.TrimSpace(drv))
+			return
+		}
+		b.inPlan = false
+	}
+	if strings.HasSuffix(line, " will be built:") {
+		b.inPlan = true
+		return
+	}
+	if drv, ok := strings.CutPrefix(line, "building '"); ok {
+		drv, _, _ = strings.Cut(drv, "'")
+		b.found(drv)
+	}
+}
+
+func (b *buildLogWriter) found(drvPath string) {
+	name, ok := strings.CutSuffix(drvPath, ".drv")
+	if !ok {
+		return
+	}
+	name, ok = strings.CutPrefix(name, "/nix/store/")
+	if !ok || len(name) < 34 {
+		return
+	}
+	name = name[33:] // strip the hash
+	if b.seen[name] {
+		return
+	}
+	if b.seen == nil {
+		b.seen = make(map[string]bool)
+	}
+	b.seen[name] = true
+	b.onBuild(name)
+}
@@ -0,0 +1,48 @@
+package nix
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildLogWriter(t *testing.T) {
+	log := `these 2 derivations will be built:
+  /nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-2.12.1.drv
+  /nix/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-ripgrep-14.1.0.drv
+these 3 paths will be fetched (1.2 MiB download, 4.5 MiB unpacked):
+  /nix/store/cccccccccccccccccccccccccccccccc-glibc-2.39
+copying path '/nix/store/cccccccccccccccccccccccccccccccc-glibc-2.39' from 'https://cache.nixos.org'...
+building '/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-2.12.1.drv'...
+building '/nix/store/dddddddddddddddddddddddddddddddd-jq-1.7.1.drv'`
+
+	var got []string
+	var out strings.Builder
+	w := &buildLogWriter{w: &out, onBuild: func(name string) { got = append(got, name) }}
+
+	// Write in small chunks to check that lines split across writes are
+	// still recognized.
+	r := strings.NewReader(log)
+	buf := make([]byte, 7)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	w.Flush()
+
+	want := []string{"hello-2.12.1", "ripgrep-14.1.0", "jq-1.7.1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got derivations %q, want %q", got, want)
+	}
+	if out.String() != log {
+		t.Errorf("got output %q, want it unchanged", out.String())
+	}
+}
@@ -14,6 +14,8 @@
 	"strings"
 	"syscall"
 	"time"
+
+	"go.jetpack.io/devbox/internal/envir"
 )
 
 type cmd struct {
@@ -32,13 +34,19 @@ type cmd struct {
 
 func command(args ...any) *cmd {
 	cmd := &cmd{
-		Args: append(cmdArgs{
+		Args: cmdArgs{
 			"nix",
 			"--extra-experimental-features", "ca-derivations",
 			"--option", "experimental-features", "nix-command flakes fetch-closure",
-		}, args...),
+		},
 		logger: slog.Default(),
 	}
+	if envir.IsOffline() {
+		// Never hit substituters and use cached flake inputs regardless of
+		// their TTL.
+		cmd.Args = append(cmd.Args, "--offline")
+	}
+	cmd.Args = append(cmd.Args, args...)
 	return cmd
 }
 
@@ -0,0 +1,25 @@
+package nix
+
+import (
+	"slices"
+	"testing"
+
+	"go.jetpack.io/devbox/internal/envir"
+)
+
+func TestCommandOffline(t *testing.T) {
+	t.Setenv(envir.DevboxOffline, "")
+	if cmd := command("build", "nixpkgs#hello"); slices.Contains(cmd.Args, "--offline") {
+		t.Errorf("got args %v, want no --offline", cmd.Args)
+	}
+
+	t.Setenv(envir.DevboxOffline, "1")
+	cmd := command("build", "nixpkgs#hello")
+	i := slices.Index(cmd.Args, "--offline")
+	if i == -1 {
+		t.Fatalf("got args %v, want --offline", cmd.Args)
+	}
+	if build := slices.Index(cmd.Args, "build"); i > build {
+		t.Errorf("got --offline after the subcommand in %v, want it before", cmd.Args)
+	}
+}
@@ -10,4 +10,14 @@ type Nix struct{
 
 type Nixer interface {
 	PrintDevEnv(ctx context.Context, args *PrintDevEnvArgs) (*PrintDevEnvOut, error)
+	ProfileRemove(profilePath string, packageNames ...string) error
+	ProfileRollback(ctx context.Context, profilePath string, generation int) error
+}
+
+func (*Nix) ProfileRemove(profilePath string, packageNames ...string) error {
+	return ProfileRemove(profilePath, packageNames...)
+}
+
+func (*Nix) ProfileRollback(ctx context.Context, profilePath string, generation int) error {
+	return ProfileRollback(ctx, profilePath, generation)
 }
@@ -84,7 +84,7 @@ func (*Nix) PrintDevEnv(ctx context.Context, args *PrintDevEnvArgs) (*PrintDevEn
 		cmd.Args = append(cmd.Args, ref)
 		slog.Debug("running print-dev-env cmd", "cmd", cmd)
 		data, err = cmd.Output(ctx)
-		if insecure, insecureErr := IsExitErrorInsecurePackage(err, "" /*pkgName*/, "" /*installable*/); insecure {
+		if insecure, insecureErr := IsExitErrorInsecurePackage(err, "devbox add", "" /*pkgName*/, "" /*installable*/); insecure {
 			return nil, insecureErr
 		} else if err != nil {
 			return nil, err
@@ -386,7 +386,11 @@ func ProfileBinPath(projectDir string) string {
 	return filepath.Join(projectDir, ProfilePath, "bin")
 }
 
-func IsExitErrorInsecurePackage(err error, pkgNameOrEmpty, installableOrEmpty string) (bool, error) {
+// IsExitErrorInsecurePackage reports whether err is a nix error about a package
+// that is marked as insecure. If it is, it also returns a user error that
+// suggests re-adding the package with addCmd (such as "devbox add") and the
+// --allow-insecure flag.
+func IsExitErrorInsecurePackage(err error, addCmd, pkgNameOrEmpty, installableOrEmpty string) (bool, error) {
 	var exitErr *exec.ExitError
 	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
 		if strings.Contains(string(exitErr.Stderr), "is marked as insecure") {
@@ -412,7 +416,7 @@ func IsExitErrorInsecurePackage(err error, pkgNameOrEmpty, installableOrEmpty st
 				pkgName = "<pkg>"
 			}
 			errMessages = append(errMessages,
-				fmt.Sprintf("To override, use `devbox add %s --allow-insecure=%s`", pkgName, strings.Join(insecurePackages, ", ")))
+				fmt.Sprintf("To override, use `%s %s --allow-insecure=%s`", addCmd, pkgName, strings.Join(insecurePackages, ", ")))
 
 			return true, usererr.New("%s", strings.Join(errMessages, "\n\n"))
 		}
@@ -420,6 +424,17 @@ func IsExitErrorInsecurePackage(err error, pkgNameOrEmpty, installableOrEmpty st
 	return false, nil
 }
 
+// InsecurePackageNames returns the names of the packages, such as
+// "openssl-1.1.1w", that err says are marked as insecure. It returns nil if
+// err isn't a nix error about insecure packages.
+func InsecurePackageNames(err error) []string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || !strings.Contains(string(exitErr.Stderr), "is marked as insecure") {
+		return nil
+	}
+	return parseInsecurePackagesFromExitError(string(exitErr.Stderr))
+}
+
 func parseInsecurePackagesFromExitError(errorMsg string) []string {
 	insecurePackages := []string{}
 
@@ -2,6 +2,8 @@
 package nix
 
 import (
+	"errors"
+	"os/exec"
 	"slices"
 	"testing"
 )
@@ -71,6 +73,24 @@ func TestParseInsecurePackagesFromExitError(t *testing.T) {
 	}
 }
 
+func TestInsecurePackageNames(t *testing.T) {
+	stderr := `error: Package ‘openssl-1.1.1w’ in /nix/store/source/pkgs/openssl.nix:1 is marked as insecure, refusing to evaluate.
+
+              permittedInsecurePackages = [
+                "openssl-1.1.1w"
+              ];`
+	got := InsecurePackageNames(&exec.ExitError{Stderr: []byte(stderr)})
+	if !slices.Equal(got, []string{"openssl-1.1.1w"}) {
+		t.Errorf("got %q, want [openssl-1.1.1w]", got)
+	}
+	if got := InsecurePackageNames(&exec.ExitError{Stderr: []byte("error: build failed")}); got != nil {
+		t.Errorf("got %q for a build failure, want nil", got)
+	}
+	if got := InsecurePackageNames(errors.New("not a nix error")); got != nil {
+		t.Errorf("got %q for a non-exit error, want nil", got)
+	}
+}
+
 func TestParseVersionInfo(t *testing.T) {
 	raw := `nix (Nix) 2.21.2
 System type: aarch64-darwin
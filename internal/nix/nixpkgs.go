@@ -55,6 +55,26 @@ func EnsureNixpkgsPrefetched(w io.Writer, commit string) error {
 	return saveToNixpkgsCommitFile(commit, commitToLocation)
 }
 
+// LockedFlakeURL returns ref with its revision locked, such as
+// "github:NixOS/nixpkgs/<rev>" for "nixpkgs/nixos-unstable". It returns an
+// error if nix can't fetch the flake.
+func LockedFlakeURL(ctx context.Context, ref string) (string, error) {
+	out, err := command("flake", "metadata", "--json", ref).Output(ctx)
+	if err != nil {
+		return "", err
+	}
+	var metadata struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(out, &metadata); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if metadata.URL == "" {
+		return "", errors.Errorf("nix flake metadata returned no locked URL for %s", ref)
+	}
+	return metadata.URL, nil
+}
+
 func nixpkgsCommitFileContents() (map[string]string, error) {
 	path := nixpkgsCommitFilePath()
 	if !fileutil.Exists(path) {
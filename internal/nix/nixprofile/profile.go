@@ -5,7 +5,6 @@
 
 import (
 	"bufio"
-	"encoding/json"
 	"io"
 	"strconv"
 	"strings"
@@ -32,55 +31,22 @@ func ProfileListItems(
 		return profileListLegacy(writer, profileDir)
 	}
 
-	type ProfileListElement struct {
-		Active      bool     `json:"active"`
-		AttrPath    string   `json:"attrPath"`
-		OriginalURL string   `json:"originalUrl"`
-		Priority    int      `json:"priority"`
-		StorePaths  []string `json:"storePaths"`
-		URL         string   `json:"url"`
-	}
-	type ProfileListOutput struct {
-		Elements map[string]ProfileListElement `json:"elements"`
-		Version  int                           `json:"version"`
-	}
-
-	// Modern nix profiles: nix >= 2.20
-	var structOutput ProfileListOutput
-	if err := json.Unmarshal([]byte(output), &structOutput); err == nil {
-		items := []*NixProfileListItem{}
-		for name, element := range structOutput.Elements {
-			items = append(items, &NixProfileListItem{
-				name:              name,
-				unlockedReference: lo.Ternary(element.OriginalURL != "", element.OriginalURL+"#"+element.AttrPath, ""),
-				lockedReference:   lo.Ternary(element.URL != "", element.URL+"#"+element.AttrPath, ""),
-				nixStorePaths:     element.StorePaths,
-			})
-		}
-		return items, nil
-	}
-	// Fall back to trying format for nix < version 2.20
-
-	// ProfileListOutputJSONLegacy is for parsing `nix profile list --json` in nix < version 2.20
-	// that relied on index instead of name for each package installed.
-	type ProfileListOutputJSONLegacy struct {
-		Elements []ProfileListElement `json:"elements"`
-		Version  int                  `json:"version"`
-	}
-	var structOutput2 ProfileListOutputJSONLegacy
-	if err := json.Unmarshal([]byte(output), &structOutput2); err != nil {
+	elements, err := nix.ParseProfileManifest([]byte(output))
+	if err != nil {
 		return nil, err
 	}
-	items := []*NixProfileListItem{}
-	for index, element := range structOutput2.Elements {
+	items := make([]*NixProfileListItem, 0, len(elements))
+	for index, element := range elements {
 		items = append(items, &NixProfileListItem{
+			// Nix >= 2.20 identifies elements by name, older versions by
+			// index.
 			index:             index,
+			name:              element.Name,
 			unlockedReference: lo.Ternary(element.OriginalURL != "", element.OriginalURL+"#"+element.AttrPath, ""),
 			lockedReference:   lo.Ternary(element.URL != "", element.URL+"#"+element.AttrPath, ""),
 			nixStorePaths:     element.StorePaths,
 		})
 	}
-
 	return items, nil
 }
 
@@ -4,18 +4,18 @@
 package nixprofile
 
 import (
-	"os"
+	"io"
 
 	"go.jetpack.io/devbox/internal/devpkg"
 	"go.jetpack.io/devbox/internal/lock"
 	"go.jetpack.io/devbox/internal/nix"
 )
 
-func ProfileUpgrade(ProfileDir string, pkg *devpkg.Package, lock *lock.File) error {
+func ProfileUpgrade(w io.Writer, ProfileDir string, pkg *devpkg.Package, lock *lock.File) error {
 	nameOrIndex, err := ProfileListNameOrIndex(
 		&ProfileListNameOrIndexArgs{
 			Lockfile:   lock,
-			Writer:     os.Stderr,
+			Writer:     w,
 			Package:    pkg,
 			ProfileDir: ProfileDir,
 		},
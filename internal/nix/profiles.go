@@ -10,8 +10,14 @@
 	"fmt"
 	"io"
 	"io/fs"
+	"iter"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"go.jetpack.io/devbox/internal/debug"
@@ -34,13 +40,80 @@ type ProfileInstallArgs struct {
 	Installables []string
 	ProfilePath  string
 	Writer       io.Writer
+
+	// Priority is the profile priority to install the installables with. If
+	// nil, it defaults to a priority lower than any existing package in the
+	// profile.
+	Priority *int
+
+	// Verbose makes nix print more of its own output, including build logs,
+	// and streams it to Writer instead of only capturing it.
+	Verbose bool
 }
 
 var ErrPriorityConflict = errors.New("priority conflict")
 
+// PriorityConflictError is an [ErrPriorityConflict] that names the files that
+// conflict, when nix's output included them.
+type PriorityConflictError struct {
+	// Existing is the file from the package that's already in the
+	// profile, such as /nix/store/<hash>-hello-2.12.1/bin/hello.
+	Existing string
+
+	// New is the file with the same path from the package that was being
+	// installed.
+	New string
+}
+
+func (e *PriorityConflictError) Error() string {
+	return fmt.Sprintf("priority conflict between %s and %s", e.Existing, e.New)
+}
+
+func (e *PriorityConflictError) Is(target error) bool {
+	return target == ErrPriorityConflict
+}
+
+// reConflictFile matches a file in the nix store, such as the conflicting
+// files in the output of nix profile install.
+var reConflictFile = regexp.MustCompile("/nix/store/[0-9a-z]{32}-[^/\\s'\"`‘’]+/[^\\s'\"`‘’]+")
+
+// parsePriorityConflict returns the conflict that nix reported in out, or
+// ErrPriorityConflict if out doesn't name both files. Nix lists the file
+// that's already in the profile first, both in its "already provides the
+// following file" error and in buildenv's "collision between" error.
+func parsePriorityConflict(out []byte) error {
+	files := reConflictFile.FindAll(out, -1)
+	if len(files) < 2 {
+		return ErrPriorityConflict
+	}
+	return &PriorityConflictError{Existing: string(files[0]), New: string(files[1])}
+}
+
+// StorePathOf returns the store path that contains the file at path, such
+// as /nix/store/<hash>-hello-2.12.1 for /nix/store/<hash>-hello-2.12.1/bin/hello,
+// and the path of the file within it.
+func StorePathOf(path string) (storePath, rel string) {
+	rest, ok := strings.CutPrefix(path, "/nix/store/")
+	if !ok {
+		return "", path
+	}
+	dir, rel, _ := strings.Cut(rest, "/")
+	return "/nix/store/" + dir, rel
+}
+
+// verboseFlags make nix print more of its own output, including the logs of
+// any builds, which helps diagnose slow or failing installs.
+var verboseFlags = []string{"-v", "--print-build-logs"}
+
 func ProfileInstall(ctx context.Context, args *ProfileInstallArgs) error {
 	defer debug.FunctionTimer().End()
 
+	var priority string
+	if args.Priority != nil {
+		priority = strconv.Itoa(*args.Priority)
+	} else {
+		priority = nextPriority(args.ProfilePath)
+	}
 	cmd := command(
 		"profile", "install",
 		"--profile", args.ProfilePath,
@@ -49,9 +122,12 @@ func ProfileInstall(ctx context.Context, args *ProfileInstallArgs) error {
 		// Using an arbitrary priority to avoid conflicts with other packages.
 		// Note that this is not really the priority we care about, since we
 		// use the flake.nix to specify the priority.
-		"--priority", nextPriority(args.ProfilePath),
+		"--priority", priority,
 	)
 
+	if args.Verbose {
+		cmd.Args = appendArgs(cmd.Args, verboseFlags)
+	}
 	cmd.Args = appendArgs(cmd.Args, args.Installables)
 	cmd.Env = allowUnfreeEnv(os.Environ())
 
@@ -59,9 +135,20 @@ func ProfileInstall(ctx context.Context, args *ProfileInstallArgs) error {
 	// However, now we do the building in nix.Build, by the time we install in profile everything
 	// should already be in the store. We need to capture the output so we can decide if a conflict
 	// happened.
-	out, err := cmd.CombinedOutput(ctx)
-	if bytes.Contains(out, []byte("error: An existing package already provides the following file")) {
-		return ErrPriorityConflict
+	var out []byte
+	var err error
+	if args.Verbose && args.Writer != nil {
+		buf := &bytes.Buffer{}
+		cmd.Stdout = io.MultiWriter(args.Writer, buf)
+		cmd.Stderr = cmd.Stdout
+		err = cmd.Run(ctx)
+		out = buf.Bytes()
+	} else {
+		out, err = cmd.CombinedOutput(ctx)
+	}
+	if bytes.Contains(out, []byte("error: An existing package already provides the following file")) ||
+		bytes.Contains(out, []byte("collision between")) {
+		return parsePriorityConflict(out)
 	}
 	return err
 }
@@ -80,10 +167,46 @@ func ProfileRemove(profilePath string, packageNames ...string) error {
 	return cmd.Run(context.TODO())
 }
 
+// ProfileRollback switches the profile at profilePath to generation. If
+// generation is 0, it switches to the generation before the current one.
+func ProfileRollback(ctx context.Context, profilePath string, generation int) error {
+	defer debug.FunctionTimer().End()
+	cmd := command("profile", "rollback", "--profile", profilePath)
+	if generation != 0 {
+		cmd.Args = append(cmd.Args, "--to", strconv.Itoa(generation))
+	}
+	return cmd.Run(ctx)
+}
+
 type manifest struct {
-	Elements []struct {
-		Priority int
+	Elements []ProfileElement
+}
+
+// ProfileElement is a package entry in a nix profile's manifest.json.
+type ProfileElement struct {
+	// Name is the name nix >= 2.20 gives to each element. It's empty for
+	// profiles created by older versions of nix, which identify elements by
+	// their index instead.
+	Name string `json:"-"`
+
+	Active      bool     `json:"active"`
+	AttrPath    string   `json:"attrPath"`
+	OriginalURL string   `json:"originalUrl"`
+	Priority    int      `json:"priority"`
+	StorePaths  []string `json:"storePaths"`
+	URL         string   `json:"url"`
+}
+
+// ProfileElements iterates over the elements in the manifest of the profile at
+// profilePath. It reads the manifest directly instead of running nix, so it's
+// cheap enough to call from checks that run on every command. A profile
+// without a manifest yields no elements.
+func ProfileElements(profilePath string) (iter.Seq[ProfileElement], error) {
+	m, err := readManifest(profilePath)
+	if err != nil {
+		return nil, err
 	}
+	return slices.Values(m.Elements), nil
 }
 
 func readManifest(profilePath string) (manifest, error) {
@@ -94,38 +217,52 @@ func readManifest(profilePath string) (manifest, error) {
 	if err != nil {
 		return manifest{}, err
 	}
-
-	type manifestModern struct {
-		Elements map[string]struct {
-			Priority int `json:"priority"`
-		} `json:"elements"`
-	}
-	var modernMani manifestModern
-	if err := json.Unmarshal(data, &modernMani); err == nil {
-		// Convert to the result format
-		result := manifest{}
-		for _, e := range modernMani.Elements {
-			result.Elements = append(result.Elements, struct{ Priority int }{e.Priority})
-		}
-		return result, nil
+	elements, err := ParseProfileManifest(data)
+	if err != nil {
+		return manifest{}, err
 	}
+	return manifest{Elements: elements}, nil
+}
 
-	type manifestLegacy struct {
-		Elements []struct {
-			Priority int `json:"priority"`
-		} `json:"elements"`
+// ParseProfileManifest parses the elements of a nix profile from a profile's
+// manifest.json or the output of `nix profile list --json`, which share the
+// same schema. Nix >= 2.20 stores elements in an object keyed by name, which
+// ParseProfileManifest returns sorted by name. Older versions store them in
+// an array and identify them by index. The schema version field is ignored
+// in favor of checking which of the two forms is used.
+func ParseProfileManifest(data []byte) ([]ProfileElement, error) {
+	var raw struct {
+		Elements json.RawMessage `json:"elements"`
 	}
-	var legacyMani manifestLegacy
-	if err := json.Unmarshal(data, &legacyMani); err != nil {
-		return manifest{}, err
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse nix profile manifest: %w", err)
 	}
 
-	// Convert to the result format
-	result := manifest{}
-	for _, e := range legacyMani.Elements {
-		result.Elements = append(result.Elements, struct{ Priority int }{e.Priority})
+	elements := bytes.TrimSpace(raw.Elements)
+	switch {
+	case len(elements) == 0 || bytes.Equal(elements, []byte("null")):
+		return nil, nil
+	case elements[0] == '{':
+		var named map[string]ProfileElement
+		if err := json.Unmarshal(elements, &named); err != nil {
+			return nil, fmt.Errorf("parse nix profile manifest: %w", err)
+		}
+		result := make([]ProfileElement, 0, len(named))
+		for _, name := range slices.Sorted(maps.Keys(named)) {
+			e := named[name]
+			e.Name = name
+			result = append(result, e)
+		}
+		return result, nil
+	case elements[0] == '[':
+		var result []ProfileElement
+		if err := json.Unmarshal(elements, &result); err != nil {
+			return nil, fmt.Errorf("parse nix profile manifest: %w", err)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("parse nix profile manifest: elements must be an object or array")
 	}
-	return result, nil
 }
 
 const DefaultPriority = 5
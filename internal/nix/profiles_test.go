@@ -0,0 +1,105 @@
+package nix
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseProfileManifest(t *testing.T) {
+	hello := ProfileElement{
+		Active:      true,
+		AttrPath:    "legacyPackages.x86_64-linux.hello",
+		OriginalURL: "flake:nixpkgs",
+		Priority:    5,
+		StorePaths:  []string{"/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-2.12.1"},
+		URL:         "github:NixOS/nixpkgs/4f0dadbf38ee4cf4cc38cbc232b7708fddf965bc",
+	}
+	jq := ProfileElement{
+		Active:   true,
+		Priority: 6,
+		StorePaths: []string{
+			"/nix/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-jq-1.7.1-bin",
+			"/nix/store/cccccccccccccccccccccccccccccccc-jq-1.7.1-man",
+		},
+	}
+	namedHello, namedJQ := hello, jq
+	namedHello.Name, namedJQ.Name = "hello", "jq"
+
+	tests := map[string][]ProfileElement{
+		// nix < 2.20 identifies elements by index.
+		"testdata/manifest-v2.json": {hello, jq},
+		// nix >= 2.20 names elements. They're sorted by name.
+		"testdata/manifest-v3.json": {namedHello, namedJQ},
+	}
+	for path, want := range tests {
+		t.Run(path, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseProfileManifest(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got elements %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseProfileManifestEdgeCases(t *testing.T) {
+	for _, data := range []string{`{}`, `{"version": 3}`, `{"elements": null}`, `{"elements": {}}`, `{"elements": []}`} {
+		got, err := ParseProfileManifest([]byte(data))
+		if err != nil {
+			t.Errorf("ParseProfileManifest(%s) error: %v", data, err)
+		}
+		if len(got) != 0 {
+			t.Errorf("ParseProfileManifest(%s) = %+v, want no elements", data, got)
+		}
+	}
+	for _, data := range []string{``, `[]`, `{"elements": "hello"}`, `{"elements": [1]}`} {
+		if _, err := ParseProfileManifest([]byte(data)); err == nil {
+			t.Errorf("ParseProfileManifest(%s) returned nil error", data)
+		}
+	}
+}
+
+func TestParsePriorityConflict(t *testing.T) {
+	existing := "/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-vim-9.1.0/bin/vi"
+	added := "/nix/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-neovim-0.10.0/bin/vi"
+	tests := map[string]string{
+		"AlreadyProvides": "error: An existing package already provides the following file:\n\n" +
+			"         " + existing + "\n\n" +
+			"       This is the conflicting file from the new package:\n\n" +
+			"         " + added + "\n",
+		"Collision": "error: collision between `" + existing + "' and `" + added + "'\n",
+	}
+	for name, out := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := parsePriorityConflict([]byte(out))
+			if !errors.Is(err, ErrPriorityConflict) {
+				t.Fatalf("got error %v, want it to be ErrPriorityConflict", err)
+			}
+			conflict := &PriorityConflictError{}
+			if !errors.As(err, &conflict) {
+				t.Fatalf("got error %T, want a *PriorityConflictError", err)
+			}
+			if conflict.Existing != existing || conflict.New != added {
+				t.Errorf("got conflict between %q and %q, want %q and %q",
+					conflict.Existing, conflict.New, existing, added)
+			}
+		})
+	}
+
+	if err := parsePriorityConflict([]byte("collision between files")); err != ErrPriorityConflict {
+		t.Errorf("got error %v without any files, want ErrPriorityConflict", err)
+	}
+
+	storePath, rel := StorePathOf(added)
+	if storePath != "/nix/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-neovim-0.10.0" || rel != "bin/vi" {
+		t.Errorf("StorePathOf(%q) = %q, %q", added, storePath, rel)
+	}
+}
@@ -64,6 +64,57 @@ func StorePathsAreInStore(ctx context.Context, storePaths []string) (map[string]
 	return parseStorePathFromInstallableOutput(output)
 }
 
+// ClosureSizes returns the closure size in bytes of each of storePaths that is
+// in the store. It doesn't substitute or build missing paths.
+func ClosureSizes(ctx context.Context, storePaths []string) (map[string]int64, error) {
+	defer debug.FunctionTimer().End()
+	if len(storePaths) == 0 {
+		return map[string]int64{}, nil
+	}
+	cmd := command("path-info", "--offline", "--json", "--closure-size")
+	cmd.Args = appendArgs(cmd.Args, storePaths)
+	output, err := cmd.Output(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseClosureSizes(output)
+}
+
+// parseClosureSizes parses the output of
+// `nix path-info --json --closure-size`. Paths that aren't in the store are
+// omitted.
+func parseClosureSizes(output []byte) (map[string]int64, error) {
+	type pathInfo struct {
+		Path        string `json:"path"`
+		Valid       *bool  `json:"valid"`
+		ClosureSize int64  `json:"closureSize"`
+	}
+	result := map[string]int64{}
+
+	// Newer nix versions are an object keyed by store path with null values
+	// for paths that aren't in the store.
+	var modern map[string]*pathInfo
+	if err := json.Unmarshal(output, &modern); err == nil {
+		for path, info := range modern {
+			if info != nil {
+				result[path] = info.ClosureSize
+			}
+		}
+		return result, nil
+	}
+
+	var legacy []pathInfo
+	if err := json.Unmarshal(output, &legacy); err == nil {
+		for _, info := range legacy {
+			if info.Valid == nil || *info.Valid {
+				result[info.Path] = info.ClosureSize
+			}
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("failed to parse path-info output: %s", output)
+}
+
 // Older nix versions (like 2.17) are an array of objects that contain path and valid fields
 type LegacyPathInfo struct {
 	Path  string `json:"path"`
@@ -56,3 +56,38 @@ func TestParseStorePathFromInstallableOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestParseClosureSizes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected map[string]int64
+	}{
+		{
+			name:  "nix-2-20",
+			input: `{"/nix/store/aaaa-go-1.22.0":{"closureSize":229715232},"/nix/store/bbbb-jq-1.7.1":null}`,
+			expected: map[string]int64{
+				"/nix/store/aaaa-go-1.22.0": 229715232,
+			},
+		},
+		{
+			name:  "nix-2-17",
+			input: `[{"path":"/nix/store/aaaa-go-1.22.0","closureSize":229715232},{"path":"/nix/store/bbbb-jq-1.7.1","valid":false}]`,
+			expected: map[string]int64{
+				"/nix/store/aaaa-go-1.22.0": 229715232,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := parseClosureSizes([]byte(tc.input))
+			if err != nil {
+				t.Errorf("Expected no error but got error: %s", err)
+			}
+			if !maps.Equal(tc.expected, actual) {
+				t.Errorf("Expected closure sizes %v but got %v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -5,7 +5,7 @@
 
 import (
 	"context"
-	"os"
+	"io"
 
 	"go.jetpack.io/devbox/internal/ux"
 )
@@ -18,12 +18,12 @@ func ProfileUpgrade(ProfileDir, indexOrName string) error {
 	).Run(context.TODO())
 }
 
-func FlakeUpdate(ProfileDir string) error {
+func FlakeUpdate(w io.Writer, ProfileDir string) error {
 	version, err := Version()
 	if err != nil {
 		return err
 	}
-	ux.Finfof(os.Stderr, "Running \"nix flake update\"\n")
+	ux.Finfof(w, "Running \"nix flake update\"\n")
 	cmd := command("flake", "update")
 	if version.AtLeast(Version2_19) {
 		cmd.Args = append(cmd.Args, "--flake")
@@ -44,6 +44,16 @@ type DerivationBuilder struct {
 	RestoreRefs bool
 	bytePatches map[string][]fileSlice
 
+	// AllowedPrefixes are the directories that the builder may write to.
+	// It refuses to create or patch any file outside of them, which guards
+	// against a bad path mapping writing to the wrong files. If it's
+	// empty, it defaults to $NIX_STORE or /nix/store.
+	AllowedPrefixes []string
+
+	// SearchCache is an optional cache of the results of searching store
+	// paths for removed references. If it's nil, every file is searched.
+	SearchCache *SearchCache
+
 	// src contains the source files of the derivation. For flakes, this is
 	// anything in the flake.nix directory.
 	src *packageFS
@@ -66,6 +76,12 @@ func (d *DerivationBuilder) init() error {
 			return fmt.Errorf("patchpkg: $out is empty (is this being run from a nix build?)")
 		}
 	}
+	if len(d.AllowedPrefixes) == 0 {
+		d.AllowedPrefixes = []string{cmp.Or(os.Getenv("NIX_STORE"), "/nix/store")}
+	}
+	if err := d.checkWritable(d.Out); err != nil {
+		return err
+	}
 	if d.Glibc != "" {
 		if d.glibcPatcher == nil {
 			d.glibcPatcher = &libPatcher{}
@@ -182,8 +198,36 @@ func (d *DerivationBuilder) restoreMissingRefs(ctx context.Context, pkg *package
 	return nil
 }
 
+// writablePath is like out.OSPath, but it returns an error if the path isn't
+// in one of d.AllowedPrefixes.
+func (d *DerivationBuilder) writablePath(out *packageFS, path string) (string, error) {
+	osPath, err := out.OSPath(path)
+	if err != nil {
+		return "", err
+	}
+	if err := d.checkWritable(osPath); err != nil {
+		return "", err
+	}
+	return osPath, nil
+}
+
+// checkWritable returns an error if path isn't inside one of
+// d.AllowedPrefixes. It compares the paths lexically without resolving
+// symlinks.
+func (d *DerivationBuilder) checkWritable(path string) error {
+	for _, prefix := range d.AllowedPrefixes {
+		rel, err := filepath.Rel(prefix, path)
+		if err == nil && rel != "." && rel != ".." &&
+			!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("patchpkg: refusing to write to %s because it isn't in %s",
+		path, strings.Join(d.AllowedPrefixes, ", "))
+}
+
 func (d *DerivationBuilder) copyDir(out *packageFS, path string) error {
-	path, err := out.OSPath(path)
+	path, err := d.writablePath(out, path)
 	if err != nil {
 		return err
 	}
@@ -203,7 +247,7 @@ func (d *DerivationBuilder) copyFile(ctx context.Context, pkg, out *packageFS, p
 		if err != nil {
 			return err
 		}
-		dstPath, err := out.OSPath(path)
+		dstPath, err := d.writablePath(out, path)
 		if err != nil {
 			return err
 		}
@@ -224,7 +268,7 @@ func (d *DerivationBuilder) copyFile(ctx context.Context, pkg, out *packageFS, p
 		perm = fs.FileMode(0o777)
 	}
 
-	dstPath, err := out.OSPath(path)
+	dstPath, err := d.writablePath(out, path)
 	if err != nil {
 		return err
 	}
@@ -249,7 +293,7 @@ func (d *DerivationBuilder) copyFile(ctx context.Context, pkg, out *packageFS, p
 }
 
 func (d *DerivationBuilder) copySymlink(pkg, out *packageFS, path string) error {
-	link, err := out.OSPath(path)
+	link, err := d.writablePath(out, path)
 	if err != nil {
 		return err
 	}
@@ -268,9 +312,15 @@ func (d *DerivationBuilder) needsGlibcPatch(file *bufio.Reader, filePath string)
 		return false
 	}
 
+	return isELF(file)
+}
+
+// isELF reports whether r starts with the ELF magic number without consuming
+// any of its data.
+func isELF(r *bufio.Reader) bool {
 	// ELF binaries are identifiable by the first 4 magic bytes:
 	// 0x7F E L F
-	magic, err := file.Peek(4)
+	magic, err := r.Peek(4)
 	if err != nil {
 		return false
 	}
@@ -287,11 +337,15 @@ func (d *DerivationBuilder) findRemovedRefs(ctx context.Context, pkg *packageFS)
 		if ctx.Err() != nil {
 			return nil, ctx.Err()
 		}
-		matches, err := searchFile(pkg, name, reRemovedRefs)
+		// Every removed ref has to be restored, so don't cap the matches.
+		result, err := d.SearchCache.searchFile(pkg, name, reRemovedRefs, 0)
 		if err != nil {
 			return nil, err
 		}
-		refs = append(refs, matches...)
+		if result.truncated {
+			slog.WarnContext(ctx, "file is too large to search for all removed store refs", "path", name, "limit", maxFileSize)
+		}
+		refs = append(refs, result.matches...)
 	}
 
 	pkgNameToHash := make(map[string]string, len(refs))
@@ -300,7 +354,7 @@ func (d *DerivationBuilder) findRemovedRefs(ctx context.Context, pkg *packageFS)
 			return nil, ctx.Err()
 		}
 
-		name := string(ref.data[33:])
+		name := string(ref.submatches[0])
 		if hash, ok := pkgNameToHash[name]; ok {
 			copy(ref.data, hash)
 			continue
@@ -394,7 +448,7 @@ type packageFS struct {
 // newPackageFS returns a packageFS for the given store path.
 func newPackageFS(storePath string) *packageFS {
 	return &packageFS{
-		FS:        os.DirFS(storePath),
+		FS:        newStoreFS(os.DirFS(storePath)),
 		storePath: storePath,
 	}
 }
@@ -0,0 +1,57 @@
+package patchpkg
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFileAllowedPrefixes(t *testing.T) {
+	pkgDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(pkgDir, "file.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pkg := newPackageFS(pkgDir)
+	store := t.TempDir()
+	d := &DerivationBuilder{AllowedPrefixes: []string{store}}
+
+	// Writing inside the allowed prefix works.
+	inside := newPackageFS(filepath.Join(store, "aaaa-patched"))
+	if err := os.Mkdir(inside.storePath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.copyFile(context.Background(), pkg, inside, "file.txt"); err != nil {
+		t.Errorf("got copyFile error inside allowed prefix: %v", err)
+	}
+
+	// Writing outside of it is rejected before the file is created.
+	outside := newPackageFS(t.TempDir())
+	if err := d.copyFile(context.Background(), pkg, outside, "file.txt"); err == nil {
+		t.Error("got nil copyFile error outside allowed prefix")
+	}
+	_, err := os.Lstat(filepath.Join(outside.storePath, "file.txt"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("copyFile created a file outside allowed prefix (stat error: %v)", err)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	d := &DerivationBuilder{AllowedPrefixes: []string{"/nix/store"}}
+	tests := map[string]bool{
+		"/nix/store/aaaa-pkg":          true,
+		"/nix/store/aaaa-pkg/bin/ls":   true,
+		"/nix/store":                   false,
+		"/nix/store-other/aaaa-pkg":    false,
+		"/nix/store/../../etc/passwd":  false,
+		"/home/user/.local/share/file": false,
+	}
+	for path, want := range tests {
+		err := d.checkWritable(path)
+		if got := err == nil; got != want {
+			t.Errorf("checkWritable(%q) error = %v, want allowed = %v", path, err, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package patchpkg
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// searchCacheVersion is part of the path to every cache entry. Increment it
+// when the format of an entry or the results of searchFile change so that
+// stale entries are ignored.
+const searchCacheVersion = "v3"
+
+// SearchCache saves the results of searching files on disk so that searching
+// the same file with the same regular expression again doesn't need to read
+// it. Files in the Nix store never change, so their results can be reused
+// across runs. Files outside of the store are always searched.
+//
+// A nil *SearchCache is valid and doesn't cache anything.
+type SearchCache struct {
+	dir string
+}
+
+// NewSearchCache returns a cache that stores its entries in dir. The
+// directory is created when the first entry is saved.
+func NewSearchCache(dir string) *SearchCache {
+	return &SearchCache{dir: dir}
+}
+
+// cacheEntry is the on-disk form of a [searchResult].
+type cacheEntry struct {
+	Matches   []cacheMatch `json:"matches"`
+	Truncated bool         `json:"truncated"`
+}
+
+type cacheMatch struct {
+	Offset     int64    `json:"offset"`
+	Data       []byte   `json:"data"`
+	Submatches [][]byte `json:"submatches,omitempty"`
+}
+
+// searchFile is like [searchFileMax], but returns the cached result when there
+// is one. Results are cached separately for each maxMatches, so a search for
+// all of the matches never gets the result of a capped search. Cached results
+// don't include the searched data, so their data field is nil. Errors reading
+// or writing the cache are logged and otherwise ignored.
+func (c *SearchCache) searchFile(fsys fs.FS, path string, re *regexp.Regexp, maxMatches int) (searchResult, error) {
+	entryPath, ok := c.entryPath(fsys, path, re, maxMatches)
+	if !ok {
+		return searchFileMax(fsys, path, re, maxMatches)
+	}
+	if result, ok := c.load(entryPath, path); ok {
+		return result, nil
+	}
+
+	result, err := searchFileMax(fsys, path, re, maxMatches)
+	if err != nil {
+		return searchResult{}, err
+	}
+	if err := c.save(entryPath, result); err != nil {
+		slog.Warn("unable to cache search result", "path", path, "cache", c.dir, "err", err)
+	}
+	return result, nil
+}
+
+// entryPath returns the path to the cache entry for searching path with re
+// for at most maxMatches matches. It returns false if the result can't be
+// cached because fsys isn't a package in the Nix store.
+func (c *SearchCache) entryPath(fsys fs.FS, path string, re *regexp.Regexp, maxMatches int) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	pkg, ok := fsys.(*packageFS)
+	if !ok {
+		return "", false
+	}
+	nixStore := cmp.Or(os.Getenv("NIX_STORE"), "/nix/store")
+	if !strings.HasPrefix(pkg.storePath, nixStore+"/") {
+		return "", false
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d", pkg.storePath, path, re, maxMatches)
+	key := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(c.dir, searchCacheVersion, key[:2], key+".json"), true
+}
+
+func (c *SearchCache) load(entryPath, path string) (searchResult, bool) {
+	data, err := os.ReadFile(entryPath)
+	if err != nil {
+		return searchResult{}, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		slog.Debug("ignoring invalid search cache entry", "path", entryPath, "err", err)
+		return searchResult{}, false
+	}
+
+	result := searchResult{truncated: entry.Truncated}
+	for _, match := range entry.Matches {
+		result.matches = append(result.matches, fileSlice{
+			path:       path,
+			data:       match.Data,
+			offset:     match.Offset,
+			submatches: match.Submatches,
+		})
+	}
+	return result, true
+}
+
+// save writes an entry to a temporary file and renames it into place so that
+// concurrent runs never see a partially written entry.
+func (c *SearchCache) save(entryPath string, result searchResult) error {
+	entry := cacheEntry{
+		Matches:   make([]cacheMatch, len(result.matches)),
+		Truncated: result.truncated,
+	}
+	for i, match := range result.matches {
+		entry.Matches[i] = cacheMatch{Offset: match.offset, Data: match.data, Submatches: match.submatches}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(entryPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), entryPath)
+}
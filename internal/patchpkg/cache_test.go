@@ -0,0 +1,86 @@
+package patchpkg
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSearchCache(t *testing.T) {
+	t.Setenv("NIX_STORE", "/nix/store")
+	ref := "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-python3-3.12.4"
+	mapFS := fstest.MapFS{
+		"lib/_sysconfigdata.py": &fstest.MapFile{Data: []byte(`PREFIX = "/nix/store/` + ref + `"`)},
+	}
+	cache := NewSearchCache(t.TempDir())
+
+	search := func(storePath string) searchResult {
+		t.Helper()
+		pkg := &packageFS{FS: mapFS, storePath: storePath}
+		result, err := cache.searchFile(pkg, "lib/_sysconfigdata.py", reRemovedRefs, 0)
+		if err != nil {
+			t.Fatalf("got searchFile error: %v", err)
+		}
+		return result
+	}
+	assertRef := func(result searchResult) {
+		t.Helper()
+		if len(result.matches) != 1 || string(result.matches[0].data) != ref {
+			t.Fatalf("got matches %v, want %s", result.matches, ref)
+		}
+	}
+
+	storePath := "/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-python3-3.12.4"
+	assertRef(search(storePath))
+
+	// Store paths are immutable, so changing the file behind the cache's
+	// back must not change the result.
+	mapFS["lib/_sysconfigdata.py"] = &fstest.MapFile{Data: []byte("PREFIX = ''")}
+	result := search(storePath)
+	assertRef(result)
+	if result.data != nil {
+		t.Error("cached result has data, want nil")
+	}
+	if result.matches[0].path != "lib/_sysconfigdata.py" {
+		t.Errorf("got match path %q, want lib/_sysconfigdata.py", result.matches[0].path)
+	}
+
+	// Files outside of the store are always searched.
+	if got := search(filepath.Join(t.TempDir(), "pkg")); len(got.matches) != 0 {
+		t.Errorf("got matches %v outside of the store, want none", got.matches)
+	}
+}
+
+func TestSearchCacheNil(t *testing.T) {
+	mapFS := fstest.MapFS{"file": &fstest.MapFile{Data: []byte("eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-a")}}
+	pkg := &packageFS{FS: mapFS, storePath: "/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-a"}
+
+	var cache *SearchCache
+	result, err := cache.searchFile(pkg, "file", reRemovedRefs, 0)
+	if err != nil {
+		t.Fatalf("got searchFile error: %v", err)
+	}
+	if len(result.matches) != 1 {
+		t.Errorf("got %d matches, want 1", len(result.matches))
+	}
+}
+
+func TestSearchCacheMaxMatches(t *testing.T) {
+	t.Setenv("NIX_STORE", "/nix/store")
+	ref := "/nix/store/eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-python3-3.12.4"
+	mapFS := fstest.MapFS{
+		"lib/_sysconfigdata.py": &fstest.MapFile{Data: []byte(`PREFIX = "` + ref + `"\nBINDIR = "` + ref + `/bin"`)},
+	}
+	pkg := &packageFS{FS: mapFS, storePath: "/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-python3-3.12.4"}
+	cache := NewSearchCache(t.TempDir())
+
+	for _, test := range []struct{ maxMatches, want int }{{1, 1}, {0, 2}, {1, 1}} {
+		result, err := cache.searchFile(pkg, "lib/_sysconfigdata.py", reRemovedRefs, test.maxMatches)
+		if err != nil {
+			t.Fatalf("got searchFile error: %v", err)
+		}
+		if len(result.matches) != test.want {
+			t.Errorf("got %d matches with maxMatches %d, want %d", len(result.matches), test.maxMatches, test.want)
+		}
+	}
+}
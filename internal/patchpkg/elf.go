@@ -144,7 +144,7 @@ func FindSharedLibrary(name string, searchPath ...string) iter.Seq[SharedLibrary
 		for i := range searchPath {
 			patterns[i] = filepath.Join(searchPath[i], suffix)
 		}
-		for match := range searchGlobs(patterns) {
+		for match := range searchGlobs(patterns, nil) {
 			lib, err := OpenSharedLibrary(match)
 			if err != nil {
 				continue
@@ -0,0 +1,104 @@
+package patchpkg
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"regexp"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Text encodings that [searchFileUTF16] decodes.
+const (
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+)
+
+// detectUTF16 returns the encoding of data if it starts with a UTF-16 byte
+// order mark.
+func detectUTF16(data []byte) (encoding string, order binary.ByteOrder) {
+	switch {
+	case len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE:
+		return encodingUTF16LE, binary.LittleEndian
+	case len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF:
+		return encodingUTF16BE, binary.BigEndian
+	}
+	return "", nil
+}
+
+// searchFileUTF16 is like [searchFile], but if the file starts with a UTF-16
+// byte order mark, it decodes the file to UTF-8 before searching it. This
+// lets re match text in UTF-16 files, such as some Windows config files.
+//
+// The offsets of matches are still byte offsets in the original file, but
+// their data is UTF-8, so it can't be written back to the file as is. The
+// result's data is the original file and its encoding is set, so use
+// [searchResult.lineNumber] to find the line of a match.
+func searchFileUTF16(fsys fs.FS, path string, re *regexp.Regexp) (searchResult, error) {
+	data, truncated, err := readFileLimit(fsys, path, maxFileSize)
+	if err != nil {
+		return searchResult{}, err
+	}
+	encoding, order := detectUTF16(data)
+	if encoding == "" {
+		return searchData(path, data, truncated, re, maxFileSize, 0), nil
+	}
+
+	decoded, offsets := decodeUTF16(data[2:], order)
+	result := searchResult{truncated: truncated, data: data, encoding: encoding}
+	for _, loc := range findAllIndex(re, decoded, 0) {
+		start, end := loc[0], loc[1]
+		offset := 2 + offsets[start]
+		if pastLimit(offset, maxFileSize, truncated) {
+			break
+		}
+		result.matches = append(result.matches, fileSlice{
+			path:       path,
+			data:       decoded[start:end],
+			offset:     offset,
+			submatches: submatches(decoded, loc),
+		})
+	}
+	return result, nil
+}
+
+// decodeUTF16 decodes UTF-16 data to UTF-8. offsets maps each byte of the
+// UTF-8 text, plus the end of the text, to the offset of the code unit in
+// data that it was decoded from. Unpaired surrogates become U+FFFD and a
+// trailing odd byte is ignored.
+func decodeUTF16(data []byte, order binary.ByteOrder) (decoded []byte, offsets []int64) {
+	decoded = make([]byte, 0, len(data))
+	offsets = make([]int64, 0, len(data)+1)
+	for i := 0; i+1 < len(data); {
+		start := i
+		r := rune(order.Uint16(data[i:]))
+		i += 2
+		if utf16.IsSurrogate(r) && i+1 < len(data) {
+			if pair := utf16.DecodeRune(r, rune(order.Uint16(data[i:]))); pair != utf8.RuneError {
+				r = pair
+				i += 2
+			}
+		}
+		if utf16.IsSurrogate(r) {
+			r = utf8.RuneError
+		}
+		n := len(decoded)
+		decoded = utf8.AppendRune(decoded, r)
+		for range len(decoded) - n {
+			offsets = append(offsets, int64(start))
+		}
+	}
+	offsets = append(offsets, int64(len(data)&^1))
+	return decoded, offsets
+}
+
+// lineNumber returns the 1-based line and column of match, decoding r.data
+// first if the search decoded it.
+func (r searchResult) lineNumber(match fileSlice) (line, col int) {
+	_, order := detectUTF16(r.data)
+	if r.encoding == "" || order == nil {
+		return match.LineNumber(r.data)
+	}
+	before, _ := decodeUTF16(r.data[2:min(match.offset, int64(len(r.data)))], order)
+	return fileSlice{offset: int64(len(before))}.LineNumber(before)
+}
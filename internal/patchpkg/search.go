@@ -1,13 +1,20 @@
 package patchpkg
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"iter"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"unicode/utf8"
@@ -17,52 +24,349 @@
 // searching.
 const maxFileSize = 1 << 30 // 1 GiB
 
+// maxMatchSize is the longest match that searchFile guarantees to find when it
+// spans the [maxFileSize] boundary. It's the length of a store path's hash, a
+// dash, and the longest name Nix allows (211 bytes).
+const maxMatchSize = 32 + 1 + 211
+
 // reRemovedRefs matches a removed Nix store path where the hash is
-// overwritten with e's (making it an invalid nix hash).
-var reRemovedRefs = regexp.MustCompile(`e{32}-[^$"'{}/[\] \t\r\n]+`)
+// overwritten with e's (making it an invalid nix hash). Its group captures
+// the name of the store path after the hash.
+var reRemovedRefs = regexp.MustCompile(`e{32}-([^$"'{}/[\] \t\r\n]+)`)
 
 // fileSlice is a slice of data within a file.
 type fileSlice struct {
 	path   string
 	data   []byte
 	offset int64
+
+	// submatches are the parts of data that matched each capture group of
+	// the regular expression, in order. A group that didn't take part in
+	// the match is nil. It's empty for regular expressions without groups.
+	submatches [][]byte
 }
 
 func (f fileSlice) String() string {
 	return fmt.Sprintf("%s@%d: %s", f.path, f.offset, f.data)
 }
 
+// LineNumber returns the 1-based line and column where the slice starts.
+// data is the content of the file that the slice is from, starting at offset
+// 0. Lines end with \n, so CRLF line endings count as a single line break.
+// The column counts UTF-8 characters, not bytes; invalid bytes count as one
+// character each.
+func (f fileSlice) LineNumber(data []byte) (line, col int) {
+	before := data[:min(f.offset, int64(len(data)))]
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return bytes.Count(before, []byte{'\n'}) + 1, utf8.RuneCount(before[lineStart:]) + 1
+}
+
+// searchResult is the result of searching a file with [searchFile].
+type searchResult struct {
+	matches []fileSlice
+
+	// truncated is true when the file was too large to search entirely,
+	// meaning that there may be matches beyond the searched data.
+	truncated bool
+
+	// data is the searched data, for computing the line numbers of
+	// matches with [searchResult.lineNumber].
+	data []byte
+
+	// encoding is the encoding that [searchFileUTF16] decoded data from
+	// before searching it. It's empty when the search used the raw bytes.
+	encoding string
+}
+
 // searchFile searches a single file for a regular expression. It limits the
 // search to the first [maxFileSize] bytes of the file to avoid consuming too
 // much memory.
-func searchFile(fsys fs.FS, path string, re *regexp.Regexp) ([]fileSlice, error) {
+func searchFile(fsys fs.FS, path string, re *regexp.Regexp) (searchResult, error) {
+	return searchFileLimit(fsys, path, re, maxFileSize)
+}
+
+// searchFileLimit is like [searchFile], but searches the first limit bytes of
+// the file. If the file is larger than limit, it reads up to [maxMatchSize]
+// extra bytes so that it still finds matches that start before limit and end
+// after it.
+func searchFileLimit(fsys fs.FS, path string, re *regexp.Regexp, limit int64) (searchResult, error) {
+	data, truncated, err := readFileLimit(fsys, path, limit)
+	if err != nil {
+		return searchResult{}, err
+	}
+	return searchData(path, data, truncated, re, limit, 0), nil
+}
+
+// searchFileMax is like [searchFile], but returns at most maxMatches matches,
+// or all of them if maxMatches is 0. It stops scanning the file once it has
+// found maxMatches, which is cheaper when the caller only needs to know
+// whether a file contains a pattern, or needs its first few matches.
+func searchFileMax(fsys fs.FS, path string, re *regexp.Regexp, maxMatches int) (searchResult, error) {
+	data, truncated, err := readFileLimit(fsys, path, maxFileSize)
+	if err != nil {
+		return searchResult{}, err
+	}
+	return searchData(path, data, truncated, re, maxFileSize, maxMatches), nil
+}
+
+// searchData searches the data that [readFileLimit] read from path. It
+// returns at most maxMatches matches, or all of them if maxMatches is 0.
+func searchData(path string, data []byte, truncated bool, re *regexp.Regexp, limit int64, maxMatches int) searchResult {
+	result := searchResult{truncated: truncated, data: data}
+	for _, loc := range findAllIndex(re, data, maxMatches) {
+		start, end := loc[0], loc[1]
+		if pastLimit(int64(start), limit, truncated) {
+			break
+		}
+		result.matches = append(result.matches, fileSlice{
+			path:       path,
+			data:       data[start:end],
+			offset:     int64(start),
+			submatches: submatches(data, loc),
+		})
+	}
+	return result
+}
+
+// findAllIndex is like [regexp.Regexp.FindAllIndex], but it also returns the
+// indexes of the capture groups in each match when re has any. Finding
+// submatches is slower, so regular expressions without groups don't pay for
+// it. It returns at most maxMatches matches, or all of them if maxMatches is
+// 0.
+func findAllIndex(re *regexp.Regexp, data []byte, maxMatches int) [][]int {
+	n := -1
+	if maxMatches > 0 {
+		n = maxMatches
+	}
+	if re.NumSubexp() == 0 {
+		return re.FindAllIndex(data, n)
+	}
+	return re.FindAllSubmatchIndex(data, n)
+}
+
+// submatches returns the slices of data for the capture groups in loc, one of
+// the results of [findAllIndex].
+func submatches(data []byte, loc []int) [][]byte {
+	if len(loc) <= 2 {
+		return nil
+	}
+	groups := make([][]byte, 0, len(loc)/2-1)
+	for i := 2; i+1 < len(loc); i += 2 {
+		if loc[i] < 0 {
+			groups = append(groups, nil)
+			continue
+		}
+		groups = append(groups, data[loc[i]:loc[i+1]])
+	}
+	return groups
+}
+
+// countMatches is like [searchFile], but only counts the matches. It's
+// cheaper when the caller only needs to know if, or how many times, a file
+// contains a pattern.
+func countMatches(fsys fs.FS, path string, re *regexp.Regexp) (int, error) {
+	data, truncated, err := readFileLimit(fsys, path, maxFileSize)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, loc := range re.FindAllIndex(data, -1) {
+		if pastLimit(int64(loc[0]), maxFileSize, truncated) {
+			break
+		}
+		count++
+	}
+	return count, nil
+}
+
+// pastLimit reports whether a match starting at offset should be left out of
+// a search limited to limit bytes. When the file ended inside the tail
+// window, the whole file was read and every match is kept. Otherwise, matches
+// starting in the tail window might continue past it, so they're left for
+// whoever searches beyond limit.
+func pastLimit(offset, limit int64, truncated bool) bool {
+	return truncated && offset >= limit
+}
+
+// smallFileSize is the size below which readFileLimit reads a file with a
+// single pre-sized buffer. Most files in a store closure are this small.
+const smallFileSize = 64 << 10 // 64 KiB
+
+// readFileLimit reads the first limit bytes of a file plus a tail window of up
+// to [maxMatchSize] bytes. truncated is true if the file has more data after
+// the tail window.
+func readFileLimit(fsys fs.FS, path string, limit int64) (data []byte, truncated bool, err error) {
 	f, err := fsys.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer f.Close()
 
-	r := &io.LimitedReader{R: f, N: maxFileSize}
-	data, err := io.ReadAll(r)
-	if err != nil {
+	if info, err := f.Stat(); err == nil && info.Mode().IsRegular() &&
+		info.Size() < smallFileSize && info.Size() <= limit {
+		data, err = readSmallFile(f, info.Size())
+		if err != nil {
+			return nil, false, err
+		}
+		if int64(len(data)) <= info.Size() {
+			return data, false, nil
+		}
+		// The file grew after the call to Stat, so read the rest below.
+	}
+	return readLimited(f, data, limit)
+}
+
+// readSmallFile reads a file that's expected to be size bytes long into a
+// single buffer. It reads one extra byte to tell if the file has grown, in
+// which case it returns size+1 bytes.
+func readSmallFile(f fs.File, size int64) ([]byte, error) {
+	buf := make([]byte, size+1)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
 		return nil, err
 	}
+	return buf[:n], nil
+}
+
+// readLimited is the general case of readFileLimit. It appends the rest of
+// the first limit+[maxMatchSize] bytes of f to data, which holds anything
+// already read from f.
+func readLimited(f fs.File, data []byte, limit int64) ([]byte, bool, error) {
+	r := &io.LimitedReader{R: f, N: limit + maxMatchSize - int64(len(data))}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+	data = append(data, rest...)
 
-	locs := re.FindAllIndex(data, -1)
-	if len(locs) == 0 {
-		return nil, nil
+	truncated := false
+	if int64(len(data)) > limit {
+		// Check if there's more data past the tail window.
+		n, err := f.Read(make([]byte, 1))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, false, err
+		}
+		truncated = n > 0
 	}
+	return data, truncated, nil
+}
+
+// RemovedRef is a removed Nix store path reference found in a file. See
+// [ScanForRemovedRefs].
+type RemovedRef struct {
+	// Ref is the invalidated store path, such as
+	// eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-python3-3.12.4.
+	Ref string `json:"ref"`
+
+	// Offset is the byte offset of Ref within the file.
+	Offset int64 `json:"offset"`
+
+	// Line and Column are the 1-based position of Ref within the file,
+	// which is easier to find in a text editor than Offset.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// ScanResult is the result of [ScanForRemovedRefs]. It's meant to be printed
+// as JSON, such as for a CI step that fails a build when a package still
+// has removed refs.
+type ScanResult struct {
+	// FilesScanned is the number of files that were searched.
+	FilesScanned int `json:"files_scanned"`
+
+	// Matches are the removed refs found in each file, keyed by path.
+	// Files without any removed refs are omitted.
+	Matches map[string][]RemovedRef `json:"matches"`
+
+	// Clean is true if no removed refs were found.
+	Clean bool `json:"clean"`
+}
+
+// RefCount returns the total number of removed refs in r.
+func (r ScanResult) RefCount() int {
+	n := 0
+	for _, refs := range r.Matches {
+		n += len(refs)
+	}
+	return n
+}
+
+// ScanOptions configure [ScanForRemovedRefs].
+type ScanOptions struct {
+	// SkipBinaries skips searching ELF binaries. Refs are usually removed
+	// from binaries on purpose to reduce a package's closure size.
+	SkipBinaries bool
+
+	// DecodeUTF16 decodes files that start with a UTF-16 byte order mark
+	// before searching them. Otherwise, UTF-16 files are searched as raw
+	// bytes, which won't find their refs, and a warning is logged for each
+	// one.
+	DecodeUTF16 bool
+}
+
+// ScanForRemovedRefs walks the directory tree rooted at root and searches each
+// regular file for store path references that were removed with Nix's
+// removeReferencesTo. It returns the references found in each file along with
+// the number of files it searched.
+//
+// Like [searchFile], it only searches the first [maxFileSize] bytes of each
+// file.
+func ScanForRemovedRefs(ctx context.Context, fsys fs.FS, root string, opts ScanOptions) (ScanResult, error) {
+	report := ScanResult{Matches: make(map[string][]RemovedRef)}
+	for path, entry := range allFiles(fsys, root) {
+		if ctx.Err() != nil {
+			return ScanResult{}, ctx.Err()
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if opts.SkipBinaries {
+			binary, err := isELFFile(fsys, path)
+			if err != nil {
+				return ScanResult{}, err
+			}
+			if binary {
+				continue
+			}
+		}
 
-	matches := make([]fileSlice, len(locs))
-	for i := range locs {
-		start, end := locs[i][0], locs[i][1]
-		matches[i] = fileSlice{
-			path:   path,
-			data:   data[start:end],
-			offset: int64(start),
+		search := searchFile
+		if opts.DecodeUTF16 {
+			search = searchFileUTF16
+		}
+		result, err := search(fsys, path, reRemovedRefs)
+		if err != nil {
+			return ScanResult{}, err
+		}
+		report.FilesScanned++
+		if result.truncated {
+			slog.WarnContext(ctx, "file is too large to search for all removed store refs", "path", path, "limit", maxFileSize)
+		}
+		if encoding, _ := detectUTF16(result.data); encoding != "" && result.encoding == "" {
+			slog.WarnContext(ctx, "file is UTF-16 and wasn't decoded, so removed store refs in it weren't found", "path", path, "encoding", encoding)
+		}
+		for _, match := range result.matches {
+			line, col := result.lineNumber(match)
+			report.Matches[path] = append(report.Matches[path], RemovedRef{
+				Ref:    string(match.data),
+				Offset: match.offset,
+				Line:   line,
+				Column: col,
+			})
 		}
 	}
-	return matches, nil
+	report.Clean = len(report.Matches) == 0
+	return report, nil
+}
+
+func isELFFile(fsys fs.FS, path string) (bool, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isELF(bufio.NewReaderSize(f, 16)), nil
 }
 
 var envValues = sync.OnceValue(func() []string {
@@ -87,11 +391,35 @@ func searchEnv(re *regexp.Regexp) string {
 // searchGlobs iterates over the paths matched by multiple [filepath.Glob]
 // patterns. It will not yield a path more than once, even if the path matches
 // multiple patterns. It silently ignores any pattern syntax errors.
-func searchGlobs(patterns []string) iter.Seq[string] {
-	seen := make(map[string]bool, len(patterns))
+//
+// It skips paths that match any of the exclude patterns (see [globExcluded]),
+// so callers don't have to search files they know aren't relevant.
+func searchGlobs(patterns, exclude []string) iter.Seq[string] {
+	return searchGlobsFunc(patterns, exclude, filepath.Glob)
+}
+
+// searchGlobsSorted is like [searchGlobs], but it collects all of the matched
+// paths and returns them in lexical order. Use it when the order must be the
+// same across runs and platforms, such as in reports or golden-file tests.
+// searchGlobs is better for large trees since it doesn't keep every match in
+// memory.
+func searchGlobsSorted(patterns, exclude []string) []string {
+	return slices.Sorted(searchGlobs(patterns, exclude))
+}
+
+// searchGlobsFS is like [searchGlobs], but matches [fs.Glob] patterns against
+// the files in fsys.
+func searchGlobsFS(fsys fs.FS, patterns, exclude []string) iter.Seq[string] {
+	return searchGlobsFunc(patterns, exclude, func(pattern string) ([]string, error) {
+		return fs.Glob(fsys, pattern)
+	})
+}
+
+func searchGlobsFunc(patterns, exclude []string, globFunc func(pattern string) ([]string, error)) iter.Seq[string] {
 	return func(yield func(string) bool) {
+		seen := make(map[string]bool, len(patterns))
 		for _, pattern := range patterns {
-			glob, err := filepath.Glob(pattern)
+			glob, err := globFunc(pattern)
 			if err != nil {
 				continue
 			}
@@ -101,6 +429,9 @@ func searchGlobs(patterns []string) iter.Seq[string] {
 				}
 				seen[match] = true
 
+				if globExcluded(match, exclude) {
+					continue
+				}
 				if !yield(match) {
 					return
 				}
@@ -109,20 +440,45 @@ func searchGlobs(patterns []string) iter.Seq[string] {
 	}
 }
 
-// globEscape escapes all metacharacters ('*', '?', '\\', '[') in s so that they
-// match their literal values in a [filepath.Glob] or [fs.Glob] pattern.
+// globExcluded reports whether name matches any of the exclude patterns,
+// using [path.Match]. A pattern without a slash matches the last element of
+// name, so "*.pyc" excludes Python bytecode in any directory. Other patterns
+// must match all of name. Like the include patterns, it ignores any pattern
+// syntax errors.
+func globExcluded(name string, exclude []string) bool {
+	for _, pattern := range exclude {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globEscape escapes all metacharacters ('*', '?', '\\', '[', ']', '-', '{',
+// '}') in s so that they match their literal values in a [filepath.Glob] or
+// [fs.Glob] pattern. filepath.Match only treats ']' and '-' specially inside a
+// character class and never treats braces specially, but escaping them keeps
+// the pattern literal for glob dialects that do (such as doublestar).
+//
+// It operates on bytes instead of runes so that invalid UTF-8 in s is
+// preserved as-is. All of the metacharacters are ASCII, so they can't appear
+// within a multi-byte sequence.
 func globEscape(s string) string {
-	if !strings.ContainsAny(s, `*?\[`) {
+	const meta = `*?\[]-{}`
+	if !strings.ContainsAny(s, meta) {
 		return s
 	}
 
 	b := make([]byte, 0, len(s)+1)
-	for _, r := range s {
-		switch r {
-		case '*', '?', '\\', '[':
+	for i := range len(s) {
+		if strings.IndexByte(meta, s[i]) >= 0 {
 			b = append(b, '\\')
 		}
-		b = utf8.AppendRune(b, r)
+		b = append(b, s[i])
 	}
 	return string(b)
 }
@@ -0,0 +1,588 @@
+package patchpkg
+
+import (
+	"context"
+	"encoding/binary"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var globEscapeTests = []string{
+	"",
+	"plain",
+	"star*",
+	"question?",
+	`back\slash`,
+	"[bracket",
+	"bracket]",
+	"[a-z]",
+	"dash-name",
+	"{brace}",
+	"{a,b}",
+	"lib/python3.12/site-packages/foo-1.0.dist-info",
+	"\xff\xfeinvalid utf-8",
+}
+
+func TestGlobEscape(t *testing.T) {
+	for _, name := range globEscapeTests {
+		pattern := globEscape(name)
+		matched, err := filepath.Match(pattern, name)
+		if err != nil {
+			t.Errorf("filepath.Match(globEscape(%q)) error: %v", name, err)
+			continue
+		}
+		if !matched {
+			t.Errorf("filepath.Match(%q, %q) = false, want true", pattern, name)
+		}
+	}
+}
+
+func TestGlobEscapeFiles(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"a]", "a{b}", "a-b", "a[b]", "ab"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range names {
+		pattern := filepath.Join(globEscape(dir), globEscape(name))
+		got := slices.Collect(searchGlobs([]string{pattern}, nil))
+		want := []string{filepath.Join(dir, name)}
+		if !slices.Equal(got, want) {
+			t.Errorf("searchGlobs(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestSearchGlobsSorted(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"lib/libz.so", "lib/liba.so.1", "bin/python3", "lib/liba.so"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := globEscape(dir)
+	patterns := []string{
+		filepath.Join(root, "lib", "*.so*"),
+		filepath.Join(root, "bin", "*"),
+		filepath.Join(root, "lib", "liba.so"), // already matched by the first pattern
+	}
+	got := searchGlobsSorted(patterns, nil)
+	want := []string{
+		filepath.Join(dir, "bin/python3"),
+		filepath.Join(dir, "lib/liba.so"),
+		filepath.Join(dir, "lib/liba.so.1"),
+		filepath.Join(dir, "lib/libz.so"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("searchGlobsSorted() = %q, want %q", got, want)
+	}
+}
+
+func FuzzGlobEscape(f *testing.F) {
+	for _, name := range globEscapeTests {
+		f.Add(name)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		pattern := globEscape(name)
+		matched, err := filepath.Match(pattern, name)
+		if err != nil {
+			t.Fatalf("filepath.Match(globEscape(%q)) error: %v", name, err)
+		}
+		if !matched {
+			t.Fatalf("filepath.Match(%q, %q) = false, want true", pattern, name)
+		}
+
+		// The pattern must match name exactly, not a longer string.
+		matched, _ = filepath.Match(pattern, name+"x")
+		if matched {
+			t.Fatalf("filepath.Match(%q, %q) = true, want false", pattern, name+"x")
+		}
+	})
+}
+
+func TestSearchFileLimit(t *testing.T) {
+	ref := "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-python3-3.12.4"
+	tests := []struct {
+		name          string
+		data          string
+		limit         int64
+		wantMatches   []string
+		wantTruncated bool
+	}{
+		{
+			name:        "WithinLimit",
+			data:        "prefix " + ref + " suffix",
+			limit:       1024,
+			wantMatches: []string{ref},
+		},
+		{
+			name:        "StraddlesLimit",
+			data:        "prefix " + ref,
+			limit:       int64(len("prefix ") + 5),
+			wantMatches: []string{ref},
+		},
+		{
+			// The file ends inside the tail window, so it was read
+			// entirely and the match must not be dropped.
+			name:        "StartsAfterLimit",
+			data:        "prefix " + ref,
+			limit:       3,
+			wantMatches: []string{ref},
+		},
+		{
+			name:          "StartsAfterLimitTruncated",
+			data:          strings.Repeat("x", 110) + ref + strings.Repeat("x", maxMatchSize),
+			limit:         100,
+			wantTruncated: true,
+		},
+		{
+			name:          "TruncatedAfterTail",
+			data:          "prefix " + ref + " " + strings.Repeat("x", maxMatchSize),
+			limit:         int64(len("prefix ") + 1),
+			wantMatches:   []string{ref},
+			wantTruncated: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"file": &fstest.MapFile{Data: []byte(test.data)}}
+			got, err := searchFileLimit(fsys, "file", reRemovedRefs, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotMatches []string
+			for _, m := range got.matches {
+				gotMatches = append(gotMatches, string(m.data))
+			}
+			if !slices.Equal(gotMatches, test.wantMatches) {
+				t.Errorf("got matches %q, want %q", gotMatches, test.wantMatches)
+			}
+			if got.truncated != test.wantTruncated {
+				t.Errorf("got truncated = %v, want %v", got.truncated, test.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestScanForRemovedRefs(t *testing.T) {
+	ref := "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-python3-3.12.4"
+	fsys := fstest.MapFS{
+		"lib/python3.12/_sysconfigdata.py": &fstest.MapFile{Data: []byte(`PREFIX = "/nix/store/` + ref + `"`)},
+		"lib/clean.py":                     &fstest.MapFile{Data: []byte("print('hello')")},
+		"bin/python3":                      &fstest.MapFile{Data: []byte("\x7fELF\x00" + ref + " ")},
+	}
+
+	got, err := ScanForRemovedRefs(context.Background(), fsys, ".", ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]RemovedRef{
+		"lib/python3.12/_sysconfigdata.py": {{Ref: ref, Offset: int64(len(`PREFIX = "/nix/store/`)), Line: 1, Column: 22}},
+		"bin/python3":                      {{Ref: ref, Offset: 5, Line: 1, Column: 6}},
+	}
+	if !maps.EqualFunc(got.Matches, want, slices.Equal) {
+		t.Errorf("got report %v, want %v", got.Matches, want)
+	}
+	if got.FilesScanned != 3 || got.Clean || got.RefCount() != 2 {
+		t.Errorf("got %d files scanned, clean %t and %d refs, want 3, false and 2",
+			got.FilesScanned, got.Clean, got.RefCount())
+	}
+
+	got, err = ScanForRemovedRefs(context.Background(), fsys, ".", ScanOptions{SkipBinaries: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "bin/python3")
+	if !maps.EqualFunc(got.Matches, want, slices.Equal) {
+		t.Errorf("got report with skipBinaries %v, want %v", got.Matches, want)
+	}
+	if got.FilesScanned != 2 {
+		t.Errorf("got %d files scanned with skipBinaries, want 2", got.FilesScanned)
+	}
+
+	got, err = ScanForRemovedRefs(context.Background(), fsys, "lib/clean.py", ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Clean || got.FilesScanned != 1 || len(got.Matches) != 0 {
+		t.Errorf("got report %+v for a clean file, want a clean report for 1 file", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ScanForRemovedRefs(ctx, fsys, ".", ScanOptions{}); err != context.Canceled {
+		t.Errorf("got error %v with canceled context, want %v", err, context.Canceled)
+	}
+}
+
+func TestSearchGlobsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/libfoo.so":     &fstest.MapFile{},
+		"lib/libfoo.so.1":   &fstest.MapFile{},
+		"lib/libbar.so":     &fstest.MapFile{},
+		"lib/[weird]-name":  &fstest.MapFile{},
+		"share/doc/foo.txt": &fstest.MapFile{},
+	}
+	patterns := []string{
+		"lib/libfoo.so*",
+		"lib/*.so", // overlaps with the first pattern
+		"lib/[",    // syntax error, ignored
+		"lib/" + globEscape("[weird]-name"),
+		"missing/*", // no matches
+	}
+
+	seq := searchGlobsFS(fsys, patterns, nil)
+	want := []string{"lib/libfoo.so", "lib/libfoo.so.1", "lib/libbar.so", "lib/[weird]-name"}
+	if got := slices.Collect(seq); !slices.Equal(got, want) {
+		t.Errorf("searchGlobsFS() = %q, want %q", got, want)
+	}
+	// The iterator should be reusable.
+	if got := slices.Collect(seq); !slices.Equal(got, want) {
+		t.Errorf("second iteration of searchGlobsFS() = %q, want %q", got, want)
+	}
+}
+
+func TestSearchGlobsExclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"bin/python3":                       &fstest.MapFile{},
+		"bin/pip":                           &fstest.MapFile{},
+		"bin/__pycache__/pip.cpython.pyc":   &fstest.MapFile{},
+		"bin/tool.pyc":                      &fstest.MapFile{},
+		"lib/libpython3.so":                 &fstest.MapFile{},
+		"lib/python3.12/config/Makefile":    &fstest.MapFile{},
+		"lib/python3.12/config/libpython.a": &fstest.MapFile{},
+	}
+	tests := []struct {
+		name     string
+		patterns []string
+		exclude  []string
+		want     []string
+	}{
+		{
+			name:     "NoExclude",
+			patterns: []string{"bin/*"},
+			want:     []string{"bin/__pycache__", "bin/pip", "bin/python3", "bin/tool.pyc"},
+		},
+		{
+			name:     "BaseName",
+			patterns: []string{"bin/*", "bin/*/*"},
+			exclude:  []string{"*.pyc", "__pycache__"},
+			want:     []string{"bin/pip", "bin/python3"},
+		},
+		{
+			name:     "FullPath",
+			patterns: []string{"lib/*", "lib/*/*/*"},
+			exclude:  []string{"lib/python3.12/config/*"},
+			want:     []string{"lib/libpython3.so", "lib/python3.12"},
+		},
+		{
+			// A path that's excluded is still only considered once,
+			// even if several include patterns match it.
+			name:     "Overlap",
+			patterns: []string{"bin/p*", "bin/*", "bin/pip"},
+			exclude:  []string{"pip"},
+			want:     []string{"bin/python3", "bin/__pycache__", "bin/tool.pyc"},
+		},
+		{
+			name:     "SyntaxError",
+			patterns: []string{"bin/pip"},
+			exclude:  []string{"["},
+			want:     []string{"bin/pip"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := slices.Collect(searchGlobsFS(fsys, test.patterns, test.exclude))
+			if !slices.Equal(got, test.want) {
+				t.Errorf("searchGlobsFS(%q, %q) = %q, want %q", test.patterns, test.exclude, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCountMatches(t *testing.T) {
+	ref := "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-python3-3.12.4"
+	fsys := fstest.MapFS{
+		"none": &fstest.MapFile{Data: []byte("print('hello')")},
+		"one":  &fstest.MapFile{Data: []byte("prefix " + ref + " suffix")},
+		"many": &fstest.MapFile{Data: []byte(strings.Repeat(ref+"\n", 3))},
+	}
+	for path, want := range map[string]int{"none": 0, "one": 1, "many": 3} {
+		got, err := countMatches(fsys, path, reRemovedRefs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("countMatches(%q) = %d, want %d", path, got, want)
+		}
+	}
+
+	if _, err := countMatches(fsys, "missing", reRemovedRefs); err == nil {
+		t.Error("countMatches(missing) returned nil error")
+	}
+}
+
+func TestReadFileLimitSmallFile(t *testing.T) {
+	data := "prefix eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-python3-3.12.4 suffix"
+	fsys := fstest.MapFS{
+		"small": &fstest.MapFile{Data: []byte(data)},
+		"empty": &fstest.MapFile{},
+	}
+	got, truncated, err := readFileLimit(fsys, "small", maxFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data || truncated {
+		t.Errorf("got %q, truncated = %v, want %q, truncated = false", got, truncated, data)
+	}
+
+	got, truncated, err = readFileLimit(fsys, "empty", maxFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 || truncated {
+		t.Errorf("got %q, truncated = %v, want empty data, truncated = false", got, truncated)
+	}
+}
+
+// BenchmarkReadSmallFiles compares reading a directory of small files with a
+// pre-sized buffer against the general io.LimitedReader path.
+func BenchmarkReadSmallFiles(b *testing.B) {
+	dir := b.TempDir()
+	const numFiles = 2000
+	content := []byte(strings.Repeat("#!/bin/sh\nexec /nix/store/eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-bash-5.2/bin/bash\n", 16))
+	for i := range numFiles {
+		name := filepath.Join(dir, "file"+strconv.Itoa(i))
+		if err := os.WriteFile(name, content, 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	fsys := os.DirFS(dir)
+
+	benchmarks := []struct {
+		name string
+		read func(f fs.File, size int64) error
+	}{
+		{"PreSized", func(f fs.File, size int64) error {
+			_, err := readSmallFile(f, size)
+			return err
+		}},
+		{"LimitedReader", func(f fs.File, size int64) error {
+			_, _, err := readLimited(f, nil, maxFileSize)
+			return err
+		}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(numFiles * int64(len(content)))
+			for range b.N {
+				for i := range numFiles {
+					f, err := fsys.Open("file" + strconv.Itoa(i))
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := bm.read(f, int64(len(content))); err != nil {
+						b.Fatal(err)
+					}
+					f.Close()
+				}
+			}
+		})
+	}
+}
+
+func TestFileSliceLineNumber(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		offset   int64
+		wantLine int
+		wantCol  int
+	}{
+		{name: "Start", data: "ref", offset: 0, wantLine: 1, wantCol: 1},
+		{name: "FirstLine", data: "abc ref", offset: 4, wantLine: 1, wantCol: 5},
+		{name: "LF", data: "one\ntwo\nab ref", offset: 11, wantLine: 3, wantCol: 4},
+		{name: "CRLF", data: "one\r\ntwo\r\nab ref", offset: 13, wantLine: 3, wantCol: 4},
+		{name: "StartOfLine", data: "one\nref", offset: 4, wantLine: 2, wantCol: 1},
+		{name: "MultiByte", data: "x\n\u00e9\u00e9 ref", offset: 7, wantLine: 2, wantCol: 4},
+		{name: "InvalidUTF8", data: "\xff\xfe ref", offset: 3, wantLine: 1, wantCol: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slice := fileSlice{path: "file", offset: test.offset}
+			line, col := slice.LineNumber([]byte(test.data))
+			if line != test.wantLine || col != test.wantCol {
+				t.Errorf("got %d:%d, want %d:%d", line, col, test.wantLine, test.wantCol)
+			}
+		})
+	}
+}
+
+func TestSearchFileUTF16(t *testing.T) {
+	ref := "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-python3-3.12.4"
+	text := "[paths]\r\nhome = é/nix/store/" + ref + "\r\n"
+	index := strings.Index(text, ref)
+
+	tests := []struct {
+		name     string
+		bom      []byte
+		order    binary.AppendByteOrder
+		encoding string
+	}{
+		{name: "LittleEndian", bom: []byte{0xFF, 0xFE}, order: binary.LittleEndian, encoding: encodingUTF16LE},
+		{name: "BigEndian", bom: []byte{0xFE, 0xFF}, order: binary.BigEndian, encoding: encodingUTF16BE},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := test.bom
+			for _, u := range utf16.Encode([]rune(text)) {
+				data = test.order.AppendUint16(data, u)
+			}
+			fsys := fstest.MapFS{"config.ini": &fstest.MapFile{Data: data}}
+
+			raw, err := searchFile(fsys, "config.ini", reRemovedRefs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(raw.matches) != 0 {
+				t.Errorf("got %d raw matches in UTF-16 file, want 0", len(raw.matches))
+			}
+
+			got, err := searchFileUTF16(fsys, "config.ini", reRemovedRefs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.encoding != test.encoding {
+				t.Errorf("got encoding %q, want %q", got.encoding, test.encoding)
+			}
+			if len(got.matches) != 1 {
+				t.Fatalf("got %d matches, want 1", len(got.matches))
+			}
+			match := got.matches[0]
+			if string(match.data) != ref {
+				t.Errorf("got match data %q, want %q", match.data, ref)
+			}
+
+			// Every rune before the match is in the BMP, so each one is
+			// a single 2-byte code unit.
+			wantOffset := int64(2 + 2*utf8.RuneCountInString(text[:index]))
+			if match.offset != wantOffset {
+				t.Errorf("got match offset %d, want %d", match.offset, wantOffset)
+			}
+			line, col := got.lineNumber(match)
+			if line != 2 || col != 20 {
+				t.Errorf("got %d:%d, want 2:20", line, col)
+			}
+
+			report, err := ScanForRemovedRefs(context.Background(), fsys, ".", ScanOptions{DecodeUTF16: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string][]RemovedRef{
+				"config.ini": {{Ref: ref, Offset: wantOffset, Line: 2, Column: 20}},
+			}
+			if !maps.EqualFunc(report.Matches, want, slices.Equal) {
+				t.Errorf("got report %v, want %v", report.Matches, want)
+			}
+		})
+	}
+}
+
+func TestSearchFileSubmatches(t *testing.T) {
+	fsys := fstest.MapFS{
+		"file": &fstest.MapFile{Data: []byte(`a=/nix/store/eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-zlib-1.3/lib b=1`)},
+	}
+
+	result, err := searchFile(fsys, "file", reRemovedRefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(result.matches))
+	}
+	got := result.matches[0].submatches
+	if len(got) != 1 || string(got[0]) != "zlib-1.3" {
+		t.Errorf("got submatches %q, want [zlib-1.3]", got)
+	}
+
+	// Groups that don't take part in a match are nil.
+	re := regexp.MustCompile(`([a-z])=(/nix)?(\d)?`)
+	result, err = searchFile(fsys, "file", re)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"a", "/nix", ""}, {"b", "", "1"}}
+	if len(result.matches) != len(want) {
+		t.Fatalf("got %d matches, want %d", len(result.matches), len(want))
+	}
+	for i, match := range result.matches {
+		for j, group := range match.submatches {
+			if string(group) != want[i][j] || (group == nil) != (want[i][j] == "") {
+				t.Errorf("got group %d of match %d = %q, want %q", j+1, i, group, want[i][j])
+			}
+		}
+	}
+
+	// Regular expressions without groups don't have submatches.
+	result, err = searchFile(fsys, "file", regexp.MustCompile(`b=1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.matches) != 1 || result.matches[0].submatches != nil {
+		t.Errorf("got matches %v, want one match without submatches", result.matches)
+	}
+}
+
+func TestSearchFileMax(t *testing.T) {
+	data := []byte(strings.Repeat("x ", 10000))
+	fsys := fstest.MapFS{"file": &fstest.MapFile{Data: data}}
+	re := regexp.MustCompile(`x`)
+
+	result, err := searchFileMax(fsys, "file", re, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for _, match := range result.matches {
+		offsets = append(offsets, match.offset)
+	}
+	if want := []int64{0, 2, 4}; !slices.Equal(offsets, want) {
+		t.Errorf("got match offsets %v, want %v", offsets, want)
+	}
+
+	result, err = searchFileMax(fsys, "file", re, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.matches) != 10000 {
+		t.Errorf("got %d matches with no maximum, want 10000", len(result.matches))
+	}
+
+	// Stopping at the first match must not allocate for the other
+	// matches.
+	allocs := testing.AllocsPerRun(10, func() {
+		searchData("file", data, false, re, maxFileSize, 1)
+	})
+	if allocs > 10 {
+		t.Errorf("got %v allocations for one match, want at most 10", allocs)
+	}
+}
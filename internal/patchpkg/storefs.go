@@ -0,0 +1,66 @@
+package patchpkg
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// storeFS is a read-only view of a tree of files in the Nix store. It wraps
+// another fs.FS, such as an [os.DirFS] of a store path or an
+// [fstest.MapFS] in tests, and records the files that were opened so that
+// it's possible to see what a patch actually read.
+//
+// The files it opens only have the methods of [fs.File] and
+// [fs.ReadDirFile], so code that's given a storeFS can't write to the store
+// through a type assertion to *os.File.
+type storeFS struct {
+	fsys fs.FS
+
+	mu    sync.Mutex
+	reads []string
+}
+
+// newStoreFS returns a storeFS that reads files from fsys.
+func newStoreFS(fsys fs.FS) *storeFS {
+	return &storeFS{fsys: fsys}
+}
+
+// Open opens the named file for reading.
+func (s *storeFS) Open(name string) (fs.File, error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.reads = append(s.reads, name)
+	s.mu.Unlock()
+	slog.Debug("opened nix store file", "path", name)
+	return &storeFile{File: f, name: name}, nil
+}
+
+// Reads returns the names of the files that were opened, in the order they
+// were opened. A file that was opened more than once is listed each time.
+func (s *storeFS) Reads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.reads)
+}
+
+// storeFile is a read-only file from a storeFS.
+type storeFile struct {
+	fs.File
+	name string
+}
+
+// ReadDir reads the entries of a directory. It lets [fs.WalkDir] and
+// [fs.Glob] work on a storeFS.
+func (f *storeFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not implemented")}
+	}
+	return dir.ReadDir(n)
+}
@@ -0,0 +1,81 @@
+package patchpkg
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestPackageFS returns a packageFS for a package at storePath whose files
+// are in memory, so tests can run the patching pipeline without a Nix store.
+func newTestPackageFS(storePath string, files fstest.MapFS) *packageFS {
+	return &packageFS{FS: newStoreFS(files), storePath: storePath}
+}
+
+func TestStoreFS(t *testing.T) {
+	fsys := newStoreFS(fstest.MapFS{
+		"bin/hello":       &fstest.MapFile{Data: []byte("#!/bin/sh\necho hello\n"), Mode: 0o755},
+		"lib/libhello.so": &fstest.MapFile{Data: []byte("\x7fELF")},
+	})
+	if err := fstest.TestFS(fsys, "bin/hello", "lib/libhello.so"); err != nil {
+		t.Fatal(err)
+	}
+
+	before := len(fsys.Reads())
+	f, err := fsys.Open("bin/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(io.Writer); ok {
+		t.Error("got a file that implements io.Writer, want a read-only file")
+	}
+	if got := fsys.Reads()[before:]; !slices.Equal(got, []string{"bin/hello"}) {
+		t.Errorf("got reads %v after opening bin/hello, want [bin/hello]", got)
+	}
+}
+
+func TestRestoreMissingRefsMapFS(t *testing.T) {
+	removed := "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-ncurses-6.4"
+	restored := "0123456789abcdfghijklmnpqrsvwxyz-ncurses-6.4"
+	sysconfig := "lib/python3.12/_sysconfigdata__linux_x86_64-linux-gnu.py"
+	prefix := `LIBS = "-L/nix/store/`
+	pkg := newTestPackageFS("/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-python3-3.12.4", fstest.MapFS{
+		sysconfig: &fstest.MapFile{Data: []byte(prefix + removed + `/lib"`)},
+	})
+
+	// The hash of a removed ref is restored from a store path in the
+	// environment of the build.
+	origEnvValues := envValues
+	envValues = func() []string { return []string{"/nix/store/" + restored + "/lib"} }
+	t.Cleanup(func() { envValues = origEnvValues })
+
+	store := t.TempDir()
+	out := newPackageFS(filepath.Join(store, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-python3-3.12.4"))
+	d := &DerivationBuilder{AllowedPrefixes: []string{store}}
+	ctx := context.Background()
+	if err := d.restoreMissingRefs(ctx, pkg); err != nil {
+		t.Fatalf("got restoreMissingRefs error: %v", err)
+	}
+	if err := d.copyDir(out, filepath.Dir(sysconfig)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.copyFile(ctx, pkg, out, sysconfig); err != nil {
+		t.Fatalf("got copyFile error: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(out.storePath, sysconfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := prefix + restored + `/lib"`; string(got) != want {
+		t.Errorf("got patched file %q, want %q", got, want)
+	}
+	if reads := pkg.FS.(*storeFS).Reads(); !slices.Contains(reads, sysconfig) {
+		t.Errorf("got reads %v, want them to include %s", reads, sysconfig)
+	}
+}
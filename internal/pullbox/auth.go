@@ -0,0 +1,122 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package pullbox
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+)
+
+const (
+	// pullTokenEnv names the environment variable with a bearer token to
+	// send when pulling a config over HTTPS, for configs behind
+	// authentication.
+	pullTokenEnv = "DEVBOX_PULL_TOKEN"
+
+	// pullTokenHostEnv names the environment variable with the only host
+	// that the token in pullTokenEnv is sent to.
+	pullTokenHostEnv = "DEVBOX_PULL_TOKEN_HOST"
+)
+
+// authorize adds credentials to a pull request. A token in DEVBOX_PULL_TOKEN
+// is sent as a bearer token if the request's host is DEVBOX_PULL_TOKEN_HOST.
+// Otherwise, the login and password of a matching machine in the user's
+// netrc file are sent using basic auth. Credentials are never sent over
+// plain HTTP.
+func authorize(req *http.Request) {
+	if req.URL.Scheme != "https" {
+		return
+	}
+	token := os.Getenv(pullTokenEnv)
+	if token != "" && req.URL.Hostname() == os.Getenv(pullTokenHostEnv) {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return
+	}
+	if login, password, ok := netrcCredentials(req.URL.Hostname()); ok {
+		req.SetBasicAuth(login, password)
+	}
+}
+
+// checkAuth returns a user error if the server rejected a pull request's
+// credentials, or the lack of them.
+func checkAuth(res *http.Response, url string) error {
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		if res.Request != nil && res.Request.Header.Get("Authorization") != "" {
+			return usererr.New("Authentication failed for %s. Check the token in %s or your netrc file.", url, pullTokenEnv)
+		}
+		return usererr.New(
+			"Authentication required for %s. Set %s and %s, or add the host to your netrc file. Credentials are only sent over HTTPS.",
+			url, pullTokenEnv, pullTokenHostEnv,
+		)
+	case http.StatusForbidden:
+		return usererr.New("Authentication failed for %s: access denied. Check that your credentials can read it.", url)
+	}
+	return nil
+}
+
+// netrcCredentials looks up host in the netrc file at $NETRC, or ~/.netrc by
+// default. It falls back to a "default" entry if there is one.
+func netrcCredentials(host string) (login, password string, ok bool) {
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", false
+	}
+	defer f.Close()
+
+	type entry struct{ login, password string }
+	var (
+		matched, fallback *entry
+		current           *entry
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanWords)
+scan:
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "machine":
+			current = nil
+			if scanner.Scan() && scanner.Text() == host && matched == nil {
+				matched = &entry{}
+				current = matched
+			}
+		case "default":
+			current = nil
+			if fallback == nil {
+				fallback = &entry{}
+				current = fallback
+			}
+		case "login":
+			if scanner.Scan() && current != nil {
+				current.login = scanner.Text()
+			}
+		case "password":
+			if scanner.Scan() && current != nil {
+				current.password = scanner.Text()
+			}
+		case "macdef":
+			// Macro definitions run until a blank line, which a word scanner
+			// can't see, so stop here rather than misread them as entries.
+			break scan
+		}
+	}
+	for _, e := range []*entry{matched, fallback} {
+		if e != nil && e.password != "" {
+			return e.login, e.password, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,131 @@
+package pullbox
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.jetpack.io/devbox/internal/devbox/devopt"
+)
+
+func TestPullAuthenticatedURL(t *testing.T) {
+	config := `{"packages": ["hello"]}`
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer secret":
+			io.WriteString(w, config) //nolint:errcheck
+		case "":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(server.Close)
+	useTestClient(t, server)
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv(pullTokenHostEnv, "127.0.0.1")
+
+	pull := func(token string) error {
+		t.Setenv(pullTokenEnv, token)
+		var out bytes.Buffer
+		return New(testProject(t.TempDir()), &out, devopt.PullboxOpts{
+			URL: server.URL + "/devbox.json",
+		}).Pull(context.Background())
+	}
+
+	if err := pull(""); err == nil || !strings.Contains(err.Error(), "Authentication required") {
+		t.Errorf("got error %v pulling without a token, want authentication required", err)
+	}
+	if err := pull("wrong"); err == nil || !strings.Contains(err.Error(), "Authentication failed") {
+		t.Errorf("got error %v pulling with a wrong token, want authentication failed", err)
+	}
+	if err := pull("secret"); err != nil {
+		t.Errorf("got error %v pulling with a valid token", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	if err := os.WriteFile(netrc, []byte("machine netrc.example.com login alice password s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", netrc)
+	t.Setenv(pullTokenEnv, "secret")
+	t.Setenv(pullTokenHostEnv, "token.example.com")
+
+	tests := []struct {
+		url, auth string
+	}{
+		{"https://token.example.com/devbox.json", "Bearer secret"},
+		{"https://other.example.com/devbox.json", ""},
+		{"https://netrc.example.com/devbox.json", "Basic YWxpY2U6czNjcmV0"},
+		{"http://token.example.com/devbox.json", ""},
+		{"http://netrc.example.com/devbox.json", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.url, nil)
+		authorize(req)
+		if got := req.Header.Get("Authorization"); got != test.auth {
+			t.Errorf("authorize(%q) set Authorization %q, want %q", test.url, got, test.auth)
+		}
+	}
+}
+
+func TestPullTokenNotSentToOtherHosts(t *testing.T) {
+	var gotAuth string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		io.WriteString(w, `{"packages": ["hello"]}`) //nolint:errcheck
+	}))
+	t.Cleanup(server.Close)
+	useTestClient(t, server)
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv(pullTokenEnv, "secret")
+	t.Setenv(pullTokenHostEnv, "token.example.com")
+
+	if _, err := download(context.Background(), server.URL+"/devbox.json"); err != nil {
+		t.Fatal(err)
+	}
+	if gotAuth != "" {
+		t.Errorf("got Authorization %q on a request to %s, want none", gotAuth, server.URL)
+	}
+}
+
+// useTestClient makes pull requests trust server's certificate until the test
+// ends.
+func useTestClient(t *testing.T, server *httptest.Server) {
+	t.Helper()
+	old := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = old })
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	data := "machine example.com login alice password s3cret\n" +
+		"default login anon password guest\n"
+	if err := os.WriteFile(netrc, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", netrc)
+
+	tests := []struct {
+		host, login, password string
+	}{
+		{"example.com", "alice", "s3cret"},
+		{"other.com", "anon", "guest"},
+	}
+	for _, test := range tests {
+		login, password, ok := netrcCredentials(test.host)
+		if !ok || login != test.login || password != test.password {
+			t.Errorf("netrcCredentials(%q) = %q, %q, %v, want %q, %q, true",
+				test.host, login, password, ok, test.login, test.password)
+		}
+	}
+}
@@ -10,42 +10,43 @@
 	"path/filepath"
 
 	"go.jetpack.io/devbox/internal/cuecfg"
-	"go.jetpack.io/devbox/internal/devconfig"
+	"go.jetpack.io/devbox/internal/devconfig/configfile"
 	"go.jetpack.io/devbox/internal/fileutil"
 )
 
-func (p *pullbox) IsTextDevboxConfig() bool {
-	if u, err := url.Parse(p.URL); err == nil {
+func isTextDevboxConfig(rawURL string) bool {
+	if u, err := url.Parse(rawURL); err == nil {
 		ext := filepath.Ext(u.Path)
 		return cuecfg.IsSupportedExtension(ext)
 	}
 	// For invalid URLS, just look at the extension
-	ext := filepath.Ext(p.URL)
+	ext := filepath.Ext(rawURL)
 	return cuecfg.IsSupportedExtension(ext)
 }
 
-func (p *pullbox) pullTextDevboxConfig(ctx context.Context) error {
-	if p.isLocalConfig() {
-		return p.copyToProfile(p.URL)
+// pullTextDevboxConfig downloads a remote config to a temporary directory,
+// authenticating the request like other pulls from a URL.
+func pullTextDevboxConfig(ctx context.Context, rawURL string) (string, error) {
+	data, err := download(ctx, rawURL)
+	if err != nil {
+		return "", err
 	}
-
-	cfg, err := devconfig.LoadConfigFromURL(ctx, p.URL)
+	cfg, err := configfile.LoadBytes(data)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	tmpDir, err := fileutil.CreateDevboxTempDir()
 	if err != nil {
-		return err
+		return "", err
 	}
-	if err = cfg.Root.SaveTo(tmpDir); err != nil {
-		return err
+	if err = cfg.SaveTo(tmpDir); err != nil {
+		return "", err
 	}
-
-	return p.copyToProfile(tmpDir)
+	return tmpDir, nil
 }
 
-func (p *pullbox) isLocalConfig() bool {
-	_, err := os.Stat(p.URL)
+func isLocalConfig(path string) bool {
+	_, err := os.Stat(path)
 	return err == nil
 }
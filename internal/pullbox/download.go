@@ -4,19 +4,31 @@
 package pullbox
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 )
 
+// httpClient sends pull requests. Tests replace it to trust their TLS servers.
+var httpClient = http.DefaultClient
+
 // Download downloads a file from the specified URL
-func download(url string) ([]byte, error) {
-	response, err := http.Get(url)
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req)
+	response, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
+	if err := checkAuth(response, url); err != nil {
+		return nil, err
+	}
 	if response.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("failed to download file: %s", response.Status)
 	}
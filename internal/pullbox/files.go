@@ -4,6 +4,10 @@
 package pullbox
 
 import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"os"
@@ -11,13 +15,27 @@
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/cmdutil"
 	"go.jetpack.io/devbox/internal/devconfig"
 	"go.jetpack.io/devbox/internal/devconfig/configfile"
 	"go.jetpack.io/devbox/internal/fileutil"
+	"go.jetpack.io/devbox/internal/ux"
 )
 
+// copyToProfile replaces the files in the project with the config file or
+// directory at src. The config is installed as devbox.json, whatever its name
+// in src.
 func (p *pullbox) copyToProfile(src string) error {
+	configPath, err := findConfig(src, p.ConfigName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p.configNotFoundError()
+	}
+	if err != nil {
+		return err
+	}
 	srcFileInfo, err := os.Stat(src)
 	if err != nil {
 		return errors.WithStack(err)
@@ -54,9 +72,138 @@ func (p *pullbox) copyToProfile(src string) error {
 			return err
 		}
 	}
+
+	rel := filepath.Base(configPath)
+	if srcFileInfo.IsDir() {
+		if rel, err = filepath.Rel(src, configPath); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if rel == configfile.DefaultName {
+		return nil
+	}
+	return errors.WithStack(os.Rename(
+		filepath.Join(p.ProjectDir(), rel),
+		filepath.Join(p.ProjectDir(), configfile.DefaultName),
+	))
+}
+
+// findConfig returns the path to the config file at path, which is either the
+// config file itself or a directory that contains it. name is the path of the
+// config within a directory, and defaults to devbox.json. It returns an
+// error wrapping fs.ErrNotExist if there's no such file.
+func findConfig(path, name string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+
+	configPath := filepath.Join(path, cmp.Or(name, configfile.DefaultName))
+	info, err = os.Stat(configPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if info.IsDir() {
+		return "", errors.Wrapf(fs.ErrNotExist, "%s is a directory", configPath)
+	}
+	return configPath, nil
+}
+
+func (p *pullbox) configNotFoundError() error {
+	location := cmp.Or(p.URL, "the pulled config")
+	if p.ConfigName != "" {
+		return usererr.New("No config named %s found in %s.", p.ConfigName, location)
+	}
+	return usererr.New(
+		"No %s found in %s. Use --config-name to pull a config with a different file name.",
+		configfile.DefaultName,
+		location,
+	)
+}
+
+// configPackages returns the packages in the config in dir, or nil if there
+// isn't a valid config.
+func configPackages(dir string) []string {
+	cfg, err := devconfig.Open(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, pkg := range cfg.Root.TopLevelPackages() {
+		names = append(names, pkg.VersionedName())
+	}
+	return names
+}
+
+// preview prints the packages of the config at src, which is a config file or
+// a directory like copyToProfile takes, and which of them would be added to
+// or removed from the installed packages.
+func (p *pullbox) preview(src string, installed []string) error {
+	configPath, err := findConfig(src, p.ConfigName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p.configNotFoundError()
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	cfg, err := configfile.LoadBytes(data)
+	if err != nil {
+		return usererr.WithUserMessage(err, "The pulled config %s isn't a valid devbox.json.", filepath.Base(configPath))
+	}
+	var pulled []string
+	for _, pkg := range cfg.TopLevelPackages() {
+		pulled = append(pulled, pkg.VersionedName())
+	}
+	printPreview(p.stderr, installed, pulled)
 	return nil
 }
 
+// printPreview prints the pulled packages and how they differ from the
+// installed ones.
+func printPreview(w io.Writer, installed, pulled []string) {
+	if len(pulled) == 0 {
+		ux.Finfof(w, "The pulled config has no packages\n")
+	} else {
+		ux.Finfof(w, "The pulled config has %d packages:\n", len(pulled))
+		for _, pkg := range pulled {
+			fmt.Fprintf(w, "  %s\n", pkg)
+		}
+	}
+	add, remove := lo.Difference(pulled, installed)
+	if len(add) == 0 && len(remove) == 0 {
+		ux.Finfof(w, "Pulling it wouldn't change your packages\n")
+	}
+	if len(add) > 0 {
+		ux.Finfof(w, "Pulling it would add: %s\n", strings.Join(add, ", "))
+	}
+	if len(remove) > 0 {
+		ux.Finfof(w, "Pulling it would remove: %s\n", strings.Join(remove, ", "))
+	}
+	ux.Finfof(w, "Nothing was changed. Run the command without --preview to pull the config.\n")
+}
+
+// printPulledPackages tells the user which of the pulled packages are new and
+// which were skipped because the previous config already had them.
+func printPulledPackages(w io.Writer, installed, pulled []string) {
+	newPkgs, _ := lo.Difference(pulled, installed)
+	skipped, _ := lo.Difference(pulled, newPkgs)
+	if len(newPkgs) == 0 {
+		ux.Finfof(w, "No new packages to install\n")
+	} else {
+		ux.Finfof(w, "Installing pulled packages: %s\n", strings.Join(newPkgs, ", "))
+	}
+	if len(skipped) > 0 {
+		ux.Finfof(w, "Skipping already-installed: %s\n", strings.Join(skipped, ", "))
+	}
+}
+
 func profileIsNotEmpty(path string) (bool, error) {
 	entries, err := os.ReadDir(path)
 	if err != nil {
@@ -79,12 +226,20 @@ func isModifiedConfig(path string) bool {
 }
 
 // urlIsArchive checks if a file URL points to an archive file
-func urlIsArchive(url string) (bool, error) {
-	response, err := http.Head(url)
+func urlIsArchive(ctx context.Context, url string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, err
+	}
+	authorize(req)
+	response, err := httpClient.Do(req)
 	if err != nil {
 		return false, err
 	}
 	defer response.Body.Close()
+	if err := checkAuth(response, url); err != nil {
+		return false, err
+	}
 	contentType := response.Header.Get("Content-Type")
 	return strings.Contains(contentType, "tar") ||
 		strings.Contains(contentType, "zip") ||
@@ -0,0 +1,67 @@
+package pullbox
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrintPulledPackages(t *testing.T) {
+	var buf bytes.Buffer
+	printPulledPackages(&buf, []string{"hello@latest", "jq@1.7"}, []string{"jq@1.7", "ripgrep@latest", "hello@latest"})
+	got := buf.String()
+	for _, want := range []string{
+		"Installing pulled packages: ripgrep@latest\n",
+		"Skipping already-installed: jq@1.7, hello@latest\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got output %q, want it to contain %q", got, want)
+		}
+	}
+
+	buf.Reset()
+	printPulledPackages(&buf, []string{"hello@latest"}, []string{"hello@latest"})
+	if got := buf.String(); !strings.Contains(got, "No new packages to install\n") {
+		t.Errorf("got output %q, want it to report no new packages", got)
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"devbox.json", "globals.json", "nested/globals.json"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path, name, want string
+	}{
+		{dir, "", filepath.Join(dir, "devbox.json")},
+		{dir, "globals.json", filepath.Join(dir, "globals.json")},
+		{dir, "nested/globals.json", filepath.Join(dir, "nested/globals.json")},
+		{filepath.Join(dir, "globals.json"), "", filepath.Join(dir, "globals.json")},
+	}
+	for _, test := range tests {
+		got, err := findConfig(test.path, test.name)
+		if err != nil {
+			t.Errorf("findConfig(%q, %q) error: %v", test.path, test.name, err)
+		} else if got != test.want {
+			t.Errorf("findConfig(%q, %q) = %q, want %q", test.path, test.name, got, test.want)
+		}
+	}
+
+	for _, name := range []string{"missing.json", "nested"} {
+		if _, err := findConfig(dir, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("findConfig(%q, %q) error = %v, want fs.ErrNotExist", dir, name, err)
+		}
+	}
+}
@@ -4,21 +4,27 @@
 package git
 
 import (
+	"context"
+	"os"
+	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
-	"go.jetpack.io/devbox/internal/cmdutil"
 	"go.jetpack.io/devbox/internal/fileutil"
 )
 
-func CloneToTmp(repo string) (string, error) {
+// CloneToTmp clones repo into a new temporary directory and returns its path.
+// Cancelling ctx interrupts git and removes the partial clone.
+func CloneToTmp(ctx context.Context, repo string) (string, error) {
 	tmpDir, err := fileutil.CreateDevboxTempDir()
 	if err != nil {
 		return "", err
 	}
 
-	if err := clone(repo, tmpDir); err != nil {
+	if err := clone(ctx, repo, tmpDir); err != nil {
+		_ = os.RemoveAll(tmpDir)
 		return "", err
 	}
 	return tmpDir, nil
@@ -30,9 +36,23 @@ func IsRepoURL(url string) bool {
 		(strings.HasPrefix(url, "https://") && strings.HasSuffix(url, ".git"))
 }
 
-func clone(repo, dir string) error {
-	cmd := cmdutil.CommandTTY("git", "clone", repo, dir)
+func clone(ctx context.Context, repo, dir string) error {
+	cmd := exec.CommandContext(ctx, "git", "clone", repo, dir)
 	cmd.Dir = dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Interrupt git instead of killing it so that it can stop its
+	// transport and clean up, and kill it if it doesn't exit soon after.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
 	err := cmd.Run()
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return errors.Wrapf(ctxErr, "git clone %s", repo)
+	}
 	return errors.WithStack(err)
 }
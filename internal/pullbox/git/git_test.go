@@ -0,0 +1,38 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package git
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCloneToTmpCanceled(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	// Make the ssh transport hang so that the clone is still running
+	// when the context is cancelled.
+	t.Setenv("GIT_SSH_COMMAND", "sleep 10 #")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	dir, err := CloneToTmp(ctx, "git@example.com:org/repo.git")
+	if err == nil {
+		t.Fatalf("CloneToTmp with a cancelled context = %q, want an error", dir)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloneToTmp with a cancelled context returned error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("CloneToTmp took %s to return after its context was cancelled", elapsed)
+	}
+
+	if left, _ := filepath.Glob(filepath.Join(tmp, "devbox*")); len(left) > 0 {
+		t.Errorf("CloneToTmp left the partial clone %s behind", left)
+	}
+}
@@ -5,18 +5,14 @@
 
 import (
 	"context"
+	"io"
 	"io/fs"
-	"os"
-	"path/filepath"
 	"runtime/trace"
 
-	"github.com/pkg/errors"
-
 	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/devbox/devopt"
 	"go.jetpack.io/devbox/internal/pullbox/git"
 	"go.jetpack.io/devbox/internal/pullbox/s3"
-	"go.jetpack.io/devbox/internal/pullbox/tar"
 	"go.jetpack.io/devbox/internal/ux"
 )
 
@@ -27,85 +23,71 @@ type devboxProject interface {
 type pullbox struct {
 	devboxProject
 	devopt.PullboxOpts
+
+	// stderr receives all of the messages that pullbox prints.
+	stderr io.Writer
 }
 
-func New(devbox devboxProject, opts devopt.PullboxOpts) *pullbox {
-	return &pullbox{devbox, opts}
+func New(devbox devboxProject, stderr io.Writer, opts devopt.PullboxOpts) *pullbox {
+	return &pullbox{devbox, opts, stderr}
 }
 
-// Pull
-// This can be rewritten to be more readable and less repetitive. Possibly
-// something like:
-// puller := getPullerForURL(url)
-// return puller.Pull()
+// Pull copies the config at p.URL into the project, using the Source
+// registered for the URL's scheme. Without a URL, it pulls the user's config
+// from Jetify Cloud.
 func (p *pullbox) Pull(ctx context.Context) error {
 	defer trace.StartRegion(ctx, "Pull").End()
-	var err error
 
+	// A preview doesn't overwrite anything, so it doesn't need permission.
 	notEmpty, err := profileIsNotEmpty(p.ProjectDir())
 	if err != nil {
 		return err
-	} else if notEmpty && !p.Overwrite {
+	} else if notEmpty && !p.Overwrite && !p.Preview {
 		return fs.ErrExist
 	}
 
 	if p.URL != "" {
-		ux.Finfof(os.Stderr, "Pulling global config from %s\n", p.URL)
+		ux.Finfof(p.stderr, "Pulling global config from %s\n", p.URL)
 	} else {
-		ux.Finfof(os.Stderr, "Pulling global config\n")
+		ux.Finfof(p.stderr, "Pulling global config\n")
 	}
 
-	var tmpDir string
+	// Remember what's installed to report which pulled packages are new.
+	installed := configPackages(p.ProjectDir())
 
+	var path string
 	if p.URL == "" {
 		if p.Credentials.IDToken == "" {
 			return usererr.New("Not logged in")
 		}
 		profile := "default" // TODO: make this editable
-		if tmpDir, err = s3.PullToTmp(ctx, &p.Credentials, profile); err != nil {
-			return err
-		}
-		return p.copyToProfile(tmpDir)
+		path, err = s3.PullToTmp(ctx, p.stderr, &p.Credentials, profile)
+	} else {
+		path, err = sourceForURL(p.URL).Fetch(ctx, p.URL)
 	}
-
-	if git.IsRepoURL(p.URL) {
-		if tmpDir, err = git.CloneToTmp(p.URL); err != nil {
-			return err
-		}
-		// Remove the .git directory, we don't want to keep state
-		if err := os.RemoveAll(filepath.Join(tmpDir, ".git")); err != nil {
-			return errors.WithStack(err)
-		}
-		return p.copyToProfile(tmpDir)
+	if err != nil {
+		return err
 	}
-
-	if p.IsTextDevboxConfig() {
-		return p.pullTextDevboxConfig(ctx)
+	if p.Preview {
+		return p.preview(path, installed)
 	}
-
-	if isArchive, err := urlIsArchive(p.URL); err != nil {
+	if err := p.copyToProfile(path); err != nil {
 		return err
-	} else if isArchive {
-		data, err := download(p.URL)
-		if err != nil {
-			return err
-		}
-
-		if tmpDir, err = tar.Extract(data); err != nil {
-			return err
-		}
-
-		return p.copyToProfile(tmpDir)
+	}
+	if p.NoInstall {
+		ux.Finfof(p.stderr, "Pulled the config without installing its packages. Run `devbox global install` to install them.\n")
+		return nil
 	}
 
-	return usererr.New("Could not determine how to pull %s", p.URL)
+	printPulledPackages(p.stderr, installed, configPackages(p.ProjectDir()))
+	return nil
 }
 
 func (p *pullbox) Push(ctx context.Context) error {
 	if p.URL != "" {
-		ux.Finfof(os.Stderr, "Pushing global config to %s\n", p.URL)
+		ux.Finfof(p.stderr, "Pushing global config to %s\n", p.URL)
 	} else {
-		ux.Finfof(os.Stderr, "Pushing global config\n")
+		ux.Finfof(p.stderr, "Pushing global config\n")
 	}
 
 	if p.URL == "" {
@@ -114,12 +96,12 @@ func (p *pullbox) Push(ctx context.Context) error {
 			return usererr.New("Not logged in")
 		}
 		ux.Finfof(
-			os.Stderr,
+			p.stderr,
 			"Logged in as %s, pushing to to devbox cloud (profile: %s)\n",
 			p.Credentials.Email,
 			profile,
 		)
-		return s3.Push(ctx, &p.Credentials, p.ProjectDir(), profile)
+		return s3.Push(ctx, p.stderr, &p.Credentials, p.ProjectDir(), profile)
 	}
 	return git.Push(ctx, p.ProjectDir(), p.URL)
 }
@@ -6,7 +6,7 @@
 import (
 	"context"
 	"fmt"
-	"os"
+	"io"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -22,6 +22,7 @@
 
 func PullToTmp(
 	ctx context.Context,
+	w io.Writer,
 	creds *devopt.Credentials,
 	profile string,
 ) (string, error) {
@@ -35,7 +36,7 @@ func PullToTmp(
 	buf := manager.WriteAtBuffer{}
 
 	ux.Finfof(
-		os.Stderr,
+		w,
 		"Logged in as %s, pulling from jetify cloud (profile: %s)\n",
 		creds.Email,
 		profile,
@@ -67,7 +68,7 @@ func PullToTmp(
 	}
 
 	ux.Fsuccessf(
-		os.Stderr,
+		w,
 		"Profile successfully pulled (profile: %s)\n",
 		profile,
 	)
@@ -19,6 +19,7 @@
 
 func Push(
 	ctx context.Context,
+	w io.Writer,
 	creds *devopt.Credentials,
 	dir, profile string,
 ) error {
@@ -55,7 +56,7 @@ func Push(
 	}
 
 	ux.Fsuccessf(
-		os.Stderr,
+		w,
 		"Profile successfully pushed (profile: %s)\n",
 		profile,
 	)
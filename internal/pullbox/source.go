@@ -0,0 +1,106 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package pullbox
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
+	"go.jetpack.io/devbox/internal/pullbox/git"
+	"go.jetpack.io/devbox/internal/pullbox/tar"
+)
+
+// A Source fetches the devbox config at a URL. Pull copies the files at the
+// returned path, which is either a single config file or a directory, into
+// the project.
+type Source interface {
+	Fetch(ctx context.Context, url string) (path string, err error)
+}
+
+// SourceFunc adapts an ordinary function to a Source.
+type SourceFunc func(ctx context.Context, url string) (string, error)
+
+func (f SourceFunc) Fetch(ctx context.Context, url string) (string, error) {
+	return f(ctx, url)
+}
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = map[string]Source{
+		"git": SourceFunc(fetchGitRepo),
+	}
+)
+
+// RegisterSource makes Pull use src for URLs with the given scheme, replacing
+// any source already registered for it. URLs with an unregistered scheme
+// (including plain paths) fall back to fetching a config file or an archive.
+func RegisterSource(scheme string, src Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources[scheme] = src
+}
+
+func sourceForURL(rawURL string) Source {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	if src, ok := sources[urlScheme(rawURL)]; ok {
+		return src
+	}
+	return SourceFunc(fetchConfigOrArchive)
+}
+
+// urlScheme returns the scheme that selects the source for a URL. SSH git
+// URLs like git@github.com:org/repo.git don't parse as URLs, so they're
+// reported as "git".
+func urlScheme(rawURL string) string {
+	if git.IsRepoURL(rawURL) {
+		return "git"
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme
+}
+
+func fetchGitRepo(ctx context.Context, url string) (string, error) {
+	tmpDir, err := git.CloneToTmp(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	// Remove the .git directory, we don't want to keep state
+	if err := os.RemoveAll(filepath.Join(tmpDir, ".git")); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return tmpDir, nil
+}
+
+func fetchConfigOrArchive(ctx context.Context, url string) (string, error) {
+	// Local files and directories are copied as is, so a config file can
+	// have any name.
+	if isLocalConfig(url) {
+		return url, nil
+	}
+	if isTextDevboxConfig(url) {
+		return pullTextDevboxConfig(ctx, url)
+	}
+
+	if isArchive, err := urlIsArchive(ctx, url); err != nil {
+		return "", err
+	} else if isArchive {
+		data, err := download(ctx, url)
+		if err != nil {
+			return "", err
+		}
+		return tar.Extract(data)
+	}
+
+	return "", usererr.New("Could not determine how to pull %s", url)
+}
@@ -0,0 +1,189 @@
+package pullbox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.jetpack.io/devbox/internal/devbox/devopt"
+)
+
+type testProject string
+
+func (p testProject) ProjectDir() string { return string(p) }
+
+// failOnStdio replaces os.Stdout and os.Stderr for the duration of the test
+// and fails it if anything writes to them. Pullbox must send all of its output
+// to the writer passed to New so that callers can capture it.
+func failOnStdio(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, std := range []**os.File{&os.Stdout, &os.Stderr} {
+		f, err := os.CreateTemp(dir, "stdio")
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig := *std
+		*std = f
+		t.Cleanup(func() {
+			*std = orig
+			f.Close()
+			data, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) > 0 {
+				t.Errorf("wrote %q directly to stdout or stderr", data)
+			}
+		})
+	}
+}
+
+func TestURLScheme(t *testing.T) {
+	tests := map[string]string{
+		"git@github.com:org/repo.git":        "git",
+		"https://github.com/org/repo.git":    "git",
+		"https://example.com/devbox.json":    "https",
+		"s3://bucket/devbox.json":            "s3",
+		"/home/user/devbox.json":             "",
+		"relative/devbox.json":               "",
+		"https://example.com/archive.tar.gz": "https",
+		"GS://bucket/devbox.json":            "gs",
+	}
+	for url, want := range tests {
+		if got := urlScheme(url); got != want {
+			t.Errorf("urlScheme(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestPullRegisteredSource(t *testing.T) {
+	config := []byte(`{"packages": ["hello"]}`)
+	var fetched string
+	RegisterSource("mem", SourceFunc(func(ctx context.Context, url string) (string, error) {
+		fetched = url
+		dir := t.TempDir()
+		return dir, os.WriteFile(filepath.Join(dir, "devbox.json"), config, 0o644)
+	}))
+	t.Cleanup(func() {
+		sourcesMu.Lock()
+		delete(sources, "mem")
+		sourcesMu.Unlock()
+	})
+
+	failOnStdio(t)
+	project := t.TempDir()
+	var out bytes.Buffer
+	pull := New(testProject(project), &out, devopt.PullboxOpts{URL: "mem://team/global"})
+	if err := pull.Pull(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Pulling global config from mem://team/global", "Installing pulled packages: hello"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", out.String(), want)
+		}
+	}
+	if fetched != "mem://team/global" {
+		t.Errorf("source fetched %q, want %q", fetched, "mem://team/global")
+	}
+	got, err := os.ReadFile(filepath.Join(project, "devbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(config) {
+		t.Errorf("got devbox.json %s, want %s", got, config)
+	}
+}
+
+func TestPullLocalDirConfigName(t *testing.T) {
+	src := t.TempDir()
+	config := []byte(`{"packages": ["hello"]}`)
+	if err := os.WriteFile(filepath.Join(src, "globals.json"), config, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	project := t.TempDir()
+	var out bytes.Buffer
+	pull := New(testProject(project), &out, devopt.PullboxOpts{URL: src})
+	err := pull.Pull(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "--config-name") {
+		t.Errorf("got error %v pulling a directory without a devbox.json, want it to suggest --config-name", err)
+	}
+
+	pull = New(testProject(project), &out, devopt.PullboxOpts{URL: src, ConfigName: "globals.json"})
+	if err := pull.Pull(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(project, "devbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(config) {
+		t.Errorf("got devbox.json %s, want %s", got, config)
+	}
+	if _, err := os.Stat(filepath.Join(project, "globals.json")); err == nil {
+		t.Error("globals.json was copied without being renamed to devbox.json")
+	}
+}
+
+func TestPullPreview(t *testing.T) {
+	src := t.TempDir()
+	pulled := []byte(`{"packages": ["hello", "ripgrep"]}`)
+	if err := os.WriteFile(filepath.Join(src, "devbox.json"), pulled, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	project := t.TempDir()
+	current := []byte(`{"packages": ["hello", "jq"]}`)
+	if err := os.WriteFile(filepath.Join(project, "devbox.json"), current, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	pull := New(testProject(project), &out, devopt.PullboxOpts{URL: src, Preview: true})
+	if err := pull.Pull(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"  hello\n", "  ripgrep\n", "would add: ripgrep", "would remove: jq"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", out.String(), want)
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(project, "devbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(current) {
+		t.Errorf("preview changed devbox.json to %s, want %s", got, current)
+	}
+}
+
+func TestPullNoInstall(t *testing.T) {
+	src := t.TempDir()
+	config := []byte(`{"packages": ["hello"]}`)
+	if err := os.WriteFile(filepath.Join(src, "devbox.json"), config, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	project := t.TempDir()
+	var out bytes.Buffer
+	pull := New(testProject(project), &out, devopt.PullboxOpts{URL: src, NoInstall: true})
+	if err := pull.Pull(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "devbox global install") {
+		t.Errorf("got output %q, want it to suggest installing later", out.String())
+	}
+	if strings.Contains(out.String(), "Installing pulled packages") {
+		t.Errorf("got output %q, want it not to report installing packages", out.String())
+	}
+	got, err := os.ReadFile(filepath.Join(project, "devbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(config) {
+		t.Errorf("got devbox.json %s, want %s", got, config)
+	}
+}
@@ -52,7 +52,10 @@ func newGlobalProcessComposeConfig() *globalProcessComposeConfig {
 }
 
 func globalProcessComposeJSONPath() (string, error) {
-	path := xdg.DataSubpath(filepath.Join("devbox", "global"))
+	path, err := xdg.DataSubpath(filepath.Join("devbox", "global"))
+	if err != nil {
+		return "", err
+	}
 	return filepath.Join(path, "process-compose.json"), errors.WithStack(os.MkdirAll(path, 0o755))
 }
 
@@ -11,7 +11,7 @@ type bash struct{
 _devbox_hook() {
   local previous_exit_status=$?;
   trap -- '' SIGINT;
-  eval "$(devbox shellenv --config {{ .ProjectDir }})";
+  eval "$(devbox shellenv --config {{ quotePath .ProjectDir }})";
   trap - SIGINT;
   return $previous_exit_status;
 };
@@ -20,10 +20,53 @@ type bash struct{
 fi
 `
 
+// bashPromptHook runs after the other prompt commands so that it sees the
+// environment from _devbox_hook. It strips the indicator it added last time
+// before deciding whether to add it again.
+const bashPromptHook = `
+_devbox_prompt() {
+  local previous_exit_status=$?;
+  PS1="${PS1#"${_devbox_prompt_indicator:-}"}";
+  _devbox_prompt_indicator="";
+  if [[ "${DEVBOX_PATH_STACK:-}" == *DEVBOX_NIX_ENV_PATH_* ]]; then
+    _devbox_prompt_indicator="${DEVBOX_PROMPT_INDICATOR-(devbox) }";
+    PS1="$_devbox_prompt_indicator$PS1";
+  fi
+  return $previous_exit_status;
+};
+if ! [[ "${PROMPT_COMMAND:-}" =~ _devbox_prompt ]]; then
+  PROMPT_COMMAND="${PROMPT_COMMAND:+$PROMPT_COMMAND;}_devbox_prompt"
+fi
+`
+
+const bashInitHook = `
+eval "$(devbox shellenv --config {{ quotePath .ProjectDir }})";
+`
+
+func (sh bash) Name() string {
+	return "bash"
+}
+
 func (sh bash) Hook() (string, error) {
 	return bashHook, nil
 }
 
+func (sh bash) InitHook() (string, error) {
+	return bashInitHook, nil
+}
+
+func (sh bash) PromptHook() (string, error) {
+	return bashPromptHook, nil
+}
+
+func (sh bash) SourceCommand(path string) string {
+	return "source " + sh.escape(path)
+}
+
+func (sh bash) QuotePath(path string) string {
+	return sh.escape(path)
+}
+
 func (sh bash) Export(e ShellExport) (out string) {
 	for key, value := range e {
 		if value == nil {
@@ -42,10 +85,20 @@ func (sh bash) Dump(env Env) (out string) {
 	return out
 }
 
+func (sh bash) DumpStructured(env Env) string {
+	return sh.Dump(env)
+}
+
+func (sh bash) HasStructuredDump() bool { return false }
+
 func (sh bash) export(key, value string) string {
 	return "export " + sh.escape(key) + "=" + sh.escape(value) + ";"
 }
 
+func (sh bash) Readonly(key string) (string, bool) {
+	return "readonly " + sh.escape(key) + ";", true
+}
+
 func (sh bash) unset(key string) string {
 	return "unset " + sh.escape(key) + ";"
 }
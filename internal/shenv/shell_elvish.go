@@ -0,0 +1,154 @@
+package shenv
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type elvish struct{}
+
+// Elvish adds support for the elvish shell.
+var Elvish Shell = elvish{}
+
+// elvishHook re-evaluates the environment before each prompt, but only when
+// devbox.json has changed since the last evaluation. Running devbox shellenv
+// on every prompt adds noticeable latency, and stat is far cheaper. Elvish's
+// os:stat doesn't report modification times, so the hook shells out to stat,
+// trying both the GNU and BSD flags. If neither works, it falls back to
+// evaluating on every prompt.
+//
+// Calling a missing external command from before-readline fails without any
+// visible error, so the hook checks for devbox first and warns once if it's
+// not in PATH, such as when the global profile that provides it isn't active.
+const elvishHook = `
+var __devbox_config_mtime = ''
+var __devbox_missing_warned = $false
+set edit:before-readline = [ $@edit:before-readline {
+  if (not (has-external devbox)) {
+    if (not $__devbox_missing_warned) {
+      echo 'devbox: command not found in PATH, so the devbox environment will not update' >&2
+      set __devbox_missing_warned = $true
+    }
+    return
+  }
+  var mtime = ''
+  try {
+    set mtime = (stat -c %Y {{ quotePath (print .ProjectDir "/devbox.json") }} 2>/dev/null)
+  } catch {
+    try {
+      set mtime = (stat -f %m {{ quotePath (print .ProjectDir "/devbox.json") }} 2>/dev/null)
+    } catch { }
+  }
+  if (or (eq $mtime '') (not-eq $mtime $__devbox_config_mtime)) {
+    eval (devbox shellenv --config {{ quotePath .ProjectDir }} | slurp)
+    set __devbox_config_mtime = $mtime
+  }
+} ]
+`
+
+// elvishInitHook goes in rc.elv, which elvish only evaluates at startup. Like
+// elvishHook, it warns instead of failing silently if devbox isn't in PATH.
+const elvishInitHook = `
+if (has-external devbox) {
+  eval (devbox shellenv --config {{ quotePath .ProjectDir }} | slurp)
+} else {
+  echo 'devbox: command not found in PATH, so the devbox environment is not set up' >&2
+}
+`
+
+// elvishPromptHook wraps edit:prompt. Like elvishInitHook, it belongs in
+// rc.elv, since evaluating it twice would wrap the prompt twice.
+const elvishPromptHook = `
+use str
+var __devbox_prompt = $edit:prompt
+set edit:prompt = {
+  if (str:contains $E:DEVBOX_PATH_STACK DEVBOX_NIX_ENV_PATH_) {
+    if (has-env DEVBOX_PROMPT_INDICATOR) {
+      put $E:DEVBOX_PROMPT_INDICATOR
+    } else {
+      put '(devbox) '
+    }
+  }
+  $__devbox_prompt
+}
+`
+
+func (sh elvish) Name() string {
+	return "elvish"
+}
+
+func (sh elvish) Hook() (string, error) {
+	return elvishHook, nil
+}
+
+func (sh elvish) InitHook() (string, error) {
+	return elvishInitHook, nil
+}
+
+func (sh elvish) PromptHook() (string, error) {
+	return elvishPromptHook, nil
+}
+
+// SourceCommand evaluates the file's contents instead of using use, which
+// only loads modules from the module search path.
+func (sh elvish) SourceCommand(path string) string {
+	return "eval (slurp < " + sh.escape(path) + ")"
+}
+
+func (sh elvish) QuotePath(path string) string {
+	return sh.escape(path)
+}
+
+func (sh elvish) Export(e ShellExport) (out string) {
+	for key, value := range e {
+		if value == nil {
+			out += sh.unset(key)
+		} else {
+			out += sh.export(key, *value)
+		}
+	}
+	return out
+}
+
+func (sh elvish) Dump(env Env) (out string) {
+	for key, value := range env {
+		out += sh.export(key, value)
+	}
+	return out
+}
+
+// DumpStructured outputs env as a JSON object, which elvish can apply without
+// any shell escaping. For example, if the output is saved to env.json:
+//
+//	from-json < env.json | each {|m| keys $m | each {|k| set-env $k $m[$k] } }
+func (sh elvish) DumpStructured(env Env) string {
+	b, err := json.Marshal(env)
+	if err != nil {
+		// A map[string]string always marshals successfully.
+		panic(err)
+	}
+	return string(b)
+}
+
+func (sh elvish) HasStructuredDump() bool { return true }
+
+// Readonly returns false. Elvish can only make variables read-only in
+// its own namespaces, not in the environment.
+func (sh elvish) Readonly(key string) (string, bool) {
+	return "", false
+}
+
+func (sh elvish) export(key, value string) string {
+	return "set-env " + sh.escape(key) + " " + sh.escape(value) + "\n"
+}
+
+func (sh elvish) unset(key string) string {
+	return "unset-env " + sh.escape(key) + "\n"
+}
+
+// escape quotes str as an elvish single-quoted string. The only special
+// sequence in a single-quoted string is a doubled quote, which stands for a
+// literal single quote.
+func (sh elvish) escape(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", "''") + "'"
+}
@@ -0,0 +1,126 @@
+package shenv
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestElvishHook(t *testing.T) {
+	hook, err := Elvish.Hook()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := ExecuteHook(Elvish, hook, "/home/me/it's my project")
+	if err != nil {
+		t.Fatalf("execute hook template: %v", err)
+	}
+	if !strings.Contains(rendered, "'/home/me/it''s my project/devbox.json'") {
+		t.Errorf("hook doesn't reference the project's devbox.json:\n%s", rendered)
+	}
+	if !strings.Contains(rendered, "has-external devbox") {
+		t.Errorf("hook doesn't check that devbox is in PATH:\n%s", rendered)
+	}
+
+	// Check that the hook compiles when elvish is available. edit:* is
+	// only defined in interactive shells, so stub it out.
+	elvish, err := exec.LookPath("elvish")
+	if err != nil {
+		t.Skip("elvish not found in PATH, skipping compile check")
+	}
+	script := filepath.Join(t.TempDir(), "hook.elv")
+	stub := "var edit: = (ns [&before-readline=[]])\n"
+	if err := os.WriteFile(script, []byte(stub+rendered), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(elvish, "-compileonly", script).CombinedOutput()
+	if err != nil {
+		t.Errorf("hook doesn't compile: %v\n%s", err, out)
+	}
+}
+
+func TestElvishExport(t *testing.T) {
+	e := ShellExport{}
+	e.Add("GREETING", "it's a\nnew line")
+	e.Remove("OLD")
+
+	got := Elvish.Export(e)
+	for _, want := range []string{
+		"set-env 'GREETING' 'it''s a\nnew line'\n",
+		"unset-env 'OLD'\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Export() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestElvishDumpStructured(t *testing.T) {
+	env := Env{"GREETING": "it's a \"new\"\nline with $HOME", "EMPTY": ""}
+	got := Elvish.DumpStructured(env)
+
+	var parsed Env
+	if err := json.Unmarshal([]byte(got), &parsed); err != nil {
+		t.Fatalf("DumpStructured() = %q isn't valid JSON: %v", got, err)
+	}
+	if !maps.Equal(parsed, env) {
+		t.Errorf("DumpStructured() round trip = %q, want %q", parsed, env)
+	}
+}
+
+// FuzzElvishEscape checks that an elvish single-quoted string parser gets
+// back the original string from escape. It also runs elvish when it's
+// available, since the parser is only a reference implementation.
+func FuzzElvishEscape(f *testing.F) {
+	for _, str := range []string{"", "plain", "it's", "''", "multi\nline", `$x \n`, "\xff"} {
+		f.Add(str)
+	}
+	elvishPath, _ := exec.LookPath("elvish")
+	f.Fuzz(func(t *testing.T, str string) {
+		quoted := elvish{}.escape(str)
+		got, ok := unquoteElvish(quoted)
+		if !ok {
+			t.Fatalf("escape(%q) = %q, which isn't a single quoted string", str, quoted)
+		}
+		if got != str {
+			t.Fatalf("unquote(escape(%q)) = %q", str, got)
+		}
+
+		if elvishPath == "" || strings.IndexByte(str, 0) != -1 {
+			return
+		}
+		out, err := exec.Command(elvishPath, "-norc", "-c", "print "+quoted).Output()
+		if err != nil {
+			t.Fatalf("elvish -c %q: %v", "print "+quoted, err)
+		}
+		if string(out) != str {
+			t.Fatalf("elvish printed %q for %q, want %q", out, quoted, str)
+		}
+	})
+}
+
+// unquoteElvish parses s as a single elvish single-quoted string, where a
+// doubled quote stands for a literal quote. It reports false if s has
+// anything other than the quoted string.
+func unquoteElvish(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		return "", false
+	}
+	var b strings.Builder
+	body := s[1 : len(s)-1]
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\'' {
+			// A lone quote would end the string early.
+			if i+1 == len(body) || body[i+1] != '\'' {
+				return "", false
+			}
+			i++
+		}
+		b.WriteByte(body[i])
+	}
+	return b.String(), true
+}
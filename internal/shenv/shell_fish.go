@@ -2,7 +2,7 @@
 
 import (
 	"fmt"
-	"strings"
+	"path/filepath"
 )
 
 type fish struct{}
@@ -12,14 +12,58 @@ type fish struct{
 
 const fishHook = `
 function __devbox_shellenv_eval --on-event fish_prompt;
-  devbox shellenv --config {{ .ProjectDir }} | source;
+  devbox shellenv --config {{ quotePath .ProjectDir }} | source;
 end;
 `
 
+// fishPromptHook wraps fish_prompt, keeping a copy of the original so that
+// evaluating the hook again doesn't add the indicator twice. It restores
+// $status before calling the original prompt, which may display it.
+const fishPromptHook = `
+functions -q __devbox_fish_prompt; or functions -c fish_prompt __devbox_fish_prompt;
+function __devbox_return; return $argv[1]; end;
+function fish_prompt;
+  set -l last_status $status;
+  if string match -q '*DEVBOX_NIX_ENV_PATH_*' -- "$DEVBOX_PATH_STACK";
+    if set -q DEVBOX_PROMPT_INDICATOR;
+      printf '%s' "$DEVBOX_PROMPT_INDICATOR";
+    else;
+      printf '(devbox) ';
+    end;
+  end;
+  __devbox_return $last_status;
+  __devbox_fish_prompt;
+end;
+`
+
+const fishInitHook = `
+devbox shellenv --config {{ quotePath .ProjectDir }} | source;
+`
+
+func (sh fish) Name() string {
+	return "fish"
+}
+
 func (sh fish) Hook() (string, error) {
 	return fishHook, nil
 }
 
+func (sh fish) InitHook() (string, error) {
+	return fishInitHook, nil
+}
+
+func (sh fish) PromptHook() (string, error) {
+	return fishPromptHook, nil
+}
+
+func (sh fish) SourceCommand(path string) string {
+	return "source " + sh.escape(path)
+}
+
+func (sh fish) QuotePath(path string) string {
+	return sh.escape(path)
+}
+
 func (sh fish) Export(e ShellExport) (out string) {
 	for key, value := range e {
 		if value == nil {
@@ -38,10 +82,21 @@ func (sh fish) Dump(env Env) (out string) {
 	return out
 }
 
+func (sh fish) DumpStructured(env Env) string {
+	return sh.Dump(env)
+}
+
+func (sh fish) HasStructuredDump() bool { return false }
+
+// Readonly returns false, since fish has no read-only variables.
+func (sh fish) Readonly(key string) (string, bool) {
+	return "", false
+}
+
 func (sh fish) export(key, value string) string {
 	if key == "PATH" {
 		command := "set -x -g PATH"
-		for _, path := range strings.Split(value, ":") {
+		for _, path := range filepath.SplitList(value) {
 			command += " " + sh.escape(path)
 		}
 		return command + ";"
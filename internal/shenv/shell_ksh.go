@@ -8,7 +8,7 @@ type ksh struct{
 // um, this is ChatGPT writing it. I need to verify and test
 const kshHook = `
 _devbox_hook() {
-  eval "$(devbox shellenv --config {{ .ProjectDir }})";
+  eval "$(devbox shellenv --config {{ quotePath .ProjectDir }})";
 }
 if [[ "$(typeset -f precmd)" != *"_devbox_hook"* ]]; then
   function precmd {
@@ -17,14 +17,47 @@ function precmd {
 fi
 `
 
+func (sh ksh) Name() string {
+	return "ksh"
+}
+
 func (sh ksh) Hook() (string, error) {
 	return kshHook, nil
 }
 
+func (sh ksh) InitHook() (string, error) {
+	return Posix.InitHook()
+}
+
+// PromptHook uses the POSIX prompt hook, since ksh also expands parameters in
+// PS1.
+func (sh ksh) PromptHook() (string, error) {
+	return Posix.PromptHook()
+}
+
+func (sh ksh) SourceCommand(path string) string {
+	return Posix.SourceCommand(path)
+}
+
+func (sh ksh) QuotePath(path string) string {
+	return Posix.QuotePath(path)
+}
+
+// Export uses POSIX syntax, since not every ksh supports $'...' strings.
 func (sh ksh) Export(e ShellExport) (out string) {
-	panic("not implemented")
+	return Posix.Export(e)
 }
 
 func (sh ksh) Dump(env Env) (out string) {
-	panic("not implemented")
+	return Posix.Dump(env)
+}
+
+func (sh ksh) Readonly(key string) (string, bool) {
+	return Posix.Readonly(key)
 }
+
+func (sh ksh) DumpStructured(env Env) string {
+	return sh.Dump(env)
+}
+
+func (sh ksh) HasStructuredDump() bool { return false }
@@ -1,5 +1,7 @@
 package shenv
 
+import "strings"
+
 type posix struct{}
 
 // Posix adds support for posix-compatible shells
@@ -12,7 +14,7 @@ type posix struct{
 _devbox_hook() {
   local previous_exit_status=$?
   trap : INT
-  eval "$(devbox shellenv --config {{ .ProjectDir }})"
+  eval "$(devbox shellenv --config {{ quotePath .ProjectDir }})"
   trap - INT
   return $previous_exit_status
 }
@@ -21,14 +23,85 @@ type posix struct{
 fi
 `
 
+// posixPromptHook relies on the shell expanding parameters in PS1 each time it
+// prints the prompt, since POSIX shells don't have prompt hooks. Parameter
+// expansion can't check for a substring, so the indicator shows whenever
+// DEVBOX_PATH_STACK is set.
+const posixPromptHook = `
+case "$PS1" in
+  *DEVBOX_PROMPT_INDICATOR*) ;;
+  *) PS1='${DEVBOX_PATH_STACK:+${DEVBOX_PROMPT_INDICATOR-(devbox) }}'"$PS1" ;;
+esac
+`
+
+const posixInitHook = `
+eval "$(devbox shellenv --config {{ quotePath .ProjectDir }})"
+`
+
+func (sh posix) Name() string {
+	return "posix"
+}
+
 func (sh posix) Hook() (string, error) {
 	return posixHook, nil
 }
 
+func (sh posix) InitHook() (string, error) {
+	return posixInitHook, nil
+}
+
+func (sh posix) PromptHook() (string, error) {
+	return posixPromptHook, nil
+}
+
+// SourceCommand uses the dot command, since source isn't in POSIX.
+func (sh posix) SourceCommand(path string) string {
+	return ". " + sh.escape(path)
+}
+
+func (sh posix) QuotePath(path string) string {
+	return sh.escape(path)
+}
+
 func (sh posix) Export(e ShellExport) (out string) {
-	panic("not implemented")
+	for key, value := range e {
+		if value == nil {
+			out += sh.unset(key)
+		} else {
+			out += sh.export(key, *value)
+		}
+	}
+	return out
 }
 
 func (sh posix) Dump(env Env) (out string) {
-	panic("not implemented")
+	for key, value := range env {
+		out += sh.export(key, value)
+	}
+	return out
+}
+
+func (sh posix) DumpStructured(env Env) string {
+	return sh.Dump(env)
+}
+
+func (sh posix) HasStructuredDump() bool { return false }
+
+func (sh posix) export(key, value string) string {
+	return "export " + key + "=" + sh.escape(value) + ";"
+}
+
+func (sh posix) Readonly(key string) (string, bool) {
+	return "readonly " + key + ";", true
+}
+
+func (sh posix) unset(key string) string {
+	return "unset " + key + ";"
+}
+
+// escape single-quotes str. Unlike $'...' strings, single quotes work the
+// same in every POSIX shell; a literal quote has to end the string, add an
+// escaped quote and start a new string.
+func (sh posix) escape(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", `'\''`) + "'"
 }
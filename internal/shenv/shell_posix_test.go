@@ -0,0 +1,28 @@
+package shenv
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestPosixExport(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+
+	values := []string{"", "plain", "it's", `"double"`, "$HOME `id`", "back\\slash", "multi\nline"}
+	for _, value := range values {
+		export := ShellExport{}
+		export.Add("DEVBOX_TEST_VALUE", value)
+		script := Posix.Export(export) + ` printf %s "$DEVBOX_TEST_VALUE"`
+		out, err := exec.Command(sh, "-c", script).Output()
+		if err != nil {
+			t.Errorf("sh -c %q: %v", script, err)
+			continue
+		}
+		if string(out) != value {
+			t.Errorf("got value %q after export, want %q", out, value)
+		}
+	}
+}
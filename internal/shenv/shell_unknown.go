@@ -11,10 +11,34 @@ type unknown struct{
 Please exit and re-enter shell after making any changes that may affect the devbox generated environment.\n"
 `
 
+func (sh unknown) Name() string {
+	return "unknown"
+}
+
 func (sh unknown) Hook() (string, error) {
 	return unknownHook, nil
 }
 
+// InitHook returns the same warning as Hook, since devbox can't set up the
+// environment in a shell it doesn't know how to evaluate.
+func (sh unknown) InitHook() (string, error) {
+	return unknownHook, nil
+}
+
+// PromptHook is empty, since devbox doesn't know how to change the prompt of
+// an unknown shell.
+func (sh unknown) PromptHook() (string, error) {
+	return "", nil
+}
+
+func (sh unknown) SourceCommand(path string) string {
+	panic("not implemented")
+}
+
+func (sh unknown) QuotePath(path string) string {
+	panic("not implemented")
+}
+
 func (sh unknown) Export(e ShellExport) (out string) {
 	panic("not implemented")
 }
@@ -22,3 +46,17 @@ func (sh unknown) Export(e ShellExport) (out string) {
 func (sh unknown) Dump(env Env) (out string) {
 	panic("not implemented")
 }
+
+func (sh unknown) Readonly(key string) (string, bool) {
+	return "", false
+}
+
+func (sh unknown) DumpStructured(env Env) string {
+	panic("not implemented")
+}
+
+// HasStructuredDump is false, since devbox can't dump the environment for an
+// unknown shell at all.
+func (sh unknown) HasStructuredDump() bool {
+	return false
+}
@@ -9,7 +9,7 @@ type zsh struct{
 const zshHook = `
 _devbox_hook() {
   trap -- '' SIGINT;
-  eval "$(devbox shellenv --config {{ .ProjectDir }})";
+  eval "$(devbox shellenv --config {{ quotePath .ProjectDir }})";
   trap - SIGINT;
 }
 typeset -ag precmd_functions;
@@ -18,10 +18,53 @@ type zsh struct{
 fi
 `
 
+// zshPromptHook works the same way as bashPromptHook, but it's added to the
+// end of precmd_functions.
+const zshPromptHook = `
+_devbox_prompt() {
+  local previous_exit_status=$?;
+  PS1="${PS1#"${_devbox_prompt_indicator:-}"}";
+  _devbox_prompt_indicator="";
+  if [[ "${DEVBOX_PATH_STACK:-}" == *DEVBOX_NIX_ENV_PATH_* ]]; then
+    _devbox_prompt_indicator="${DEVBOX_PROMPT_INDICATOR-(devbox) }";
+    PS1="$_devbox_prompt_indicator$PS1";
+  fi
+  return $previous_exit_status;
+}
+typeset -ag precmd_functions;
+if [[ -z "${precmd_functions[(r)_devbox_prompt]+1}" ]]; then
+  precmd_functions=( ${precmd_functions[@]} _devbox_prompt )
+fi
+`
+
+const zshInitHook = `
+eval "$(devbox shellenv --config {{ quotePath .ProjectDir }})";
+`
+
+func (sh zsh) Name() string {
+	return "zsh"
+}
+
 func (sh zsh) Hook() (string, error) {
 	return zshHook, nil
 }
 
+func (sh zsh) InitHook() (string, error) {
+	return zshInitHook, nil
+}
+
+func (sh zsh) PromptHook() (string, error) {
+	return zshPromptHook, nil
+}
+
+func (sh zsh) SourceCommand(path string) string {
+	return "source " + sh.escape(path)
+}
+
+func (sh zsh) QuotePath(path string) string {
+	return sh.escape(path)
+}
+
 func (sh zsh) Export(e ShellExport) (out string) {
 	for key, value := range e {
 		if value == nil {
@@ -40,10 +83,20 @@ func (sh zsh) Dump(env Env) (out string) {
 	return out
 }
 
+func (sh zsh) DumpStructured(env Env) string {
+	return sh.Dump(env)
+}
+
+func (sh zsh) HasStructuredDump() bool { return false }
+
 func (sh zsh) export(key, value string) string {
 	return "export " + sh.escape(key) + "=" + sh.escape(value) + ";"
 }
 
+func (sh zsh) Readonly(key string) (string, bool) {
+	return "readonly " + sh.escape(key) + ";", true
+}
+
 func (sh zsh) unset(key string) string {
 	return "unset " + sh.escape(key) + ";"
 }
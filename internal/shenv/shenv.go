@@ -1,18 +1,66 @@
 package shenv
 
+import (
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+)
+
 type Env map[string]string
 
 // Shell is the interface that represents the interaction with the host shell.
 type Shell interface {
+	// Name is the name of the shell's executable, such as "bash". It's the
+	// name that [ShellByName] uses to find the shell.
+	Name() string
+
 	// Hook is the string that gets evaluated into the host shell config and
 	// setups direnv as a prompt hook.
 	Hook() (string, error)
 
+	// InitHook is the string that gets evaluated into the host shell config
+	// to set up the environment once when the shell starts, such as from the
+	// top of an rcfile. Unlike Hook, it doesn't run again before each prompt,
+	// so it's the place for setup that's too expensive to repeat.
+	InitHook() (string, error)
+
+	// PromptHook is the string that gets evaluated into the host shell
+	// config to prefix the prompt with an indicator while a devbox
+	// environment is active. The indicator is $DEVBOX_PROMPT_INDICATOR, or
+	// "(devbox) " if it's unset.
+	PromptHook() (string, error)
+
+	// SourceCommand returns the command that applies the shell commands in
+	// the file at path to the host shell, such as `source path` in bash.
+	// It's what users run on the file that `devbox shellenv --source-file`
+	// writes.
+	SourceCommand(path string) string
+
+	// QuotePath quotes path so that the shell reads it as a single literal
+	// word, even if it has spaces, quotes or $. Hooks call it as the
+	// quotePath template function (see [ExecuteHook]).
+	QuotePath(path string) string
+
 	// Export outputs the ShellExport as an evaluatable string on the host shell
 	Export(e ShellExport) string
 
 	// Dump outputs and evaluatable string that sets the env in the host shell
 	Dump(env Env) string
+
+	// Readonly returns the statement that marks the exported variable key
+	// as read-only, to follow its export. It returns false if the shell has
+	// no read-only environment variables, as in fish and elvish.
+	Readonly(key string) (string, bool)
+
+	// DumpStructured outputs the env in a structured format that the host
+	// shell can parse natively, avoiding the need to escape each variable.
+	// Shells without a structured format return the same output as Dump.
+	DumpStructured(env Env) string
+
+	// HasStructuredDump reports whether DumpStructured has a format of its
+	// own instead of returning the same output as Dump.
+	HasStructuredDump() bool
 }
 
 // ShellExport represents environment variables to add and remove on the host
@@ -29,21 +77,71 @@ func (e ShellExport) Remove(key string) {
 	e[key] = nil
 }
 
+// shells are the supported shells, keyed by name.
+var shells = map[string]Shell{}
+
+func init() {
+	for _, sh := range []Shell{Bash, Elvish, Fish, Ksh, Posix, Zsh} {
+		shells[sh.Name()] = sh
+	}
+}
+
+// ShellByName returns the supported shell with the given name.
+func ShellByName(name string) (Shell, bool) {
+	sh, ok := shells[name]
+	return sh, ok
+}
+
+// ShellNames returns the sorted names of all supported shells, such as for
+// completing a --shell flag.
+func ShellNames() []string {
+	return slices.Sorted(maps.Keys(shells))
+}
+
+// ShellInfo describes a supported shell and the optional [Shell] features it
+// has, so that callers can tell users about partial support. Every shell
+// implements the rest of the interface.
+type ShellInfo struct {
+	Name string `json:"name"`
+
+	DumpStructured bool `json:"dump_structured"`
+}
+
+// SupportedShells returns the features of each supported shell, sorted by
+// name.
+func SupportedShells() []ShellInfo {
+	infos := make([]ShellInfo, 0, len(shells))
+	for _, name := range ShellNames() {
+		infos = append(infos, ShellInfo{
+			Name:           name,
+			DumpStructured: shells[name].HasStructuredDump(),
+		})
+	}
+	return infos
+}
+
+// ExecuteHook renders hook, as returned by the Hook, InitHook or PromptHook
+// methods of sh, for the project in projectDir. Hooks are text/template
+// templates that can use {{ .ProjectDir }} and quote it with quotePath.
+func ExecuteHook(sh Shell, hook, projectDir string) (string, error) {
+	tmpl, err := template.New(sh.Name()).
+		Funcs(template.FuncMap{"quotePath": sh.QuotePath}).
+		Parse(hook)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, struct{ ProjectDir string }{projectDir}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
 // DetectShell returns a Shell instance from the given shell name
 // TODO: use a single common "enum" for both shenv and DevboxShell
 func DetectShell(target string) Shell {
-	switch target {
-	case "bash":
-		return Bash
-	case "fish":
-		return Fish
-	case "ksh":
-		return Ksh
-	case "posix":
-		return Posix
-	case "zsh":
-		return Zsh
-	default:
-		return UnknownSh
+	if sh, ok := ShellByName(target); ok {
+		return sh
 	}
+	return UnknownSh
 }
@@ -0,0 +1,259 @@
+package shenv
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestShellByName(t *testing.T) {
+	for _, name := range ShellNames() {
+		sh, ok := ShellByName(name)
+		if !ok {
+			t.Errorf("ShellByName(%q) not found", name)
+			continue
+		}
+		if sh.Name() != name {
+			t.Errorf("ShellByName(%q).Name() = %q", name, sh.Name())
+		}
+	}
+	if !slices.Contains(ShellNames(), "elvish") {
+		t.Errorf("ShellNames() = %v, want it to include elvish", ShellNames())
+	}
+	if sh, ok := ShellByName("unknown"); ok {
+		t.Errorf("ShellByName(%q) = %v, want no shell", "unknown", sh)
+	}
+	if got := DetectShell("nu"); got != UnknownSh {
+		t.Errorf("DetectShell(%q) = %v, want UnknownSh", "nu", got)
+	}
+}
+
+func TestSupportedShells(t *testing.T) {
+	infos := SupportedShells()
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name)
+		sh, _ := ShellByName(info.Name)
+		env := Env{"GREETING": "hello"}
+		if structured := sh.DumpStructured(env) != sh.Dump(env); structured != info.DumpStructured {
+			t.Errorf("%s HasStructuredDump() = %v, but DumpStructured() output differs from Dump(): %v",
+				info.Name, info.DumpStructured, structured)
+		}
+	}
+	if !slices.Equal(names, ShellNames()) {
+		t.Errorf("SupportedShells() names = %v, want %v", names, ShellNames())
+	}
+	if UnknownSh.HasStructuredDump() {
+		t.Errorf("UnknownSh.HasStructuredDump() = true, want false")
+	}
+}
+
+func TestInitHook(t *testing.T) {
+	// Each of these registers a hook that runs before every prompt.
+	promptHooks := []string{"PROMPT_COMMAND", "precmd", "before-readline", "fish_prompt"}
+	for _, name := range ShellNames() {
+		sh, _ := ShellByName(name)
+		hook, err := sh.InitHook()
+		if err != nil {
+			t.Errorf("%s InitHook() error: %v", name, err)
+			continue
+		}
+		dir := "/home/me/my projects/app"
+		rendered, err := ExecuteHook(sh, hook, dir)
+		if err != nil {
+			t.Errorf("%s InitHook() isn't a valid hook template: %v", name, err)
+			continue
+		}
+		if !strings.Contains(rendered, "devbox shellenv --config "+sh.QuotePath(dir)) {
+			t.Errorf("%s InitHook() = %q, want it to evaluate devbox shellenv for the project", name, rendered)
+		}
+		for _, prompt := range promptHooks {
+			if strings.Contains(hook, prompt) {
+				t.Errorf("%s InitHook() = %q, want it to run once instead of with %s", name, hook, prompt)
+			}
+		}
+	}
+}
+
+func TestPromptHook(t *testing.T) {
+	for _, name := range ShellNames() {
+		sh, _ := ShellByName(name)
+		hook, err := sh.PromptHook()
+		if err != nil {
+			t.Errorf("%s PromptHook() error: %v", name, err)
+			continue
+		}
+		if !strings.Contains(hook, "DEVBOX_PROMPT_INDICATOR") || !strings.Contains(hook, "(devbox) ") {
+			t.Errorf("%s PromptHook() = %q, want it to show $DEVBOX_PROMPT_INDICATOR or (devbox)", name, hook)
+		}
+	}
+}
+
+func TestPromptHookRun(t *testing.T) {
+	// Run the prompt hook (twice, to check that it doesn't add the
+	// indicator again) and print the prompt that the shell would show.
+	tests := []struct {
+		shell  Shell
+		prompt string
+	}{
+		{Bash, `_devbox_prompt; _devbox_prompt; printf '%s' "$PS1"`},
+		{Posix, `printf '%s' "$(eval "printf '%s' \"$PS1\"")"`},
+	}
+	for _, test := range tests {
+		t.Run(test.shell.Name(), func(t *testing.T) {
+			bin := map[string]string{"bash": "bash", "posix": "dash"}[test.shell.Name()]
+			path, err := exec.LookPath(bin)
+			if err != nil {
+				t.Skipf("%s not found in PATH", bin)
+			}
+			hook, err := test.shell.PromptHook()
+			if err != nil {
+				t.Fatal(err)
+			}
+			script := "PS1='$ '\n" + hook + hook + test.prompt
+
+			run := func(env ...string) string {
+				t.Helper()
+				cmd := exec.Command(path, "-c", script)
+				cmd.Env = env
+				out, err := cmd.CombinedOutput()
+				if err != nil {
+					t.Fatalf("run prompt hook: %v\n%s", err, out)
+				}
+				return string(out)
+			}
+			stack := "DEVBOX_PATH_STACK=DEVBOX_NIX_ENV_PATH_abc:DEVBOX_INIT_PATH"
+			if got := run(stack); got != "(devbox) $ " {
+				t.Errorf("got prompt %q in a devbox environment, want %q", got, "(devbox) $ ")
+			}
+			if got := run(stack, "DEVBOX_PROMPT_INDICATOR=[box] "); got != "[box] $ " {
+				t.Errorf("got prompt %q with a custom indicator, want %q", got, "[box] $ ")
+			}
+			if got := run(); got != "$ " {
+				t.Errorf("got prompt %q outside of a devbox environment, want %q", got, "$ ")
+			}
+		})
+	}
+}
+
+func TestSourceCommand(t *testing.T) {
+	path := "/tmp/dir with 'quotes' and $vars/shellenv.sh"
+	for _, name := range ShellNames() {
+		sh, _ := ShellByName(name)
+		got := sh.SourceCommand(path)
+		if !strings.Contains(got, "shellenv.sh") {
+			t.Errorf("%s SourceCommand(%q) = %q, want it to include the path", name, path, got)
+		}
+	}
+	if got, want := Elvish.SourceCommand("/tmp/it's.elv"), `eval (slurp < '/tmp/it''s.elv')`; got != want {
+		t.Errorf("elvish SourceCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestSourceCommandRun(t *testing.T) {
+	tests := []struct {
+		shell Shell
+		bin   string
+	}{
+		{Bash, "bash"},
+		{Posix, "dash"},
+	}
+	for _, test := range tests {
+		t.Run(test.shell.Name(), func(t *testing.T) {
+			bin, err := exec.LookPath(test.bin)
+			if err != nil {
+				t.Skipf("%s not found in PATH", test.bin)
+			}
+			dir := filepath.Join(t.TempDir(), "dir with 'quotes' and $vars")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "shellenv.sh")
+			if err := os.WriteFile(path, []byte("SOURCED=yes\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			script := test.shell.SourceCommand(path) + `; printf '%s' "$SOURCED"`
+			out, err := exec.Command(bin, "-c", script).CombinedOutput()
+			if err != nil {
+				t.Fatalf("run %q: %v\n%s", script, err, out)
+			}
+			if string(out) != "yes" {
+				t.Errorf("got %q after running %q, want %q", out, script, "yes")
+			}
+		})
+	}
+}
+
+func TestQuotePathRun(t *testing.T) {
+	tests := []struct {
+		shell Shell
+		bin   string
+	}{
+		{Bash, "bash"},
+		{Zsh, "zsh"},
+		{Posix, "dash"},
+		{Fish, "fish"},
+	}
+	for _, path := range []string{"/home/me/my projects/app", `/tmp/it's "$HOME"`} {
+		for _, test := range tests {
+			t.Run(test.shell.Name(), func(t *testing.T) {
+				bin, err := exec.LookPath(test.bin)
+				if err != nil {
+					t.Skipf("%s not found in PATH", test.bin)
+				}
+				script := "printf '%s' " + test.shell.QuotePath(path)
+				out, err := exec.Command(bin, "-c", script).CombinedOutput()
+				if err != nil {
+					t.Fatalf("run %q: %v\n%s", script, err, out)
+				}
+				if string(out) != path {
+					t.Errorf("got %q after running %q, want %q", out, script, path)
+				}
+			})
+		}
+	}
+}
+
+func TestReadonlyRun(t *testing.T) {
+	tests := []struct {
+		shell Shell
+		bin   string
+	}{
+		{Bash, "bash"},
+		{Zsh, "zsh"},
+		{Posix, "dash"},
+	}
+	for _, test := range tests {
+		t.Run(test.shell.Name(), func(t *testing.T) {
+			bin, err := exec.LookPath(test.bin)
+			if err != nil {
+				t.Skipf("%s not found in PATH", test.bin)
+			}
+			export := ShellExport{}
+			export.Add("DEVBOX_TEST_VALUE", "kept")
+			readonly, ok := test.shell.Readonly("DEVBOX_TEST_VALUE")
+			if !ok {
+				t.Fatalf("%s Readonly() isn't supported", test.shell.Name())
+			}
+			script := test.shell.Export(export) + "\n" + readonly + "\n" +
+				`if (DEVBOX_TEST_VALUE=changed) 2>/dev/null; then printf reassigned; ` +
+				`else printf '%s' "$DEVBOX_TEST_VALUE"; fi`
+			out, err := exec.Command(bin, "-c", script).CombinedOutput()
+			if err != nil {
+				t.Fatalf("run %q: %v\n%s", script, err, out)
+			}
+			if string(out) != "kept" {
+				t.Errorf("got %q after reassigning a read-only variable, want %q", out, "kept")
+			}
+		})
+	}
+	for _, sh := range []Shell{Fish, Elvish} {
+		if _, ok := sh.Readonly("DEVBOX_TEST_VALUE"); ok {
+			t.Errorf("%s Readonly() is supported, but the shell has no read-only environment variables", sh.Name())
+		}
+	}
+}
@@ -7,11 +7,37 @@
 	"os"
 	"path/filepath"
 
+	"go.jetpack.io/devbox/internal/boxcli/usererr"
 	"go.jetpack.io/devbox/internal/envir"
 )
 
-func DataSubpath(subpath string) string {
-	return filepath.Join(dataDir(), subpath)
+// DataSubpath returns subpath joined to the XDG data directory. See
+// [DataDir] for how the directory is chosen.
+func DataSubpath(subpath string) (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, subpath), nil
+}
+
+// DataDir returns the XDG data directory. It mirrors the shell expansion
+// ${XDG_DATA_HOME:-$HOME/.local/share} so that paths devbox computes match
+// the ones in the shell scripts it generates. Like the shell, it treats an
+// empty variable the same as an unset one. It returns an error instead of
+// guessing a directory if neither variable is set.
+func DataDir() (string, error) {
+	if dir := os.Getenv(envir.XDGDataHome); dir != "" {
+		return dir, nil
+	}
+	if home := os.Getenv(envir.Home); home != "" {
+		return filepath.Join(home, ".local/share"), nil
+	}
+	return "", usererr.New(
+		"Can't find a directory for devbox data because neither $%s nor $%s is set. "+
+			"Set one of them and try again.",
+		envir.XDGDataHome, envir.Home,
+	)
 }
 
 func ConfigSubpath(subpath string) string {
@@ -26,7 +52,6 @@ func StateSubpath(subpath string) string {
 	return filepath.Join(stateDir(), subpath)
 }
 
-func dataDir() string   { return resolveDir(envir.XDGDataHome, ".local/share") }
 func configDir() string { return resolveDir(envir.XDGConfigHome, ".config") }
 func cacheDir() string  { return resolveDir(envir.XDGCacheHome, ".cache") }
 func stateDir() string  { return resolveDir(envir.XDGStateHome, ".local/state") }
@@ -0,0 +1,48 @@
+// Copyright 2024 Jetify Inc. and contributors. All rights reserved.
+// Use of this source code is governed by the license in the LICENSE file.
+
+package xdg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.jetpack.io/devbox/internal/envir"
+)
+
+func TestDataSubpath(t *testing.T) {
+	tests := []struct {
+		name        string
+		xdgDataHome string
+		home        string
+		want        string
+	}{
+		{name: "XDGSet", xdgDataHome: "/data", home: "/home/user", want: "/data/devbox/global"},
+		{name: "XDGUnset", home: "/home/user", want: "/home/user/.local/share/devbox/global"},
+		{name: "XDGSetHomeUnset", xdgDataHome: "/data", want: "/data/devbox/global"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(envir.XDGDataHome, test.xdgDataHome)
+			t.Setenv(envir.Home, test.home)
+
+			got, err := DataSubpath("devbox/global")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != filepath.FromSlash(test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDataSubpathBothUnset(t *testing.T) {
+	t.Setenv(envir.XDGDataHome, "")
+	t.Setenv(envir.Home, "")
+
+	got, err := DataSubpath("devbox/global")
+	if err == nil {
+		t.Errorf("got path %q with XDG_DATA_HOME and HOME unset, want error", got)
+	}
+}